@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// appRoleCmd represents the approle command
+var appRoleCmd = &cobra.Command{
+	Use:   "approle",
+	Short: "Manage the AppRoles nodes of a cluster log in with.",
+}
+
+var appRoleSecretIDCmd = &cobra.Command{
+	Use:   "secret-id [cluster ID] [role]",
+	Short: "Create a secret id for the AppRole of a node class. Prints the role id and the secret id.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper approle secret-id [cluster ID] [role]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cidrs, err := cmd.PersistentFlags().GetStringSlice(kubernetes.FlagAppRoleCIDRs)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagAppRoleCIDRs, err)
+		}
+
+		roleID, secretID, err := k.AppRoleSecretID(args[1], cidrs)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("role_id   %s\n", roleID)
+		fmt.Printf("secret_id %s\n", secretID)
+	},
+}
+
+func init() {
+	appRoleSecretIDCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	appRoleSecretIDCmd.PersistentFlags().StringSlice(kubernetes.FlagAppRoleCIDRs, []string{}, "CIDR blocks the secret id can be used from, e.g. '10.0.1.5/32'")
+
+	appRoleCmd.AddCommand(appRoleSecretIDCmd)
+	RootCmd.AddCommand(appRoleCmd)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// Exit code of audit-config if drift has been found
+const exitCodeDrift = 2
+
+// auditConfigCmd represents the audit-config command
+var auditConfigCmd = &cobra.Command{
+	Use:   "audit-config [cluster ID]",
+	Short: "Report PKI roles and policies that differ from what setup would write. Exits non-zero on drift.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper audit-config [cluster ID]")
+		}
+
+		v, err := vault.NewClient(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		if err := setFlagsKubernetes(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsBootstrap(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsNodeIdentity(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsPKIKey(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+
+		audit, err := k.Audit()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		format, err := cmd.PersistentFlags().GetString(kubernetes.FlagAuditFormat)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagAuditFormat, err)
+		}
+		switch format {
+		case kubernetes.PlanFormatHuman:
+			fmt.Print(audit.String())
+		case kubernetes.PlanFormatJSON:
+			dat, err := audit.JSON()
+			if err != nil {
+				log.Fatalf("error converting audit to JSON: %v", err)
+			}
+			fmt.Println(string(dat))
+		default:
+			log.Fatalf("unknown format '%s'", format)
+		}
+
+		if audit.HasDrift() {
+			os.Exit(exitCodeDrift)
+		}
+	},
+}
+
+func init() {
+	auditConfigCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityCA, time.Hour*24*365*20, "Maxium validity for CA certificates")
+	auditConfigCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityAdmin, time.Hour*24*365, "Maxium validity for admin certificates")
+	auditConfigCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityComponents, time.Hour*24*30, "Maxium validity for component certificates")
+
+	auditConfigCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	auditConfigCmd.PersistentFlags().StringSlice(kubernetes.FlagK8sRoles, []string{}, "Additional component role of the k8s PKI and the class allowed to sign it, e.g. 'metrics-server=worker'")
+
+	auditConfigCmd.PersistentFlags().String(kubernetes.FlagBootstrap, kubernetes.BootstrapInitToken, "How nodes get their first token. [init-token|approle]")
+	auditConfigCmd.PersistentFlags().Int(kubernetes.FlagAppRoleSecretIDNumUses, 1, "Number of times an AppRole secret id can be used, 0 for unlimited")
+	auditConfigCmd.PersistentFlags().Duration(kubernetes.FlagAppRoleSecretIDTTL, 0, "Validity of AppRole secret ids, 0 for no expiry")
+	auditConfigCmd.PersistentFlags().Bool(kubernetes.FlagNodeIdentity, false, "Only allow nodes added with 'node add' to sign kubelet certificates, for their own name")
+	auditConfigCmd.PersistentFlags().String(kubernetes.FlagPKIKeyType, kubernetes.PKIKeyTypeRSA, "Type of keys the PKI roles accept in CSRs, unset roles are left alone. [rsa|ec|ed25519|any]")
+	auditConfigCmd.PersistentFlags().Int(kubernetes.FlagPKIKeyBits, 2048, "Minimum bits of RSA keys, or EC curve the PKI roles accept (Default to 256 for EC keys)")
+
+	auditConfigCmd.PersistentFlags().String(kubernetes.FlagAuditFormat, kubernetes.PlanFormatHuman, "Output format of the report. [human|json]")
+
+	RootCmd.AddCommand(auditConfigCmd)
+}
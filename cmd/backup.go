@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup [cluster ID] [file]",
+	Short: "Write an encrypted backup of the mounts, CAs, roles, policies, token roles and secrets of a cluster.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper backup [cluster ID] [file]")
+		}
+
+		e, err := backupEncryption(cmd, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		v, err := vault.NewClient(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		b, err := k.Backup()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		f, err := os.OpenFile(args[1], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			log.Fatalf("error creating backup file: %v", err)
+		}
+		if err := b.Write(f, e); err != nil {
+			f.Close()
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatalf("error writing backup file: %v", err)
+		}
+
+		log.Infof("Backup of cluster '%s' written to '%s'", args[0], args[1])
+	},
+}
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore [cluster ID] [file]",
+	Short: "Restore a cluster from a backup, run setup afterwards to recreate auth backends and init tokens.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper restore [cluster ID] [file]")
+		}
+
+		e, err := backupEncryption(cmd, false)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		f, err := os.Open(args[1])
+		if err != nil {
+			log.Fatalf("error opening backup file: %v", err)
+		}
+		b, err := kubernetes.ReadBackup(f, e)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		v, err := vault.NewClient(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		allowNewCA, err := cmd.PersistentFlags().GetBool(kubernetes.FlagRestoreAllowNewCA)
+		if err != nil {
+			log.Fatalf("error parsing %s '%t': %s", kubernetes.FlagRestoreAllowNewCA, allowNewCA, err)
+		}
+
+		result, err := k.Restore(b, allowNewCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, path := range result.NewCAs {
+			log.Warnf("CA of '%s' has not been exported, setup will generate a new CA", path)
+		}
+	},
+}
+
+func init() {
+	backupCmd.PersistentFlags().String(kubernetes.FlagBackupPassphraseFile, "", "File containing the passphrase to encrypt the backup with")
+	backupCmd.PersistentFlags().String(kubernetes.FlagBackupPGPRecipient, "", "File containing the armored public PGP keys to encrypt the backup for")
+
+	restoreCmd.PersistentFlags().String(kubernetes.FlagBackupPassphraseFile, "", "File containing the passphrase of the backup or of the PGP key")
+	restoreCmd.PersistentFlags().String(kubernetes.FlagBackupPGPKey, "", "File containing the armored private PGP key to decrypt the backup with")
+	restoreCmd.PersistentFlags().Bool(kubernetes.FlagRestoreAllowNewCA, false, "Restore although CAs missing in vault have not been exported, setup generates new ones, which existing certificates and kubeconfigs aren't trusted by")
+
+	RootCmd.AddCommand(backupCmd)
+	RootCmd.AddCommand(restoreCmd)
+}
+
+// Read the passphrase and PGP keys given by flag, backups have to be
+// encrypted with exactly one of them
+func backupEncryption(cmd *cobra.Command, encrypt bool) (*kubernetes.BackupEncryption, error) {
+	e := &kubernetes.BackupEncryption{}
+
+	path, err := cmd.PersistentFlags().GetString(kubernetes.FlagBackupPassphraseFile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagBackupPassphraseFile, path, err)
+	}
+	if path != "" {
+		if e.Passphrase, err = kubernetes.ReadPassphrase(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if encrypt {
+		path, err = cmd.PersistentFlags().GetString(kubernetes.FlagBackupPGPRecipient)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagBackupPGPRecipient, path, err)
+		}
+		if path != "" {
+			if e.Recipients, err = kubernetes.ReadPGPKeys(path); err != nil {
+				return nil, err
+			}
+		}
+
+		if (len(e.Passphrase) > 0) == (len(e.Recipients) > 0) {
+			return nil, fmt.Errorf("exactly one of --%s or --%s is required", kubernetes.FlagBackupPassphraseFile, kubernetes.FlagBackupPGPRecipient)
+		}
+		return e, nil
+	}
+
+	path, err = cmd.PersistentFlags().GetString(kubernetes.FlagBackupPGPKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagBackupPGPKey, path, err)
+	}
+	if path != "" {
+		if e.Keys, err = kubernetes.ReadPGPKeys(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(e.Passphrase) == 0 && len(e.Keys) == 0 {
+		return nil, fmt.Errorf("--%s or --%s is required", kubernetes.FlagBackupPassphraseFile, kubernetes.FlagBackupPGPKey)
+	}
+
+	return e, nil
+}
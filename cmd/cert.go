@@ -2,8 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/jetstack/vault-helper/pkg/cert"
@@ -22,38 +27,96 @@ var certCmd = &cobra.Command{
 			i.Log.Fatal(err)
 		}
 
+		certsFile, err := cmd.PersistentFlags().GetString(cert.FlagCertsFile)
+		if err != nil {
+			log.Fatalf("error parsing %s [string] '%s': %v", cert.FlagCertsFile, certsFile, err)
+		}
+		if len(args) != 3 && (len(args) != 0 || certsFile == "") {
+			i.Log.Fatal("wrong number of arguments given. Usage: vault-helper cert [cert role] [common name] [destination path]")
+		}
+
+		watch, err := cmd.PersistentFlags().GetBool(cert.FlagWatch)
+		if err != nil {
+			log.Fatalf("error parsing %s [bool] '%t': %v", cert.FlagWatch, watch, err)
+		}
+
+		load := func() ([]*cert.Cert, error) {
+			return newCerts(cmd, args, certsFile, cert.New(log, i))
+		}
+
+		if watch {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+			if err := cert.NewWatcher(log, i, load).Run(signals); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+
 		if err := i.TokenRenewRun(); err != nil {
 			i.Log.Fatal(err)
 		}
 
-		c := cert.New(log, i)
-		if len(args) != 3 {
-			i.Log.Fatal("wrong number of arguments given. Usage: vault-helper cert [cert role] [common name] [destination path]")
+		certs, err := load()
+		if err != nil {
+			log.Fatal(err)
 		}
+		for _, c := range certs {
+			if err := c.RunCert(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	},
+}
+
+// The certificate of the arguments followed by the ones of the certificates
+// file, configured by the flags
+func newCerts(cmd *cobra.Command, args []string, certsFile string, c *cert.Cert) ([]*cert.Cert, error) {
+	if err := setFlagsCert(c, cmd); err != nil {
+		return nil, err
+	}
+
+	hooks, err := newHooks(cmd, c.Log)
+	if err != nil {
+		return nil, err
+	}
+	c.SetHooks(hooks)
+
+	var certs []*cert.Cert
+	if len(args) == 3 {
 		abs, err := filepath.Abs(args[2])
 		if err != nil {
-			i.Log.Fatalf("failed to generate absoute path from destination '%s': %v", args[2], err)
+			return nil, fmt.Errorf("failed to generate absoute path from destination '%s': %v", args[2], err)
 		}
 		c.SetDestination(abs)
 
 		c.SetRole(args[0])
 		c.SetCommonName(args[1])
 
-		if err := setFlagsCert(c, cmd); err != nil {
-			log.Fatal(err)
-		}
+		certs = append(certs, c)
+	}
 
-		if err := c.RunCert(); err != nil {
-			log.Fatal(err)
+	if certsFile != "" {
+		f, err := cert.LoadCertsFile(certsFile)
+		if err != nil {
+			return nil, err
 		}
-	},
+		fileCerts, err := f.Certs(c)
+		if err != nil {
+			return nil, fmt.Errorf("error in certificates file '%s': %v", certsFile, err)
+		}
+		certs = append(certs, fileCerts...)
+	}
+
+	return certs, nil
 }
 
 func init() {
-	certCmd.PersistentFlags().Int(cert.FlagKeyBitSize, 2048, "Bit size used for generating key. [int]")
+	certCmd.PersistentFlags().Int(cert.FlagKeyBitSize, 2048, "Bit size used for generating key, the curve of ECDSA keys (256 or 384, defaults to 256). [int]")
 	certCmd.Flag(cert.FlagKeyBitSize).Shorthand = "b"
 
-	certCmd.PersistentFlags().String(cert.FlagKeyType, "RSA", "Type of key to generate. [string]")
+	certCmd.PersistentFlags().String(cert.FlagKeyType, cert.KeyTypeRSA, "Type of key to generate. [RSA|ECDSA|Ed25519]")
 	certCmd.Flag(cert.FlagKeyType).Shorthand = "t"
 
 	certCmd.PersistentFlags().StringSlice(cert.FlagIpSans, []string{}, "IP sans. [[]string] (default none)")
@@ -68,23 +131,100 @@ func init() {
 	certCmd.PersistentFlags().String(cert.FlagGroup, "", "Group of created file/directories. Gid value also accepted. [string] (default <current user-group)")
 	certCmd.Flag(cert.FlagGroup).Shorthand = "g"
 
+	certCmd.PersistentFlags().Bool(cert.FlagWatch, false, "Keep running, renewing the token and certificates before they expire. SIGHUP reloads the certificates file. [bool]")
+	certCmd.PersistentFlags().String(cert.FlagCertsFile, "", "YAML or JSON file listing further certificates to issue, defaulting to the flags. [string]")
+
+	certCmd.PersistentFlags().String(cert.FlagRenewBefore, "72h", "Renew certificates with less than this duration, or percentage of their validity like '30%', remaining. [string]")
+
+	hookFlags(certCmd)
 	instanceTokenFlags(certCmd)
 
 	RootCmd.AddCommand(certCmd)
 }
 
-func setFlagsCert(c *cert.Cert, cmd *cobra.Command) error {
-	vInt, err := cmd.PersistentFlags().GetInt(cert.FlagKeyBitSize)
+func hookFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(cert.FlagOnChangeExec, "", "Command run with /bin/sh when the certificate changed. [string] (default none)")
+	cmd.PersistentFlags().String(cert.FlagOnChangeSignal, "", "Signal sent to the process of --pidfile when the certificate changed, like HUP. [string] (default none)")
+	cmd.PersistentFlags().String(cert.FlagOnChangePidfile, "", "File containing the pid of the process to signal. [string]")
+	cmd.PersistentFlags().String(cert.FlagOnChangeWebhook, "", "URL receiving a POST when the certificate changed. [string] (default none)")
+	cmd.PersistentFlags().Duration(cert.FlagHookTimeout, 30*time.Second, "Timeout of each hook attempt. [duration]")
+	cmd.PersistentFlags().Int(cert.FlagHookRetries, 3, "Number of retries of failed hooks, with a doubling delay. [int]")
+}
+
+func newHooks(cmd *cobra.Command, log *logrus.Entry) (*cert.Hooks, error) {
+	h := cert.NewHooks(log)
+
+	vStr, err := cmd.PersistentFlags().GetString(cert.FlagOnChangeExec)
 	if err != nil {
-		return fmt.Errorf("error parsing %s [int] '%d': %v", cert.FlagKeyBitSize, vInt, err)
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagOnChangeExec, vStr, err)
 	}
-	c.SetBitSize(vInt)
+	h.Exec = vStr
 
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagOnChangeSignal)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagOnChangeSignal, vStr, err)
+	}
+	if vStr != "" {
+		signal, err := cert.ParseSignal(vStr)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagOnChangeSignal, vStr, err)
+		}
+		h.Signal = signal
+	}
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagOnChangePidfile)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagOnChangePidfile, vStr, err)
+	}
+	h.Pidfile = vStr
+	if h.Signal != 0 && h.Pidfile == "" {
+		return nil, fmt.Errorf("%s requires %s", cert.FlagOnChangeSignal, cert.FlagOnChangePidfile)
+	}
+
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagOnChangeWebhook)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagOnChangeWebhook, vStr, err)
+	}
+	h.Webhook = vStr
+
+	vDur, err := cmd.PersistentFlags().GetDuration(cert.FlagHookTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [duration] '%s': %v", cert.FlagHookTimeout, vDur, err)
+	}
+	h.Timeout = vDur
+
+	vInt, err := cmd.PersistentFlags().GetInt(cert.FlagHookRetries)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s [int] '%d': %v", cert.FlagHookRetries, vInt, err)
+	}
+	if vInt < 0 {
+		return nil, fmt.Errorf("error parsing %s [int] '%d': expected at least 0", cert.FlagHookRetries, vInt)
+	}
+	h.Retries = vInt
+
+	return h, nil
+}
+
+func setFlagsCert(c *cert.Cert, cmd *cobra.Command) error {
 	vStr, err := cmd.PersistentFlags().GetString(cert.FlagKeyType)
 	if err != nil {
 		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeyType, vStr, err)
 	}
-	c.SetKeyType(vStr)
+	keyType, err := cert.ParseKeyType(vStr)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagKeyType, vStr, err)
+	}
+	c.SetKeyType(keyType)
+
+	vInt, err := cmd.PersistentFlags().GetInt(cert.FlagKeyBitSize)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [int] '%d': %v", cert.FlagKeyBitSize, vInt, err)
+	}
+	// the default size is the one of RSA keys
+	if !cmd.PersistentFlags().Changed(cert.FlagKeyBitSize) {
+		vInt = cert.DefaultBitSize(keyType)
+	}
+	c.SetBitSize(vInt)
 
 	vStr, err = cmd.PersistentFlags().GetString(cert.FlagOwner)
 	if err != nil {
@@ -98,6 +238,17 @@ func setFlagsCert(c *cert.Cert, cmd *cobra.Command) error {
 	}
 	c.SetGroup(vStr)
 
+	vStr, err = cmd.PersistentFlags().GetString(cert.FlagRenewBefore)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagRenewBefore, vStr, err)
+	}
+	renewBefore, fraction, err := cert.ParseRenewBefore(vStr)
+	if err != nil {
+		return fmt.Errorf("error parsing %s [string] '%s': %v", cert.FlagRenewBefore, vStr, err)
+	}
+	c.SetRenewBefore(renewBefore)
+	c.SetRenewBeforeFraction(fraction)
+
 	vSli, err := cmd.PersistentFlags().GetStringSlice(cert.FlagIpSans)
 	if err != nil {
 		return fmt.Errorf("error parsing %s [[]string] '%s': %v", cert.FlagIpSans, vSli, err)
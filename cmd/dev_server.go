@@ -58,7 +58,7 @@ var devServerCmd = &cobra.Command{
 		}
 
 		for n, t := range v.Kubernetes.InitTokens() {
-			log.Infof(n + "-init_token := " + t)
+			log.Infof("%s-init_token := %s", n, t)
 		}
 
 		daemon.SdNotify(false, "READY=1")
@@ -79,6 +79,9 @@ func init() {
 	devServerCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityComponents, time.Hour*24*30, "Maxium validity for component certificates")
 	devServerCmd.Flag(kubernetes.FlagMaxValidityComponents).Shorthand = "s"
 
+	devServerCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	devServerCmd.PersistentFlags().StringSlice(kubernetes.FlagK8sRoles, []string{}, "Additional component role of the k8s PKI and the class allowed to sign it, e.g. 'metrics-server=worker'")
+
 	devServerCmd.PersistentFlags().String(kubernetes.FlagInitTokenEtcd, "", "Set init-token-etcd   (Default to new token)")
 	devServerCmd.Flag(kubernetes.FlagInitTokenEtcd).Shorthand = "e"
 
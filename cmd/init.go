@@ -35,6 +35,12 @@ func Execute() {
 func instanceTokenFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringP(instanceToken.FlagConfigPath, "p", "/etc/vault", "Set config path to directory with tokens")
 	cmd.PersistentFlags().StringP(instanceToken.FlagInitRole, "r", "", "Set role of token to renew. (default *no role*)")
+	cmd.PersistentFlags().String(instanceToken.FlagRoleID, "", "Log in with this AppRole role id and the secret id in <config-path>/secret-id instead of an init token")
+	cmd.PersistentFlags().String(instanceToken.FlagAppRolePath, "approle", "Path of the AppRole auth backend")
+	cmd.PersistentFlags().String(instanceToken.FlagClientCert, "", "Log in with this client certificate when the token is missing or expired")
+	cmd.PersistentFlags().String(instanceToken.FlagClientKey, "", "Key of the client certificate")
+	cmd.PersistentFlags().String(instanceToken.FlagCertAuthPath, "cert", "Path of the cert auth backend, e.g. 'cluster-name/cert'")
+	cmd.PersistentFlags().String(instanceToken.FlagCertAuthRole, "", "Cert auth role to log in as (default any role trusting the certificate)")
 }
 
 func newInstanceToken(cmd *cobra.Command) (iToken *instanceToken.InstanceToken, result error) {
@@ -51,10 +57,51 @@ func newInstanceToken(cmd *cobra.Command) (iToken *instanceToken.InstanceToken,
 	if err != nil {
 		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagInitRole, initRole, err))
 	}
+	roleID, err := cmd.Flags().GetString(instanceToken.FlagRoleID)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagRoleID, roleID, err))
+	}
+	if roleID == "" {
+		roleID = os.Getenv("VAULT_ROLE_ID")
+	}
+	i.SetRoleID(roleID)
+
+	appRolePath, err := cmd.Flags().GetString(instanceToken.FlagAppRolePath)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagAppRolePath, appRolePath, err))
+	}
+	i.SetAppRolePath(appRolePath)
+
+	clientCert, err := cmd.Flags().GetString(instanceToken.FlagClientCert)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagClientCert, clientCert, err))
+	}
+	clientKey, err := cmd.Flags().GetString(instanceToken.FlagClientKey)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagClientKey, clientKey, err))
+	}
+	if (clientCert == "") != (clientKey == "") {
+		result = multierror.Append(result, fmt.Errorf("both --%s and --%s are required to log in with a client certificate", instanceToken.FlagClientCert, instanceToken.FlagClientKey))
+	}
+	i.SetClientCert(clientCert, clientKey)
+
+	certAuthPath, err := cmd.Flags().GetString(instanceToken.FlagCertAuthPath)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagCertAuthPath, certAuthPath, err))
+	}
+	i.SetCertAuthPath(certAuthPath)
+
+	certAuthRole, err := cmd.Flags().GetString(instanceToken.FlagCertAuthRole)
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("error parsing %s '%s': %v", instanceToken.FlagCertAuthRole, certAuthRole, err))
+	}
+	i.SetCertAuthRole(certAuthRole)
+
+	// nodes logging in with an AppRole don't need a token role
 	if initRole == "" {
 		//Read env variable
 		initRole = os.Getenv("VAULT_INIT_ROLE")
-		if initRole == "" {
+		if initRole == "" && roleID == "" {
 			result = multierror.Append(result, fmt.Errorf("no token role was given. token role is required for this command: --%s", instanceToken.FlagInitRole))
 		}
 	}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// initTokenCmd represents the init-token command
+var initTokenCmd = &cobra.Command{
+	Use:   "init-token",
+	Short: "Manage the init tokens of a cluster.",
+}
+
+var initTokenRotateCmd = &cobra.Command{
+	Use:   "rotate [cluster ID] [role]",
+	Short: "Replace the init token of a role with a new one and revoke the old one.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper init-token rotate [cluster ID] [role]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		token, err := k.RotateInitToken(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Infof("%s-init_token := %s", args[1], token)
+	},
+}
+
+var initTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke [cluster ID] [role]",
+	Short: "Revoke the init token of a role. The next setup creates a new one.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper init-token revoke [cluster ID] [role]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RevokeInitToken(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	initTokenRotateCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	initTokenRevokeCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+
+	initTokenCmd.AddCommand(initTokenRotateCmd)
+	initTokenCmd.AddCommand(initTokenRevokeCmd)
+	RootCmd.AddCommand(initTokenCmd)
+}
@@ -35,13 +35,22 @@ var kubeconfCmd = &cobra.Command{
 		if err := i.TokenRenewRun(); err != nil {
 			i.Log.Fatal(err)
 		}
-		c := cert.New(i.Log, i)
-		if err := c.RunCert(); err != nil {
-			c.Log.Fatal(err)
+
+		// the hooks run once for the kubeconfig, when either it or the
+		// certificate changed
+		c, err := newCerts(cmd, args, "", cert.New(i.Log, i))
+		if err != nil {
+			log.Fatal(err)
+		}
+		hooks := c[0].Hooks()
+		c[0].SetHooks(nil)
+		if err := c[0].RunCert(); err != nil {
+			log.Fatal(err)
 		}
 
-		u := kubeconfig.New(log, c)
+		u := kubeconfig.New(log, c[0])
 		u.SetFilePath(abs)
+		u.SetHooks(hooks)
 
 		if err := u.RunKube(); err != nil {
 			u.Log.Fatal(err)
@@ -50,10 +59,10 @@ var kubeconfCmd = &cobra.Command{
 }
 
 func init() {
-	kubeconfCmd.PersistentFlags().Int(cert.FlagKeyBitSize, 2048, "Bit size used for generating key. [int]")
+	kubeconfCmd.PersistentFlags().Int(cert.FlagKeyBitSize, 2048, "Bit size used for generating key, the curve of ECDSA keys (256 or 384, defaults to 256). [int]")
 	kubeconfCmd.Flag(cert.FlagKeyBitSize).Shorthand = "b"
 
-	kubeconfCmd.PersistentFlags().String(cert.FlagKeyType, "RSA", "Type of key to generate. [string]")
+	kubeconfCmd.PersistentFlags().String(cert.FlagKeyType, cert.KeyTypeRSA, "Type of key to generate. [RSA|ECDSA|Ed25519]")
 	kubeconfCmd.Flag(cert.FlagKeyType).Shorthand = "t"
 
 	kubeconfCmd.PersistentFlags().StringSlice(cert.FlagIpSans, []string{}, "IP sans. [[]string] (default none)")
@@ -68,6 +77,9 @@ func init() {
 	kubeconfCmd.PersistentFlags().String(cert.FlagGroup, "", "Group of created file/directories. Gid value also accepted. [string] (default <current user-group>)")
 	kubeconfCmd.Flag(cert.FlagGroup).Shorthand = "g"
 
+	kubeconfCmd.PersistentFlags().String(cert.FlagRenewBefore, "72h", "Renew certificates with less than this duration, or percentage of their validity like '30%', remaining. [string]")
+
+	hookFlags(kubeconfCmd)
 	instanceTokenFlags(kubeconfCmd)
 
 	RootCmd.AddCommand(kubeconfCmd)
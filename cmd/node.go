@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// nodeCmd represents the node command
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Manage nodes with their own identity, which can only sign kubelet certificates for their name.",
+}
+
+var nodeAddCmd = &cobra.Command{
+	Use:   "add [cluster ID] [node name]",
+	Short: "Add a node with its own policy. Prints the init token, or the role id and secret id of its AppRole.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper node add [cluster ID] [node name]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsBootstrap(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+
+		class, err := cmd.PersistentFlags().GetString(kubernetes.FlagNodeClass)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagNodeClass, err)
+		}
+
+		bootstrap, err := k.AddNode(args[1], class)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if bootstrap.InitToken != "" {
+			fmt.Printf("init_token %s\n", bootstrap.InitToken)
+			return
+		}
+		fmt.Printf("role_id   %s\n", bootstrap.RoleID)
+		fmt.Printf("secret_id %s\n", bootstrap.SecretID)
+	},
+}
+
+var nodeRemoveCmd = &cobra.Command{
+	Use:   "remove [cluster ID] [node name]",
+	Short: "Remove the policy of a node and the roles it logs in with, revoking its init token.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper node remove [cluster ID] [node name]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RemoveNode(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	nodeAddCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	nodeAddCmd.PersistentFlags().String(kubernetes.FlagNodeClass, "worker", "Init token role the node gets its other policies from, empty for none")
+	nodeAddCmd.PersistentFlags().String(kubernetes.FlagBootstrap, kubernetes.BootstrapInitToken, "How the node gets its first token. [init-token|approle]")
+	nodeAddCmd.PersistentFlags().Int(kubernetes.FlagAppRoleSecretIDNumUses, 1, "Number of times an AppRole secret id can be used, 0 for unlimited")
+	nodeAddCmd.PersistentFlags().Duration(kubernetes.FlagAppRoleSecretIDTTL, 0, "Validity of AppRole secret ids, 0 for no expiry")
+	nodeRemoveCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+
+	nodeCmd.AddCommand(nodeAddCmd)
+	nodeCmd.AddCommand(nodeRemoveCmd)
+	RootCmd.AddCommand(nodeCmd)
+}
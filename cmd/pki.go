@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// pkiCmd represents the pki command
+var pkiCmd = &cobra.Command{
+	Use:   "pki",
+	Short: "Manage the CAs of a cluster's PKIs.",
+}
+
+var pkiImportSignedCmd = &cobra.Command{
+	Use:   "import-signed [cluster ID] [pki name] [certificate path]",
+	Short: "Import the signed certificate of an intermediate CA waiting for it.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 3 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper pki import-signed [cluster ID] [pki name] [certificate path]")
+		}
+
+		cert, err := ioutil.ReadFile(args[2])
+		if err != nil {
+			log.Fatalf("error reading certificate '%s': %v", args[2], err)
+		}
+
+		v, err := vault.NewClient(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		if err := k.ImportSignedCA(args[1], string(cert)); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var pkiImportCmd = &cobra.Command{
+	Use:   "import [cluster ID] [pki name]",
+	Short: "Import an existing CA and its private key into a PKI, instead of letting setup generate one.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper pki import [cluster ID] [pki name] --cert ca.crt --key ca.key")
+		}
+
+		var pems []string
+		for _, flag := range []string{kubernetes.FlagPKIImportCert, kubernetes.FlagPKIImportKey} {
+			path, err := cmd.PersistentFlags().GetString(flag)
+			if err != nil {
+				log.Fatalf("error parsing %s '%s': %s", flag, path, err)
+			}
+			if path == "" {
+				log.Fatalf("--%s is required", flag)
+			}
+			dat, err := ioutil.ReadFile(path)
+			if err != nil {
+				log.Fatalf("error reading %s '%s': %v", flag, path, err)
+			}
+			pems = append(pems, string(dat))
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		maxValidityCA, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagMaxValidityCA)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagMaxValidityCA, err)
+		}
+		k.SetMaxValidityCA(maxValidityCA)
+		if k.CAExported, err = cmd.PersistentFlags().GetBool(kubernetes.FlagCAExported); err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagCAExported, err)
+		}
+
+		if err := k.ImportCA(args[1], pems[0], pems[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var pkiRotateCmd = &cobra.Command{
+	Use:   "rotate [cluster ID] [pki name]",
+	Short: "Mount a new CA generation next to the active one and switch roles and policies to it.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper pki rotate [cluster ID] [pki name]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RotatePKI(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var pkiRetireCmd = &cobra.Command{
+	Use:   "retire [cluster ID] [pki name]",
+	Short: "Unmount all but the active CA generation of a PKI.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper pki retire [cluster ID] [pki name]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RetirePKI(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	pkiImportCmd.PersistentFlags().String(kubernetes.FlagPKIImportCert, "", "Path to the PEM encoded CA certificate, optionally followed by the chain of its issuers")
+	pkiImportCmd.PersistentFlags().String(kubernetes.FlagPKIImportKey, "", "Path to the PEM encoded private key of the CA")
+	pkiImportCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	pkiImportCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityCA, time.Hour*24*365*20, "Maxium validity for CA certificates")
+	pkiImportCmd.PersistentFlags().Bool(kubernetes.FlagCAExported, false, "Escrow the CA key in the secrets mount for backups")
+	pkiRotateCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	pkiRetireCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+
+	pkiCmd.AddCommand(pkiImportCmd)
+	pkiCmd.AddCommand(pkiImportSignedCmd)
+	pkiCmd.AddCommand(pkiRotateCmd)
+	pkiCmd.AddCommand(pkiRetireCmd)
+	RootCmd.AddCommand(pkiCmd)
+}
+
+// Create a cluster using the VAULT_TOKEN and the spec given by flag
+func newAdminKubernetes(cmd *cobra.Command, clusterID string) (*kubernetes.Kubernetes, error) {
+	v, err := vault.NewClient(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSpecKubernetes(cmd, v, clusterID)
+}
+
+// Create a cluster with the spec given by --spec, the vault client is nil
+// for commands working offline
+func newSpecKubernetes(cmd *cobra.Command, v *vault.Client, clusterID string) (*kubernetes.Kubernetes, error) {
+	k := kubernetes.New(v, LogLevel(cmd))
+	k.SetClusterID(clusterID)
+
+	path, err := cmd.PersistentFlags().GetString(kubernetes.FlagSpec)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSpec, path, err)
+	}
+	if path != "" {
+		spec, err := kubernetes.LoadSpec(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := k.SetSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	return k, nil
+}
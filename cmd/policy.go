@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// policyCmd represents the policy command
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Review the policies setup writes for a cluster, without vault.",
+}
+
+var policyRenderCmd = &cobra.Command{
+	Use:   "render [cluster ID]",
+	Short: "Write the rules of every policy setup writes to files named after the policies.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper policy render [cluster ID]")
+		}
+
+		k, err := newPolicyKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		dir, err := cmd.PersistentFlags().GetString(kubernetes.FlagPolicyDestDir)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagPolicyDestDir, err)
+		}
+
+		paths, err := k.RenderPolicies(dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, path := range paths {
+			log.Infof("Policy written to '%s'", path)
+		}
+	},
+}
+
+var policyCheckCmd = &cobra.Command{
+	Use:   "check [cluster ID]",
+	Short: "Print the capabilities tokens of a token role get for a path, evaluating the policies like vault.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper policy check [cluster ID] --token-role [role] --path [path]")
+		}
+
+		k, err := newPolicyKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		role, err := cmd.PersistentFlags().GetString(kubernetes.FlagPolicyTokenRole)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagPolicyTokenRole, err)
+		}
+		path, err := cmd.PersistentFlags().GetString(kubernetes.FlagPolicyPath)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagPolicyPath, err)
+		}
+		if role == "" || path == "" {
+			log.Fatalf("--%s and --%s are required", kubernetes.FlagPolicyTokenRole, kubernetes.FlagPolicyPath)
+		}
+
+		check, err := k.CheckPolicies(role, path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Print(check.String())
+	},
+}
+
+// Create a cluster without vault, with the flags changing its policies
+func newPolicyKubernetes(cmd *cobra.Command, clusterID string) (*kubernetes.Kubernetes, error) {
+	k, err := newSpecKubernetes(cmd, nil, clusterID)
+	if err != nil {
+		return nil, err
+	}
+	if err := setFlagsBootstrap(k, cmd); err != nil {
+		return nil, err
+	}
+	if err := setFlagsNodeIdentity(k, cmd); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func init() {
+	for _, c := range []*cobra.Command{policyRenderCmd, policyCheckCmd} {
+		c.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+		c.PersistentFlags().String(kubernetes.FlagBootstrap, kubernetes.BootstrapInitToken, "How nodes get their first token. [init-token|approle]")
+		c.PersistentFlags().Bool(kubernetes.FlagNodeIdentity, false, "Only allow nodes added with 'node add' to sign kubelet certificates, for their own name")
+	}
+	policyRenderCmd.PersistentFlags().String(kubernetes.FlagPolicyDestDir, ".", "Directory to write the policies to")
+	policyCheckCmd.PersistentFlags().String(kubernetes.FlagPolicyTokenRole, "", "Init token role whose tokens to check, e.g. 'worker'")
+	policyCheckCmd.PersistentFlags().String(kubernetes.FlagPolicyPath, "", "Vault path to check, e.g. '<cluster>/pki/k8s/sign/kube-apiserver'")
+
+	policyCmd.AddCommand(policyRenderCmd)
+	policyCmd.AddCommand(policyCheckCmd)
+	RootCmd.AddCommand(policyCmd)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// revokeCmd represents the revoke command
+var revokeCmd = &cobra.Command{
+	Use:   "revoke [cluster ID]",
+	Short: "Revoke certificates issued by the PKIs of a cluster by serial number and rotate the CRLs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper revoke [cluster ID] --serial [serial number]")
+		}
+
+		serials, err := cmd.PersistentFlags().GetStringSlice(kubernetes.FlagRevokeSerial)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagRevokeSerial, err)
+		}
+		if len(serials) == 0 {
+			log.Fatalf("--%s is required", kubernetes.FlagRevokeSerial)
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RevokeSerials(serials); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+// revokeNodeCmd represents the revoke-node command
+var revokeNodeCmd = &cobra.Command{
+	Use:   "revoke-node [cluster ID] [node name]",
+	Short: "Revoke the certificates and tokens of a decommissioned node and rotate the CRLs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 2 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper revoke-node [cluster ID] [node name]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RevokeNode(args[1]); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	revokeCmd.PersistentFlags().StringSlice(kubernetes.FlagRevokeSerial, []string{}, "Serial number of a certificate to revoke, can be given multiple times")
+	revokeCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	revokeNodeCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+
+	RootCmd.AddCommand(revokeCmd)
+	RootCmd.AddCommand(revokeNodeCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// serviceAccountsCmd represents the service-accounts command
+var serviceAccountsCmd = &cobra.Command{
+	Use:   "service-accounts",
+	Short: "Manage the service account signing key of a cluster.",
+}
+
+var serviceAccountsRotateCmd = &cobra.Command{
+	Use:   "rotate [cluster ID]",
+	Short: "Replace the service account signing key. Public keys of replaced keys stay valid for the overlap window.",
+	Long: `Replace the service account signing key. Public keys of replaced keys stay
+valid for the overlap window, after which setup drops them. Tokens signed by a
+dropped key can't be verified anymore, including legacy service account tokens
+that never expire. Pass --` + kubernetes.FlagSAKeepPrevious + ` to rotate and setup to keep
+previous keys until those tokens were reissued.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper service-accounts rotate [cluster ID]")
+		}
+
+		k, err := newAdminKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsServiceAccounts(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := k.RotateServiceAccountKey(k.ServiceAccountKeyType); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	serviceAccountsRotateCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	serviceAccountsRotateCmd.PersistentFlags().String(kubernetes.FlagSAKeyType, kubernetes.SAKeyTypeRSA, "Type of the new service account key. [rsa|ecdsa]")
+	serviceAccountsRotateCmd.PersistentFlags().Duration(kubernetes.FlagSAKeyOverlap, time.Hour*24*30, "How long public keys of rotated service account keys stay valid")
+	serviceAccountsRotateCmd.PersistentFlags().Bool(kubernetes.FlagSAKeepPrevious, false, "Keep the public keys of rotated service account keys beyond the overlap window, until the tokens they signed were reissued")
+
+	serviceAccountsCmd.AddCommand(serviceAccountsRotateCmd)
+	RootCmd.AddCommand(serviceAccountsCmd)
+}
+
+func setFlagsServiceAccounts(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetString(kubernetes.FlagSAKeyType)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSAKeyType, value, err)
+	}
+	if value != kubernetes.SAKeyTypeRSA && value != kubernetes.SAKeyTypeECDSA {
+		return fmt.Errorf("invalid %s '%s', expected '%s' or '%s'", kubernetes.FlagSAKeyType, value, kubernetes.SAKeyTypeRSA, kubernetes.SAKeyTypeECDSA)
+	}
+	k.ServiceAccountKeyType = value
+
+	overlap, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagSAKeyOverlap)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSAKeyOverlap, overlap, err)
+	}
+	k.ServiceAccountKeyOverlap = overlap
+
+	keep, err := cmd.PersistentFlags().GetBool(kubernetes.FlagSAKeepPrevious)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%t': %s", kubernetes.FlagSAKeepPrevious, keep, err)
+	}
+	k.ServiceAccountKeepPrevious = keep
+
+	return nil
+}
@@ -1,9 +1,15 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
+	"github.com/Sirupsen/logrus"
 	vault "github.com/hashicorp/vault/api"
 	"github.com/spf13/cobra"
 
@@ -12,7 +18,7 @@ import (
 
 // initCmd represents the init command
 var setupCmd = &cobra.Command{
-	Use:   "setup [cluster ID]",
+	Use:   "setup [cluster ID...]",
 	Short: "Setup kubernetes on a running vault server.",
 	Run: func(cmd *cobra.Command, args []string) {
 		log := LogLevel(cmd)
@@ -22,38 +28,70 @@ var setupCmd = &cobra.Command{
 			log.Fatal(err)
 		}
 
-		i, err := newInstanceToken(cmd)
+		clusters, err := setupClusters(v, log, cmd, args)
 		if err != nil {
-			i.Log.Fatal(err)
+			log.Fatal(err)
 		}
 
-		if err := i.TokenRenewRun(); err != nil {
-			i.Log.Fatal(err)
+		plan, err := cmd.PersistentFlags().GetBool(kubernetes.FlagPlan)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagPlan, err)
+		}
+		if plan {
+			format, err := cmd.PersistentFlags().GetString(kubernetes.FlagPlanFormat)
+			if err != nil {
+				log.Fatalf("error parsing %s: %v", kubernetes.FlagPlanFormat, err)
+			}
+			for _, k := range clusters {
+				if err := printPlan(k, format); err != nil {
+					log.Fatal(err)
+				}
+			}
+			return
 		}
 
-		k := kubernetes.New(v, log)
+		parallel, err := cmd.PersistentFlags().GetInt(kubernetes.FlagParallel)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagParallel, err)
 		}
-
-		if len(args) > 0 {
-			k.SetClusterID(args[0])
-		} else {
-			log.Fatal("no cluster id was given")
+		csrDir, err := cmd.PersistentFlags().GetString(kubernetes.FlagCACSRDir)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagCACSRDir, err)
 		}
 
-		if err := setFlagsKubernetes(k, cmd); err != nil {
-			log.Fatal(err)
-		}
+		results := kubernetes.EnsureAll(clusters, parallel)
 
-		if err := k.Ensure(); err != nil {
-			log.Fatal(err)
+		failed := false
+		for pos, result := range results {
+			k := clusters[pos]
+			if result.Err != nil {
+				failed = true
+				continue
+			}
+
+			for n, t := range result.InitTokens {
+				k.Log.Infof("%s-init_token := %s", n, t)
+			}
+			for n, id := range result.RoleIDs {
+				k.Log.Infof("%s-role_id := %s", n, id)
+			}
+			if err := writePendingCSRs(k, result.ClusterID, csrDir); err != nil {
+				result.Err = err
+				failed = true
+			}
 		}
 
-		for n, t := range k.InitTokens() {
-			log.Infof(n + "-init_token := " + t)
+		if len(results) == 1 {
+			if results[0].Err != nil {
+				log.Fatal(results[0].Err)
+			}
+			return
 		}
 
+		fmt.Print(kubernetes.EnsureSummary(results))
+		if failed {
+			os.Exit(1)
+		}
 	},
 }
 
@@ -62,6 +100,34 @@ func init() {
 	setupCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityAdmin, time.Hour*24*365, "Maxium validity for admin certificates")
 	setupCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityComponents, time.Hour*24*30, "Maxium validity for component certificates")
 
+	setupCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	setupCmd.PersistentFlags().String(kubernetes.FlagSpecDir, "", "Directory of YAML or JSON cluster specs to setup, named after their cluster ID")
+	setupCmd.PersistentFlags().Int(kubernetes.FlagParallel, 4, "Number of clusters to setup at the same time")
+	setupCmd.PersistentFlags().StringSlice(kubernetes.FlagK8sRoles, []string{}, "Additional component role of the k8s PKI and the class allowed to sign it, e.g. 'metrics-server=worker'")
+
+	setupCmd.PersistentFlags().Bool(kubernetes.FlagPlan, false, "Print the changes setup would make to vault, without writing anything")
+	setupCmd.PersistentFlags().String(kubernetes.FlagPlanFormat, kubernetes.PlanFormatHuman, "Output format of the plan. [human|json]")
+
+	setupCmd.PersistentFlags().String(kubernetes.FlagCAMode, kubernetes.CAModeRoot, "Mode of CAs not configured in the spec. [root|intermediate]")
+	setupCmd.PersistentFlags().String(kubernetes.FlagCAParent, "", "Path of a PKI mount signing intermediate CAs (Default to external signing)")
+	setupCmd.PersistentFlags().Bool(kubernetes.FlagCAExported, false, "Generate CAs not configured in the spec with exported keys, escrowed in the secrets mount for backups")
+	setupCmd.PersistentFlags().String(kubernetes.FlagPKIURL, "", "Base URL of vault written into certificates to fetch CAs and CRLs from, unset URLs are left alone")
+	setupCmd.PersistentFlags().Duration(kubernetes.FlagCRLExpiry, 0, "Validity of the CRLs of the PKIs, only written if set")
+	setupCmd.PersistentFlags().String(kubernetes.FlagPKIKeyType, kubernetes.PKIKeyTypeRSA, "Type of keys the PKI roles accept in CSRs, unset roles are left alone. [rsa|ec|ed25519|any]")
+	setupCmd.PersistentFlags().Int(kubernetes.FlagPKIKeyBits, 2048, "Minimum bits of RSA keys, or EC curve the PKI roles accept (Default to 256 for EC keys)")
+	setupCmd.PersistentFlags().String(kubernetes.FlagCACSRDir, "", "Directory to write CSRs of intermediate CAs waiting to be signed to")
+
+	setupCmd.PersistentFlags().Int(kubernetes.FlagSecretsKVVersion, 0, "KV version of the secrets mount, version 1 mounts are migrated to 2. [1|2] (Default to the version of the existing mount, 1 for new mounts)")
+
+	setupCmd.PersistentFlags().String(kubernetes.FlagSAKeyType, kubernetes.SAKeyTypeRSA, "Type of the service account key, if there is none yet. [rsa|ecdsa]")
+	setupCmd.PersistentFlags().Duration(kubernetes.FlagSAKeyOverlap, time.Hour*24*30, "How long public keys of rotated service account keys stay valid")
+	setupCmd.PersistentFlags().Bool(kubernetes.FlagSAKeepPrevious, false, "Keep the public keys of rotated service account keys beyond the overlap window, until the tokens they signed were reissued")
+
+	setupCmd.PersistentFlags().String(kubernetes.FlagBootstrap, kubernetes.BootstrapInitToken, "How nodes get their first token. [init-token|approle]")
+	setupCmd.PersistentFlags().Int(kubernetes.FlagAppRoleSecretIDNumUses, 1, "Number of times an AppRole secret id can be used, 0 for unlimited")
+	setupCmd.PersistentFlags().Duration(kubernetes.FlagAppRoleSecretIDTTL, 0, "Validity of AppRole secret ids, 0 for no expiry")
+	setupCmd.PersistentFlags().Bool(kubernetes.FlagNodeIdentity, false, "Only allow nodes added with 'node add' to sign kubelet certificates, for their own name")
+
 	setupCmd.PersistentFlags().String(kubernetes.FlagInitTokenEtcd, "", "Set init-token-etcd   (Default to new token)")
 	setupCmd.PersistentFlags().String(kubernetes.FlagInitTokenWorker, "", "Set init-token-worker (Default to new token)")
 	setupCmd.PersistentFlags().String(kubernetes.FlagInitTokenMaster, "", "Set init-token-master (Default to new token)")
@@ -70,30 +136,146 @@ func init() {
 	RootCmd.AddCommand(setupCmd)
 }
 
-func setFlagsKubernetes(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
-	if value, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagMaxValidityComponents); err != nil {
+// Create the clusters given as arguments and found in the spec directory,
+// which all share the same cached vault client
+func setupClusters(v *vault.Client, log *logrus.Entry, cmd *cobra.Command, args []string) ([]*kubernetes.Kubernetes, error) {
+	specs := map[string]*kubernetes.Spec{}
+
+	specDir, err := cmd.PersistentFlags().GetString(kubernetes.FlagSpecDir)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSpecDir, specDir, err)
+	}
+	if specDir != "" {
+		spec, err := cmd.PersistentFlags().GetString(kubernetes.FlagSpec)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSpec, spec, err)
+		}
+		if spec != "" {
+			return nil, fmt.Errorf("only one of --%s and --%s can be given", kubernetes.FlagSpec, kubernetes.FlagSpecDir)
+		}
+
+		specs, err = kubernetes.LoadSpecDir(specDir)
 		if err != nil {
-			return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagMaxValidityComponents, value, err)
+			return nil, err
+		}
+	}
+
+	clusterIDs := append([]string{}, args...)
+	var dirIDs []string
+	for clusterID := range specs {
+		dirIDs = append(dirIDs, clusterID)
+	}
+	sort.Strings(dirIDs)
+	clusterIDs = append(clusterIDs, dirIDs...)
+
+	if len(clusterIDs) == 0 {
+		return nil, errors.New("no cluster id was given")
+	}
+
+	seen := map[string]bool{}
+	for _, clusterID := range clusterIDs {
+		if seen[clusterID] {
+			return nil, fmt.Errorf("cluster '%s' was given more than once", clusterID)
+		}
+		seen[clusterID] = true
+	}
+
+	// a token can only be the init token of a single cluster
+	if len(clusterIDs) > 1 {
+		for _, flag := range []string{kubernetes.FlagInitTokenEtcd, kubernetes.FlagInitTokenMaster, kubernetes.FlagInitTokenWorker, kubernetes.FlagInitTokenAll} {
+			if cmd.PersistentFlags().Changed(flag) {
+				return nil, fmt.Errorf("--%s can only be given when setting up a single cluster", flag)
+			}
+		}
+	}
+
+	shared := kubernetes.NewCachedVault(v)
+
+	var clusters []*kubernetes.Kubernetes
+	for _, clusterID := range clusterIDs {
+		k := kubernetes.NewWithVault(shared, log.WithField("cluster", clusterID))
+		k.SetClusterID(clusterID)
+
+		if spec, ok := specs[clusterID]; ok {
+			if err := k.SetSpec(spec); err != nil {
+				return nil, fmt.Errorf("cluster '%s': %v", clusterID, err)
+			}
 		}
+		if err := setFlagsKubernetes(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsCA(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsServiceAccounts(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsSecrets(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsBootstrap(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsNodeIdentity(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsPKIURLs(k, cmd); err != nil {
+			return nil, err
+		}
+		if err := setFlagsPKIKey(k, cmd); err != nil {
+			return nil, err
+		}
+
+		clusters = append(clusters, k)
+	}
+
+	return clusters, nil
+}
+
+func setFlagsKubernetes(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	if value, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagMaxValidityComponents); err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagMaxValidityComponents, value, err)
+	} else {
 		k.MaxValidityComponents = value
 	}
 
 	if value, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagMaxValidityAdmin); err != nil {
-		if err != nil {
-			return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagMaxValidityAdmin, value, err)
-		}
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagMaxValidityAdmin, value, err)
+	} else {
 		k.MaxValidityAdmin = value
 	}
 
 	if value, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagMaxValidityCA); err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagMaxValidityCA, value, err)
+	} else {
+		k.SetMaxValidityCA(value)
+	}
+
+	// Spec file
+	value, err := cmd.PersistentFlags().GetString(kubernetes.FlagSpec)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagSpec, value, err)
+	}
+	if value != "" {
+		spec, err := kubernetes.LoadSpec(value)
 		if err != nil {
-			return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagMaxValidityCA, value, err)
+			return err
+		}
+		if err := k.SetSpec(spec); err != nil {
+			return err
 		}
-		k.MaxValidityCA = value
 	}
 
-	// Init token flags
-	value, err := cmd.PersistentFlags().GetString(kubernetes.FlagInitTokenEtcd)
+	if err := setFlagsK8sRoles(k, cmd); err != nil {
+		return err
+	}
+
+	// Init token flags, only known to commands creating init tokens
+	if cmd.PersistentFlags().Lookup(kubernetes.FlagInitTokenEtcd) == nil {
+		return nil
+	}
+
+	value, err = cmd.PersistentFlags().GetString(kubernetes.FlagInitTokenEtcd)
 	if err != nil {
 		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagInitTokenEtcd, value, err)
 	}
@@ -119,3 +301,201 @@ func setFlagsKubernetes(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
 
 	return nil
 }
+
+func setFlagsCA(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetString(kubernetes.FlagCAMode)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagCAMode, value, err)
+	}
+	k.CAMode = value
+
+	value, err = cmd.PersistentFlags().GetString(kubernetes.FlagCAParent)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagCAParent, value, err)
+	}
+	k.CAParent = value
+
+	exported, err := cmd.PersistentFlags().GetBool(kubernetes.FlagCAExported)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%t': %s", kubernetes.FlagCAExported, exported, err)
+	}
+	k.CAExported = exported
+
+	ca := &kubernetes.SpecCA{Mode: k.CAMode, Parent: k.CAParent, Exported: k.CAExported}
+	if err := ca.Validate(); err != nil {
+		return fmt.Errorf("invalid CA flags: %v", err)
+	}
+
+	return nil
+}
+
+func setFlagsSecrets(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetInt(kubernetes.FlagSecretsKVVersion)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%d': %s", kubernetes.FlagSecretsKVVersion, value, err)
+	}
+	if value < 0 || value > 2 {
+		return fmt.Errorf("invalid %s '%d', expected 1 or 2", kubernetes.FlagSecretsKVVersion, value)
+	}
+	k.SecretsKVVersion = value
+
+	return nil
+}
+
+func setFlagsBootstrap(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetString(kubernetes.FlagBootstrap)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagBootstrap, value, err)
+	}
+	if value != kubernetes.BootstrapInitToken && value != kubernetes.BootstrapAppRole {
+		return fmt.Errorf("invalid %s '%s', expected '%s' or '%s'", kubernetes.FlagBootstrap, value, kubernetes.BootstrapInitToken, kubernetes.BootstrapAppRole)
+	}
+	k.Bootstrap = value
+
+	// AppRole flags, only known to commands creating secret ids
+	if cmd.PersistentFlags().Lookup(kubernetes.FlagAppRoleSecretIDNumUses) == nil {
+		return nil
+	}
+
+	numUses, err := cmd.PersistentFlags().GetInt(kubernetes.FlagAppRoleSecretIDNumUses)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%d': %s", kubernetes.FlagAppRoleSecretIDNumUses, numUses, err)
+	}
+	k.AppRoleSecretIDNumUses = numUses
+
+	ttl, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagAppRoleSecretIDTTL)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagAppRoleSecretIDTTL, ttl, err)
+	}
+	k.AppRoleSecretIDTTL = ttl
+
+	return nil
+}
+
+// The issuing and CRL URLs default to the address of the vault client
+// The URLs and the CRL config are only written when asked for, so setup
+// leaves the ones of existing clusters alone
+func setFlagsPKIURLs(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	if cmd.PersistentFlags().Changed(kubernetes.FlagPKIURL) {
+		value, err := cmd.PersistentFlags().GetString(kubernetes.FlagPKIURL)
+		if err != nil {
+			return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagPKIURL, value, err)
+		}
+		k.PKIURL = value
+	}
+
+	if cmd.PersistentFlags().Changed(kubernetes.FlagCRLExpiry) {
+		expiry, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagCRLExpiry)
+		if err != nil {
+			return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagCRLExpiry, expiry, err)
+		}
+		k.CRLExpiry = expiry
+	}
+
+	return nil
+}
+
+// The key settings are only written to the PKI roles when asked for, so setup
+// leaves the ones of existing roles alone. The key type defaults to RSA, the
+// key bits to the smallest supported EC curve for EC keys.
+func setFlagsPKIKey(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	if !cmd.PersistentFlags().Changed(kubernetes.FlagPKIKeyType) && !cmd.PersistentFlags().Changed(kubernetes.FlagPKIKeyBits) {
+		return nil
+	}
+
+	keyType, err := cmd.PersistentFlags().GetString(kubernetes.FlagPKIKeyType)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagPKIKeyType, keyType, err)
+	}
+
+	bits, err := cmd.PersistentFlags().GetInt(kubernetes.FlagPKIKeyBits)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%d': %s", kubernetes.FlagPKIKeyBits, bits, err)
+	}
+	if keyType == kubernetes.PKIKeyTypeEC && !cmd.PersistentFlags().Changed(kubernetes.FlagPKIKeyBits) {
+		bits = 256
+	}
+
+	if err := kubernetes.ValidatePKIKey(keyType, bits); err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagPKIKeyType, keyType, err)
+	}
+	k.PKIKeyType = keyType
+	k.PKIKeyBits = bits
+
+	return nil
+}
+
+// Extra component roles
+func setFlagsK8sRoles(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	values, err := cmd.PersistentFlags().GetStringSlice(kubernetes.FlagK8sRoles)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%s': %s", kubernetes.FlagK8sRoles, values, err)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	roles, err := kubernetes.ParseComponentRoles(values)
+	if err != nil {
+		return err
+	}
+	return k.AddComponentRoles(roles)
+}
+
+func setFlagsNodeIdentity(k *kubernetes.Kubernetes, cmd *cobra.Command) error {
+	value, err := cmd.PersistentFlags().GetBool(kubernetes.FlagNodeIdentity)
+	if err != nil {
+		return fmt.Errorf("error parsing %s '%t': %s", kubernetes.FlagNodeIdentity, value, err)
+	}
+	k.NodeIdentity = value
+
+	return nil
+}
+
+// Write the CSRs of intermediate CAs which still have to be signed
+func writePendingCSRs(k *kubernetes.Kubernetes, clusterID, dir string) error {
+	csrs, err := k.PendingCSRs()
+	if err != nil {
+		return err
+	}
+
+	for name, csr := range csrs {
+		if dir == "" {
+			k.Log.Infof("%s CSR waiting to be signed:\n%s", name, csr)
+			continue
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%s-%s.csr", clusterID, name))
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return fmt.Errorf("error creating CSR directory '%s': %v", dir, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(csr), 0644); err != nil {
+			return fmt.Errorf("error writing CSR '%s': %v", path, err)
+		}
+		k.Log.Infof("%s CSR waiting to be signed written to '%s'", name, path)
+	}
+
+	return nil
+}
+
+func printPlan(k *kubernetes.Kubernetes, format string) error {
+	plan, err := k.Plan()
+	if err != nil {
+		return fmt.Errorf("error planning changes: %v", err)
+	}
+
+	switch format {
+	case kubernetes.PlanFormatHuman:
+		fmt.Print(plan.String())
+	case kubernetes.PlanFormatJSON:
+		dat, err := plan.JSON()
+		if err != nil {
+			return fmt.Errorf("error converting plan to JSON: %v", err)
+		}
+		fmt.Println(string(dat))
+	default:
+		return fmt.Errorf("unknown plan format '%s'", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// specCmd represents the spec command
+var specCmd = &cobra.Command{
+	Use:   "spec",
+	Short: "Review the cluster spec setup converges to, without vault.",
+}
+
+var specShowCmd = &cobra.Command{
+	Use:   "show [cluster ID]",
+	Short: "Print the spec setup converges to as YAML, the built-in one unless --spec is given.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper spec show [cluster ID]")
+		}
+
+		k, err := newPolicyKubernetes(cmd, args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsK8sRoles(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+
+		out, err := k.Spec().YAML()
+		if err != nil {
+			log.Fatalf("error marshalling spec: %v", err)
+		}
+		fmt.Print(string(out))
+	},
+}
+
+func init() {
+	specShowCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	specShowCmd.PersistentFlags().String(kubernetes.FlagBootstrap, kubernetes.BootstrapInitToken, "How nodes get their first token. [init-token|approle]")
+	specShowCmd.PersistentFlags().Bool(kubernetes.FlagNodeIdentity, false, "Only allow nodes added with 'node add' to sign kubelet certificates, for their own name")
+	specShowCmd.PersistentFlags().StringSlice(kubernetes.FlagK8sRoles, []string{}, "Additional component role of the k8s PKI and the class allowed to sign it, e.g. 'metrics-server=worker'")
+
+	specCmd.AddCommand(specShowCmd)
+	RootCmd.AddCommand(specCmd)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// Exit code of status if a check is not ok
+const exitCodeProblems = 2
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status [cluster ID]",
+	Short: "Check that everything setup writes for a cluster exists, matches and is valid. Exits non-zero on problems.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper status [cluster ID]")
+		}
+
+		v, err := vault.NewClient(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		if err := setFlagsKubernetes(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsBootstrap(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsNodeIdentity(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsPKIURLs(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+		if err := setFlagsPKIKey(k, cmd); err != nil {
+			log.Fatal(err)
+		}
+
+		warnBefore, err := cmd.PersistentFlags().GetDuration(kubernetes.FlagStatusWarnBefore)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagStatusWarnBefore, err)
+		}
+
+		status, err := k.Status(warnBefore)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		format, err := cmd.PersistentFlags().GetString(kubernetes.FlagStatusFormat)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagStatusFormat, err)
+		}
+		switch format {
+		case kubernetes.PlanFormatHuman:
+			fmt.Print(status.String())
+		case kubernetes.PlanFormatJSON:
+			dat, err := status.JSON()
+			if err != nil {
+				log.Fatalf("error converting status to JSON: %v", err)
+			}
+			fmt.Println(string(dat))
+		default:
+			log.Fatalf("unknown format '%s'", format)
+		}
+
+		if status.HasProblems() {
+			os.Exit(exitCodeProblems)
+		}
+	},
+}
+
+func init() {
+	statusCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityCA, time.Hour*24*365*20, "Maxium validity for CA certificates")
+	statusCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityAdmin, time.Hour*24*365, "Maxium validity for admin certificates")
+	statusCmd.PersistentFlags().Duration(kubernetes.FlagMaxValidityComponents, time.Hour*24*30, "Maxium validity for component certificates")
+
+	statusCmd.PersistentFlags().String(kubernetes.FlagSpec, "", "Path to a YAML or JSON cluster spec (Default to built-in spec)")
+	statusCmd.PersistentFlags().StringSlice(kubernetes.FlagK8sRoles, []string{}, "Additional component role of the k8s PKI and the class allowed to sign it, e.g. 'metrics-server=worker'")
+
+	statusCmd.PersistentFlags().String(kubernetes.FlagBootstrap, kubernetes.BootstrapInitToken, "How nodes get their first token. [init-token|approle]")
+	statusCmd.PersistentFlags().Int(kubernetes.FlagAppRoleSecretIDNumUses, 1, "Number of times an AppRole secret id can be used, 0 for unlimited")
+	statusCmd.PersistentFlags().Duration(kubernetes.FlagAppRoleSecretIDTTL, 0, "Validity of AppRole secret ids, 0 for no expiry")
+	statusCmd.PersistentFlags().Bool(kubernetes.FlagNodeIdentity, false, "Only allow nodes added with 'node add' to sign kubelet certificates, for their own name")
+
+	statusCmd.PersistentFlags().String(kubernetes.FlagPKIURL, "", "Base URL of vault written into certificates to fetch CAs and CRLs from, unset URLs are left alone")
+	statusCmd.PersistentFlags().Duration(kubernetes.FlagCRLExpiry, 0, "Validity of the CRLs of the PKIs, only written if set")
+	statusCmd.PersistentFlags().String(kubernetes.FlagPKIKeyType, kubernetes.PKIKeyTypeRSA, "Type of keys the PKI roles accept in CSRs, unset roles are left alone. [rsa|ec|ed25519|any]")
+	statusCmd.PersistentFlags().Int(kubernetes.FlagPKIKeyBits, 2048, "Minimum bits of RSA keys, or EC curve the PKI roles accept (Default to 256 for EC keys)")
+
+	statusCmd.PersistentFlags().Duration(kubernetes.FlagStatusWarnBefore, time.Hour*24*30, "Warn about CAs and init tokens expiring within this time")
+	statusCmd.PersistentFlags().String(kubernetes.FlagStatusFormat, kubernetes.PlanFormatHuman, "Output format of the status. [human|json]")
+
+	RootCmd.AddCommand(statusCmd)
+}
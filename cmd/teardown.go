@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/vault-helper/pkg/kubernetes"
+)
+
+// teardownCmd represents the teardown command
+var teardownCmd = &cobra.Command{
+	Use:   "teardown [cluster ID]",
+	Short: "Remove kubernetes from a running vault server.",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := LogLevel(cmd)
+
+		if len(args) != 1 {
+			log.Fatal("wrong number of arguments given. Usage: vault-helper teardown [cluster ID]")
+		}
+
+		v, err := vault.NewClient(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		k := kubernetes.New(v, log)
+		k.SetClusterID(args[0])
+
+		var opts kubernetes.TeardownOptions
+		if opts.KeepCA, err = cmd.PersistentFlags().GetBool(kubernetes.FlagKeepCA); err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagKeepCA, err)
+		}
+		if opts.KeepSecrets, err = cmd.PersistentFlags().GetBool(kubernetes.FlagKeepSecrets); err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagKeepSecrets, err)
+		}
+
+		confirmed, err := cmd.PersistentFlags().GetBool(kubernetes.FlagConfirm)
+		if err != nil {
+			log.Fatalf("error parsing %s: %v", kubernetes.FlagConfirm, err)
+		}
+		if !confirmed {
+			if confirmed, err = confirmClusterID(os.Stdin, args[0]); err != nil {
+				log.Fatal(err)
+			}
+			if !confirmed {
+				log.Fatal("teardown not confirmed, exiting")
+			}
+		}
+
+		if err := k.Teardown(opts); err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	teardownCmd.PersistentFlags().Bool(kubernetes.FlagKeepCA, false, "Keep the PKI mounts and their CAs")
+	teardownCmd.PersistentFlags().Bool(kubernetes.FlagKeepSecrets, false, "Keep the secrets mount and the service account key")
+	teardownCmd.PersistentFlags().Bool(kubernetes.FlagConfirm, false, "Do not ask for confirmation")
+	teardownCmd.Flag(kubernetes.FlagConfirm).Shorthand = "y"
+
+	RootCmd.AddCommand(teardownCmd)
+}
+
+// Ask the user to type the cluster ID before anything gets removed
+func confirmClusterID(in io.Reader, clusterID string) (bool, error) {
+	fmt.Printf("All vault setup of cluster '%s' will be removed. Type the cluster ID to confirm: ", clusterID)
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("error reading confirmation: %v", err)
+	}
+
+	return strings.TrimSpace(answer) == clusterID, nil
+}
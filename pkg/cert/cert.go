@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
@@ -18,6 +20,7 @@ const FlagIpSans = "ip-sans"
 const FlagSanHosts = "san-hosts"
 const FlagOwner = "owner"
 const FlagGroup = "group"
+const FlagRenewBefore = "renew-before"
 
 type Cert struct {
 	role        string
@@ -25,6 +28,7 @@ type Cert struct {
 	destination string
 	bitSize     int
 	pemSize     int
+	pemKeyType  string
 	keyType     string
 	ipSans      []string
 	sanHosts    []string
@@ -32,11 +36,36 @@ type Cert struct {
 	group       string
 	data        *pem.Block
 
+	// certificates are renewed if less than the duration or fraction of
+	// their validity remains
+	renewBefore         time.Duration
+	renewBeforeFraction float64
+	// fraction the renewal threshold is extended by, spreading renewals
+	renewJitter float64
+
+	// hooks run when new certificates were stored, changed is set if their
+	// content differs from the previous certificates
+	hooks   *Hooks
+	changed bool
+
+	// keyPending is set if the key in data was generated and not stored yet
+	keyPending bool
+
 	Log           *logrus.Entry
 	instanceToken *instanceToken.InstanceToken
 }
 
 func (c *Cert) RunCert() error {
+	if err := c.verifyKeyParams(); err != nil {
+		return fmt.Errorf("error ensuring key: %v", err)
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if err := c.EnsureKey(); err != nil {
 		return fmt.Errorf("error ensuring key: %v", err)
 	}
@@ -45,13 +74,27 @@ func (c *Cert) RunCert() error {
 	//	return fmt.Errorf("error renewing tokens: %v", err)
 	//}
 
+	c.changed = false
 	if err := c.RequestCertificate(); err != nil {
 		return fmt.Errorf("error requesting certificate: %v", err)
 	}
 
+	// hooks of an earlier change run again, if they failed
+	if err := c.Hooks().RunPending(c.hookMarkerPath(), &HookEvent{
+		Path:       filepath.Clean(c.Destination() + ".pem"),
+		CommonName: c.CommonName(),
+	}); err != nil {
+		return fmt.Errorf("error running hooks: %v", err)
+	}
+
 	return nil
 }
 
+// Marks the hooks of the destination pending until they succeeded
+func (c *Cert) hookMarkerPath() string {
+	return filepath.Clean(c.Destination() + ".hook-pending")
+}
+
 //func (c *Cert) TokenRenew() error {
 //	i := instanceToken.New(c.vaultClient, c.Log)
 //
@@ -140,7 +183,8 @@ func (c *Cert) WritePermissions(path string, perm os.FileMode) error {
 func New(logger *logrus.Entry, i *instanceToken.InstanceToken) *Cert {
 	c := &Cert{
 		bitSize:       2048,
-		keyType:       "RSA",
+		keyType:       KeyTypeRSA,
+		renewBefore:   time.Hour * 72,
 		instanceToken: i,
 	}
 
@@ -186,6 +230,13 @@ func (c *Cert) PemSize() int {
 	return c.pemSize
 }
 
+func (c *Cert) SetPemKeyType(keyType string) {
+	c.pemKeyType = keyType
+}
+func (c *Cert) PemKeyType() string {
+	return c.pemKeyType
+}
+
 func (c *Cert) SetKeyType(keyType string) {
 	c.keyType = keyType
 }
@@ -221,6 +272,39 @@ func (c *Cert) Group() string {
 	return c.group
 }
 
+func (c *Cert) SetRenewBefore(renewBefore time.Duration) {
+	c.renewBefore = renewBefore
+}
+func (c *Cert) RenewBefore() time.Duration {
+	return c.renewBefore
+}
+
+func (c *Cert) SetRenewBeforeFraction(fraction float64) {
+	c.renewBeforeFraction = fraction
+}
+func (c *Cert) RenewBeforeFraction() float64 {
+	return c.renewBeforeFraction
+}
+
+func (c *Cert) SetRenewJitter(jitter float64) {
+	c.renewJitter = jitter
+}
+func (c *Cert) RenewJitter() float64 {
+	return c.renewJitter
+}
+
+func (c *Cert) SetHooks(hooks *Hooks) {
+	c.hooks = hooks
+}
+func (c *Cert) Hooks() *Hooks {
+	return c.hooks
+}
+
+// Whether the last run stored certificates differing from the previous ones
+func (c *Cert) Changed() bool {
+	return c.changed
+}
+
 func (c *Cert) SetData(data *pem.Block) {
 	c.data = data
 }
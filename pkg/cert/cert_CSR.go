@@ -7,6 +7,7 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,8 +16,19 @@ import (
 )
 
 func (c *Cert) RequestCertificate() error {
+	if c.keyPending {
+		c.Log.Info("Generating new certificates for the new key")
+		return c.createNewCerts()
+	}
+
 	if err := c.verifyCertificates(); err != nil {
-		c.Log.Debugf("Couldn't verify certificates: %v", err)
+		c.Log.Infof("Certificates need to be issued: %v", err)
+		c.Log.Info("Generating new certificates")
+		return c.createNewCerts()
+	}
+
+	if err := c.verifyCA(); err != nil {
+		c.Log.Infof("Certificates need to be issued: %v", err)
 		c.Log.Info("Generating new certificates")
 		return c.createNewCerts()
 	}
@@ -55,21 +67,56 @@ func (c *Cert) createNewCerts() error {
 		return errors.New("no ca certificate received")
 	}
 
+	// while a CA is rotated the bundle lets clients trust all its generations
+	bundle, err := c.caBundle()
+	if err != nil {
+		return err
+	}
+	certCA = mergeCerts(certCA, bundle)
+
 	c.Log.Infof("New certificate received for: %s", c.CommonName())
 
 	certPath := filepath.Clean(c.Destination() + ".pem")
 	caPath := filepath.Clean(c.Destination() + "-ca.pem")
 
-	if err := c.storeCertificate(certPath, cert); err != nil {
-		return fmt.Errorf("error storing certificate at path '%s': %v", certPath, err)
+	changed := !c.equalFile(certPath, cert) || !c.equalFile(caPath, certCA)
+
+	// the key goes first, a set interrupted after it has a certificate not
+	// matching the key, which the next run reissues
+	var files []*storeFile
+	if c.keyPending {
+		keyPath := filepath.Clean(c.Destination() + "-key.pem")
+		files = append(files, &storeFile{path: keyPath, data: pem.EncodeToMemory(c.Data()), perm: os.FileMode(0600)})
+	}
+	files = append(files,
+		&storeFile{path: certPath, data: []byte(cert), perm: os.FileMode(0644)},
+		&storeFile{path: caPath, data: []byte(certCA), perm: os.FileMode(0644)},
+	)
+	if changed {
+		if err := c.Hooks().MarkPending(c.hookMarkerPath()); err != nil {
+			return err
+		}
 	}
-	if err := c.storeCertificate(caPath, certCA); err != nil {
-		return fmt.Errorf("error storing ca certificate at path '%s': %v", caPath, err)
+	if err := c.storeFiles(files); err != nil {
+		return fmt.Errorf("error storing certificates at '%s': %v", c.Destination(), err)
+	}
+	c.keyPending = false
+
+	if changed {
+		c.changed = true
+	} else {
+		c.Log.Infof("Certificates at %s are unchanged", c.Destination())
 	}
 
 	return nil
 }
 
+// Whether the file exists with the content
+func (c *Cert) equalFile(path, content string) bool {
+	dat, err := ioutil.ReadFile(path)
+	return err == nil && string(dat) == content
+}
+
 func (c *Cert) checkExistingCerts(path string) (exist bool, err error) {
 	fi, err := os.Stat(path)
 
@@ -91,24 +138,6 @@ func (c *Cert) checkExistingCerts(path string) (exist bool, err error) {
 	return true, nil
 }
 
-func (c *Cert) verifyCertificates() error {
-	conf := vault.DefaultConfig()
-	conf.Address = c.InstanceToken().VaultClient().Address()
-
-	tConf := &vault.TLSConfig{
-		CAPath:     filepath.Clean(filepath.Dir(c.Destination())),
-		CACert:     filepath.Clean(c.Destination() + "-ca.pem"),
-		ClientCert: filepath.Clean(c.Destination() + ".pem"),
-		ClientKey:  filepath.Clean(c.Destination() + "-key.pem"),
-	}
-
-	if err := conf.ConfigureTLS(tConf); err != nil {
-		return fmt.Errorf("error verifying cert: %v", err)
-	}
-
-	return nil
-}
-
 func (c *Cert) decodeSec(sec *vault.Secret) (cert string, certCA string, err error) {
 	if sec == nil {
 		return "", "", errors.New("no secret returned from vault")
@@ -148,16 +177,22 @@ func (c *Cert) createCSR() (csr []byte, err error) {
 	names := pkix.Name{
 		CommonName: c.CommonName(),
 	}
-	var csrTemplate = x509.CertificateRequest{
-		Subject:            names,
-		SignatureAlgorithm: x509.SHA512WithRSA,
-	}
 
-	key, err := x509.ParsePKCS1PrivateKey(c.Data().Bytes)
+	key, err := parsePrivateKey(c.Data())
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key bytes: %v", err)
 	}
 
+	algorithm, err := signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var csrTemplate = x509.CertificateRequest{
+		Subject:            names,
+		SignatureAlgorithm: algorithm,
+	}
+
 	csrCertificate, err := x509.CreateCertificateRequest(rand.Reader, &csrTemplate, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create CSR: %v", err)
@@ -185,23 +220,3 @@ func (c *Cert) writeCSR(path string, data map[string]interface{}) (secret *vault
 
 	return c.InstanceToken().VaultClient().Logical().Write(path, data)
 }
-
-func (c *Cert) storeCertificate(path, cert string) error {
-	fi, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("failed to open file '%s': %v", path, err)
-	}
-	defer fi.Close()
-
-	if _, err := fi.Write([]byte(cert)); err != nil {
-		return fmt.Errorf("failed to write certificate to file '%s': %v", path, err)
-	}
-
-	if err := c.WritePermissions(path, os.FileMode(0644)); err != nil {
-		return fmt.Errorf("failed to set permissons of certificate file '%s': %s", path, err)
-	}
-
-	c.Log.Infof("Certificate written to: %s", path)
-
-	return nil
-}
@@ -0,0 +1,188 @@
+package cert
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jetstack/vault-helper/pkg/kv"
+)
+
+// Roles of cluster PKIs, the mount is <cluster>/pki/<pki> or one of its
+// generations <cluster>/pki/<pki>-genN while the CA is rotated
+var pkiRolePath = regexp.MustCompile(`^([^/]+)/pki/([^/]+)/(sign|issue)/`)
+var pkiGeneration = regexp.MustCompile(`-gen[0-9]+$`)
+
+// The mount, cluster and PKI name of the role, empty for roles outside of
+// cluster PKIs
+func (c *Cert) rolePKI() (mount, cluster, pkiName string) {
+	match := pkiRolePath.FindStringSubmatch(strings.Trim(filepath.Clean(c.Role()), "/"))
+	if match == nil {
+		return "", "", ""
+	}
+	return filepath.Join(match[1], "pki", match[2]), match[1], pkiGeneration.ReplaceAllString(match[2], "")
+}
+
+// The trust bundle of the role's PKI, holding all CA generations and their
+// cross-signed certificates. It is only published while a CA is rotated and
+// empty otherwise or if the token may not read it.
+func (c *Cert) caBundle() (string, error) {
+	_, cluster, pkiName := c.rolePKI()
+	if pkiName == "" {
+		return "", nil
+	}
+
+	logical := c.InstanceToken().VaultClient().Logical()
+	mountPath, version, err := kv.MountVersion(logical, filepath.Join(cluster, "secrets"))
+	if err != nil {
+		return "", err
+	}
+	if mountPath == "" {
+		mountPath = filepath.Join(cluster, "secrets")
+	}
+
+	path := kv.DataPath(mountPath, version, fmt.Sprintf("ca_bundle_%s", pkiName))
+	sec, err := logical.Read(path)
+	if err != nil && strings.Contains(err.Error(), "Code: 403") {
+		c.Log.Warnf("Not allowed to read the CA bundle at '%s', only the issuing CA is stored", path)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading CA bundle '%s': %v", path, err)
+	}
+	if version == 2 {
+		sec = kv.Unwrap(sec)
+	}
+	if sec == nil || sec.Data == nil {
+		return "", nil
+	}
+
+	bundle, _ := sec.Data["certificate"].(string)
+	return bundle, nil
+}
+
+// The CA certificate of the role's mount, which issues new certificates
+func (c *Cert) mountCA() (string, error) {
+	mount, _, _ := c.rolePKI()
+	if mount == "" {
+		return "", nil
+	}
+
+	path := filepath.Join(mount, "cert", "ca")
+	sec, err := c.InstanceToken().VaultClient().Logical().Read(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading CA certificate '%s': %v", path, err)
+	}
+	if sec == nil || sec.Data == nil {
+		return "", nil
+	}
+
+	ca, _ := sec.Data["certificate"].(string)
+	return ca, nil
+}
+
+// Make sure the certificate was issued by the CA of the role's mount and the
+// stored CA file holds that CA and the trust bundle, both change when a CA is
+// rotated or retired. Certificates are kept if vault can't tell.
+func (c *Cert) verifyCA() error {
+	ca, err := c.mountCA()
+	if err != nil {
+		c.Log.Warnf("Unable to check the CA of the certificates: %v", err)
+		return nil
+	}
+	if ca == "" {
+		return nil
+	}
+	bundle, err := c.caBundle()
+	if err != nil {
+		c.Log.Warnf("Unable to check the CA of the certificates: %v", err)
+		return nil
+	}
+
+	certPath := filepath.Clean(c.Destination() + ".pem")
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("error reading certificate: %v", err)
+	}
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate '%s': %v", certPath, err)
+	}
+	roots, err := parseCertificates([]byte(ca))
+	if err != nil {
+		return fmt.Errorf("error parsing CA certificate of '%s': %v", c.Role(), err)
+	}
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("certificate '%s' is not issued by the CA of '%s': %v", certPath, c.Role(), err)
+	}
+
+	caPath := filepath.Clean(c.Destination() + "-ca.pem")
+	dat, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return fmt.Errorf("error reading ca certificate: %v", err)
+	}
+	stored := pemCertificates(string(dat))
+	for _, block := range pemCertificates(mergeCerts(ca, bundle)) {
+		if !containsBlock(stored, block) {
+			return fmt.Errorf("ca certificate '%s' is missing certificates of the CA bundle of '%s'", caPath, c.Role())
+		}
+	}
+
+	return nil
+}
+
+// The certificates of a followed by those of b missing in a
+func mergeCerts(a, b string) string {
+	if b == "" {
+		return a
+	}
+
+	blocks := pemCertificates(a)
+	for _, block := range pemCertificates(b) {
+		if !containsBlock(blocks, block) {
+			blocks = append(blocks, block)
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		buf.Write(pem.EncodeToMemory(block))
+	}
+	return buf.String()
+}
+
+func pemCertificates(dat string) []*pem.Block {
+	var blocks []*pem.Block
+	rest := []byte(dat)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return blocks
+		}
+		if block.Type == "CERTIFICATE" {
+			blocks = append(blocks, block)
+		}
+	}
+}
+
+func containsBlock(blocks []*pem.Block, block *pem.Block) bool {
+	for _, b := range blocks {
+		if bytes.Equal(b.Bytes, block.Bytes) {
+			return true
+		}
+	}
+	return false
+}
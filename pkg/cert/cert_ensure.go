@@ -1,18 +1,23 @@
 package cert
 
 import (
-	"bufio"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 )
 
-// Ensure -key.pem exists, and has correct size and key type
+// Ensure -key.pem exists, and has correct size and key type. A missing or
+// wrong key is replaced by a new key, which is only stored along with the
+// certificate issued for it.
 func (c *Cert) EnsureKey() error {
+	c.keyPending = false
+
+	if err := c.verifyKeyParams(); err != nil {
+		return err
+	}
+
 	if err := c.ensureDestination(); err != nil {
 		return fmt.Errorf("error ensuring destination: %v", err)
 	}
@@ -24,35 +29,26 @@ func (c *Cert) EnsureKey() error {
 	if err != nil && os.IsNotExist(err) {
 		c.Log.Debug("Pem file doesn't exist")
 		c.Log.Infof("Key doesn't exist at path: %s", path)
-		if err := c.genAndWriteKey(path); err != nil {
-			return err
-		}
-		return c.WritePermissions(path, os.FileMode(0600))
+		return c.genKey()
 	}
 
 	//Path Exists
 	c.Log.Debug("Pem file exists '-key.pem'")
 	if err := c.loadKeyFromFile(path); err != nil {
-		return fmt.Errorf("failed to load rsa key from file '%s': %v", path, err)
+		return fmt.Errorf("failed to load key from file '%s': %v", path, err)
 	}
 
-	if c.KeyType() != c.Data().Type {
-		c.Log.Warn("key doesn't match expected type at path '%s'. exp=%s got=%s", path, c.KeyType(), c.Data().Type)
+	// validated above
+	keyType, _ := ParseKeyType(c.KeyType())
+	if keyType != c.PemKeyType() {
+		c.Log.Warnf("key doesn't match expected type at path '%s'. exp=%s got=%s", path, keyType, c.PemKeyType())
 		// Wrong key type
-		// Delete File, Generate new and write to file
-		if err := c.DeleteFile(path); err != nil {
-			return err
-		}
-		return c.genAndWriteKey(path)
+		return c.genKey()
 	}
-	if c.BitSize() != c.PemSize() {
+	if keyType != KeyTypeEd25519 && c.BitSize() != c.PemSize() {
 		c.Log.Infof("key doesn't match expected size at path '%s'. exp=%d got=%d", path, c.BitSize(), c.PemSize())
 		//Wrong bit size
-		// Delete file, generate new and write to file
-		if err := c.DeleteFile(path); err != nil {
-			return err
-		}
-		return c.genAndWriteKey(path)
+		return c.genKey()
 	}
 
 	return c.WritePermissions(path, os.FileMode(0600))
@@ -70,7 +66,9 @@ func (c *Cert) ensureDestination() error {
 
 	// Path doesn't exist
 	if err != nil && os.IsNotExist(err) {
-		os.MkdirAll(dir, os.FileMode(0750))
+		if err := os.MkdirAll(dir, os.FileMode(0750)); err != nil {
+			return fmt.Errorf("failed to create directory '%s': %v", dir, err)
+		}
 		c.Log.Debugf("Destination directory doesn't exist. Directory created: %s", dir)
 		return nil
 	}
@@ -90,100 +88,57 @@ func (c *Cert) ensureDestination() error {
 	return nil
 }
 
-//Generate new key and write to file
-func (c *Cert) genAndWriteKey(path string) error {
-	c.Log.Infof("Generating new RSA key")
+// Generate new key, pending until certificates are issued for it
+func (c *Cert) genKey() error {
+	c.Log.Infof("Generating new %s key", c.KeyType())
 	if err := c.generateKey(); err != nil {
 		return fmt.Errorf("error generating key: %v", err)
 	}
-
-	if err := c.writeKeyToFile(path); err != nil {
-		return fmt.Errorf("error saving key to file '%s': %v", path, err)
-	}
-	c.Log.Infof("Key written to file: %s", path)
+	c.keyPending = true
 
 	return nil
 }
 
 func (c *Cert) loadKeyFromFile(path string) error {
-
-	// Load PEM
-	pemfile, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("unable to open file for reading '%s': %v", path, err)
-	}
-
-	// need to convert pemfile to []byte for decoding
-	pemfileinfo, err := pemfile.Stat()
+	pembytes, err := ioutil.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("unable to get pem file info '%s': %v", path, err)
+		return fmt.Errorf("unable to read file '%s': %v", path, err)
 	}
 
-	size := pemfileinfo.Size()
-	pembytes := make([]byte, size)
-
-	// read pemfile content into pembytes
-	buffer := bufio.NewReader(pemfile)
-	_, err = buffer.Read(pembytes)
-	if err != nil {
-		return fmt.Errorf("unable to read pembyte from file: %v", err)
+	data, _ := pem.Decode(pembytes)
+	if data == nil {
+		return fmt.Errorf("failed to decode pem file '%s'", path)
 	}
 
-	data, rest := pem.Decode([]byte(pembytes))
+	k, err := parsePrivateKey(data)
 	if err != nil {
-		return fmt.Errorf("failed to decode pem file. There was data left: %s", rest)
+		return fmt.Errorf("failed to parse private key bytes: %v", err)
 	}
 
-	k, err := x509.ParsePKCS1PrivateKey(data.Bytes)
+	keyType, size, err := keyTypeAndSize(k)
 	if err != nil {
-		return fmt.Errorf("failed to parse private key bytes: %v", err)
+		return err
 	}
 
-	c.SetPemSize(k.N.BitLen())
-
+	c.SetPemKeyType(keyType)
+	c.SetPemSize(size)
 	c.SetData(data)
-	c.SetKeyType(data.Type)
-
-	pemfile.Close()
-	if err != nil {
-		return fmt.Errorf("unable to close pemfile: %v", err)
-	}
 
 	return nil
 }
 
 func (c *Cert) generateKey() error {
-	size := c.BitSize()
-	key, err := rsa.GenerateKey(rand.Reader, size)
+	keyType, err := ParseKeyType(c.KeyType())
 	if err != nil {
-		return fmt.Errorf("failed to generate rsa key: %v", err)
-	}
-
-	key_bytes := x509.MarshalPKCS1PrivateKey(key)
-	key_pem := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: key_bytes,
+		return err
 	}
 
-	c.SetData(key_pem)
-
-	return nil
-}
-
-// Save PEM file
-func (c *Cert) writeKeyToFile(path string) error {
-	pemfile, err := os.Create(path)
+	key_pem, err := generatePrivateKey(keyType, c.BitSize())
 	if err != nil {
-		return fmt.Errorf("failed to create pem key file for writting: %v", err)
+		return err
 	}
 
-	if err := pem.Encode(pemfile, c.Data()); err != nil {
-		return fmt.Errorf("failed to encode key to pem file at'%s': %v", path, err)
-	}
-
-	if err := pemfile.Close(); err != nil {
-		return fmt.Errorf("error closing pem file at '%s': %v", path, err)
-	}
+	c.SetData(key_pem)
 
 	return nil
 }
@@ -0,0 +1,172 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const KeyTypeRSA = "RSA"
+const KeyTypeECDSA = "ECDSA"
+const KeyTypeEd25519 = "Ed25519"
+
+// Match a key type case-insensitively, returning its canonical name
+func ParseKeyType(value string) (string, error) {
+	for _, keyType := range []string{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		if strings.EqualFold(value, keyType) {
+			return keyType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key type '%s', expected '%s', '%s' or '%s'", value, KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519)
+}
+
+// Default bit size of a key type, used if none is given. Ed25519 keys have a
+// fixed size.
+func DefaultBitSize(keyType string) int {
+	switch keyType {
+	case KeyTypeECDSA:
+		return 256
+	case KeyTypeEd25519:
+		return 0
+	}
+	return 2048
+}
+
+// For ECDSA keys the bit size selects the curve
+func ellipticCurve(bitSize int) (elliptic.Curve, error) {
+	switch bitSize {
+	case 256:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	}
+	return nil, fmt.Errorf("unsupported ECDSA key size %d, expected 256 (P-256) or 384 (P-384)", bitSize)
+}
+
+// Make sure the key type and bit size can be used to generate a key
+func (c *Cert) verifyKeyParams() error {
+	keyType, err := ParseKeyType(c.KeyType())
+	if err != nil {
+		return err
+	}
+
+	switch keyType {
+	case KeyTypeRSA:
+		if c.BitSize() < 2048 {
+			return fmt.Errorf("RSA key size %d is too small, expected at least 2048", c.BitSize())
+		}
+	case KeyTypeECDSA:
+		if _, err := ellipticCurve(c.BitSize()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Generate a private key of the key type, RSA keys are PKCS#1, ECDSA keys
+// SEC1 and Ed25519 keys PKCS#8 encoded
+func generatePrivateKey(keyType string, bitSize int) (*pem.Block, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		key, err := rsa.GenerateKey(rand.Reader, bitSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate rsa key: %v", err)
+		}
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+
+	case KeyTypeECDSA:
+		curve, err := ellipticCurve(bitSize)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ecdsa key: %v", err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ecdsa key: %v", err)
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate ed25519 key: %v", err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal ed25519 key: %v", err)
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+
+	return nil, fmt.Errorf("unknown key type '%s'", keyType)
+}
+
+// Parse a PKCS#1, SEC1 or PKCS#8 encoded private key
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	if block == nil {
+		return nil, errors.New("no key data")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported PKCS#8 key %T", key)
+		}
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+}
+
+// The key type and bit size of a private key
+func keyTypeAndSize(key crypto.Signer) (string, int, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return KeyTypeRSA, k.N.BitLen(), nil
+	case *ecdsa.PrivateKey:
+		return KeyTypeECDSA, k.Curve.Params().BitSize, nil
+	case ed25519.PrivateKey:
+		return KeyTypeEd25519, 0, nil
+	}
+	return "", 0, fmt.Errorf("unsupported key %T", key)
+}
+
+// The CSR signature algorithm matching a private key
+func signatureAlgorithm(key crypto.Signer) (x509.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return x509.SHA512WithRSA, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			return x509.ECDSAWithSHA256, nil
+		case 384:
+			return x509.ECDSAWithSHA384, nil
+		}
+		return x509.ECDSAWithSHA512, nil
+	case ed25519.PrivateKey:
+		return x509.PureEd25519, nil
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported key %T", key)
+}
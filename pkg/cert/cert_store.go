@@ -0,0 +1,201 @@
+package cert
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Renames files, replaced by tests to fail in the middle of a set
+var rename = os.Rename
+
+// storeFile is a file of the set stored for a destination
+type storeFile struct {
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// Store the files of a destination as a set. They are written to synced
+// temporary files with their permissions and ownership set, the existing key,
+// certificate and CA are kept as .bak, before the files are renamed into
+// place in the given order. If a rename fails the previous set is restored.
+// A run interrupted between the renames leaves a mixed set, the .bak files
+// are the complete previous one.
+func (c *Cert) storeFiles(files []*storeFile) error {
+	var temps []string
+	defer func() {
+		for _, temp := range temps {
+			os.Remove(temp)
+		}
+	}()
+
+	for _, f := range files {
+		temp, err := c.writeTempFile(f)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, temp)
+	}
+
+	backups, err := c.backupFiles()
+	if err != nil {
+		return err
+	}
+
+	for n, f := range files {
+		if err := rename(temps[n], f.path); err != nil {
+			err = fmt.Errorf("failed to rename '%s' to '%s': %v", temps[n], f.path, err)
+			if rerr := c.restoreFiles(files[:n], backups); rerr != nil {
+				return fmt.Errorf("%v, restoring previous files failed: %v", err, rerr)
+			}
+			return err
+		}
+	}
+	temps = nil
+
+	if err := syncDir(filepath.Dir(c.Destination())); err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		c.Log.Infof("File written to: %s", f.path)
+	}
+
+	return nil
+}
+
+// Write the data to a synced temporary file next to the destination
+func (c *Cert) writeTempFile(f *storeFile) (string, error) {
+	file, err := ioutil.TempFile(filepath.Dir(f.path), "."+filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file for '%s': %v", f.path, err)
+	}
+	path := file.Name()
+
+	if _, err := file.Write(f.data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("failed to write temporary file '%s': %v", path, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", fmt.Errorf("failed to sync temporary file '%s': %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("failed to close temporary file '%s': %v", path, err)
+	}
+
+	if err := c.WritePermissions(path, f.perm); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Keep the existing key, certificate and CA as a set of .bak files, by hard
+// linking them. Returns which of the files existed.
+func (c *Cert) backupFiles() (map[string]bool, error) {
+	backups := map[string]bool{}
+	for _, path := range c.setPaths() {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			os.Remove(path + ".bak")
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read file at location '%s': %v", path, err)
+		}
+
+		if err := os.Remove(path + ".bak"); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove backup '%s.bak': %v", path, err)
+		}
+		if err := os.Link(path, path+".bak"); err != nil {
+			return nil, fmt.Errorf("failed to back up '%s': %v", path, err)
+		}
+		backups[path] = true
+	}
+
+	return backups, nil
+}
+
+// Put the backups of files already renamed into place back
+func (c *Cert) restoreFiles(files []*storeFile, backups map[string]bool) error {
+	for _, f := range files {
+		if !backups[f.path] {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		temp := f.path + ".restore"
+		if err := os.Link(f.path+".bak", temp); err != nil {
+			return err
+		}
+		if err := rename(temp, f.path); err != nil {
+			os.Remove(temp)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The key, certificate and CA of the destination
+func (c *Cert) setPaths() []string {
+	return []string{
+		filepath.Clean(c.Destination() + "-key.pem"),
+		filepath.Clean(c.Destination() + ".pem"),
+		filepath.Clean(c.Destination() + "-ca.pem"),
+	}
+}
+
+// Make renames in the directory durable
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open directory '%s': %v", path, err)
+	}
+	defer dir.Close()
+
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// Take the advisory lock of the destination, waiting for other runs holding
+// it. The returned function releases the lock.
+func (c *Cert) lock() (func(), error) {
+	if err := c.ensureDestination(); err != nil {
+		return nil, fmt.Errorf("error ensuring destination: %v", err)
+	}
+
+	path := filepath.Clean(c.Destination() + ".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file '%s': %v", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == syscall.EWOULDBLOCK {
+		c.Log.Infof("Waiting for lock '%s' held by another run", path)
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to lock '%s': %v", path, err)
+		}
+	} else if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock '%s': %v", path, err)
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
@@ -1,13 +1,18 @@
 package cert
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
@@ -304,6 +309,584 @@ func TestCert_Exist_NoChange(t *testing.T) {
 	}
 }
 
+// Test keys of all types are generated, loaded and sign CSRs
+func TestCert_Key_Types(t *testing.T) {
+	for _, kt := range []struct {
+		keyType   string
+		bitSize   int
+		algorithm x509.SignatureAlgorithm
+	}{
+		{KeyTypeRSA, 2048, x509.SHA512WithRSA},
+		{KeyTypeECDSA, 256, x509.ECDSAWithSHA256},
+		{KeyTypeECDSA, 384, x509.ECDSAWithSHA384},
+		{KeyTypeEd25519, 0, x509.PureEd25519},
+	} {
+		c, _ := initCert(t, vaultDev)
+		c.SetKeyType(kt.keyType)
+		c.SetBitSize(kt.bitSize)
+
+		if err := c.EnsureKey(); err != nil {
+			t.Fatalf("%s %d: error ensuring key: %v", kt.keyType, kt.bitSize, err)
+		}
+		// the new key is stored with its certificate
+		if err := c.storeFiles([]*storeFile{{path: c.Destination() + "-key.pem", data: pem.EncodeToMemory(c.Data()), perm: 0600}}); err != nil {
+			t.Fatalf("%s %d: error storing key: %v", kt.keyType, kt.bitSize, err)
+		}
+		if err := c.loadKeyFromFile(c.Destination() + "-key.pem"); err != nil {
+			t.Fatalf("%s %d: error loading key: %v", kt.keyType, kt.bitSize, err)
+		}
+		if c.PemKeyType() != kt.keyType || c.PemSize() != kt.bitSize {
+			t.Errorf("%s %d: unexpected key loaded, got=%s %d", kt.keyType, kt.bitSize, c.PemKeyType(), c.PemSize())
+		}
+
+		csrPEM, err := c.createCSR()
+		if err != nil {
+			t.Fatalf("%s %d: error creating CSR: %v", kt.keyType, kt.bitSize, err)
+		}
+		block, _ := pem.Decode(csrPEM)
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			t.Fatalf("%s %d: error parsing CSR: %v", kt.keyType, kt.bitSize, err)
+		}
+		if csr.SignatureAlgorithm != kt.algorithm {
+			t.Errorf("%s %d: unexpected signature algorithm, exp=%s got=%s", kt.keyType, kt.bitSize, kt.algorithm, csr.SignatureAlgorithm)
+		}
+		if err := csr.CheckSignature(); err != nil {
+			t.Errorf("%s %d: invalid CSR signature: %v", kt.keyType, kt.bitSize, err)
+		}
+	}
+
+	c, _ := initCert(t, vaultDev)
+	c.SetKeyType(KeyTypeECDSA)
+	c.SetBitSize(2048)
+	if err := c.EnsureKey(); err == nil {
+		t.Error("expected an error for an unsupported curve")
+	}
+}
+
+// Test existing keys of another type get replaced, and PKCS#8 keys are kept
+func TestCert_Key_Type_Change(t *testing.T) {
+	c, _ := initCert(t, vaultDev)
+	keyPem := c.Destination() + "-key.pem"
+
+	key, err := generatePrivateKey(KeyTypeRSA, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(keyPem, pem.EncodeToMemory(key), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c.SetKeyType("ecdsa")
+	c.SetBitSize(256)
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	signer, err := parsePrivateKey(c.Data())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyType, _, _ := keyTypeAndSize(signer); keyType != KeyTypeECDSA || !c.keyPending {
+		t.Errorf("expected the RSA key to be replaced, got=%s", keyType)
+	}
+	// the RSA key is kept until a certificate is issued for the new key
+	if dat, err := ioutil.ReadFile(keyPem); err != nil || string(dat) != string(pem.EncodeToMemory(key)) {
+		t.Errorf("expected the RSA key to be kept: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dat := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(keyPem, dat, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := c.EnsureKey(); err != nil {
+		t.Fatalf("error ensuring key: %v", err)
+	}
+	if c.keyPending {
+		t.Error("unexpected new key replacing the PKCS#8 key")
+	}
+	after, err := ioutil.ReadFile(keyPem)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(after) != string(dat) {
+		t.Error("expected the PKCS#8 key to be kept")
+	}
+}
+
+// Test EC certificates are issued by PKI roles accepting EC keys
+func TestCert_ECDSA_Int(t *testing.T) {
+	k := kubernetes.New(vaultDev.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test-cluster-ec")
+	k.PKIKeyType = kubernetes.PKIKeyTypeEC
+	k.PKIKeyBits = 256
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring kubernetes: %v", err)
+	}
+
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+	c.SetKeyType(KeyTypeECDSA)
+	c.SetBitSize(384)
+
+	// RSA roles refuse EC keys
+	if err := c.RunCert(); err == nil {
+		t.Error("expected an error signing an EC key with an RSA role")
+	}
+
+	c.SetRole("test-cluster-ec/pki/k8s/sign/kube-apiserver")
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+
+	dat, err := ioutil.ReadFile(c.Destination() + ".pem")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	block, _ := pem.Decode(dat)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("unexpected public key algorithm, exp=%s got=%s", x509.ECDSA, cert.PublicKeyAlgorithm)
+	}
+}
+
+// Test certificates are reissued if they don't match the request or are
+// about to expire
+func TestCert_Renew(t *testing.T) {
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("failed to set token for test: %v", err)
+	}
+	c.SetIPSans([]string{"127.0.0.1"})
+	c.SetSanHosts([]string{"kubernetes"})
+
+	dotPem := filepath.Clean(c.Destination() + ".pem")
+	run := func() string {
+		if err := c.RunCert(); err != nil {
+			t.Fatalf("error running cert: %v", err)
+		}
+		dat, err := ioutil.ReadFile(dotPem)
+		if err != nil {
+			t.Fatalf("error reading from certificate file path: '%s': %v", dotPem, err)
+		}
+		return string(dat)
+	}
+
+	cert := run()
+	if err := c.verifyCertificates(); err != nil {
+		t.Fatalf("unexpected error verifying certificates: %v", err)
+	}
+
+	c.SetRenewBefore(0)
+	c.SetRenewBeforeFraction(0.5)
+	if run() != cert {
+		t.Error("unexpected renewal of certificate with more than half of its validity remaining")
+	}
+
+	c.SetRenewBefore(time.Hour * 24 * 31)
+	renewed := run()
+	if renewed == cert {
+		t.Error("expected certificate expiring within the renewal duration to be renewed")
+	}
+	c.SetRenewBefore(0)
+
+	c.SetSanHosts([]string{"kubernetes", "kubernetes.default"})
+	cert, renewed = renewed, run()
+	if renewed == cert {
+		t.Error("expected certificate to be renewed for a new DNS san")
+	}
+
+	c.SetIPSans(nil)
+	cert, renewed = renewed, run()
+	if renewed == cert {
+		t.Error("expected certificate to be renewed without IP san")
+	}
+
+	c.SetCommonName("kube-apiserver")
+	cert, renewed = renewed, run()
+	if renewed == cert {
+		t.Error("expected certificate to be renewed for a new common name")
+	}
+
+	// a key not matching the certificate
+	keyPem := c.Destination() + "-key.pem"
+	key, err := generatePrivateKey(KeyTypeRSA, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(keyPem, pem.EncodeToMemory(key), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.verifyCertificates(); err == nil {
+		t.Error("expected an error verifying a certificate with another key")
+	}
+
+	// a CA not issuing the certificate
+	if err := ioutil.WriteFile(c.Destination()+"-ca.pem", []byte(cert), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run() == renewed {
+		t.Error("expected certificate to be renewed with another CA")
+	}
+}
+
+func TestCert_Verify_Validity(t *testing.T) {
+	now := time.Now()
+	cert := &x509.Certificate{
+		NotBefore: now.Add(-time.Hour * 24 * 20),
+		NotAfter:  now.Add(time.Hour * 24 * 10),
+	}
+
+	for _, v := range []struct {
+		value string
+		renew bool
+	}{
+		{"0s", false},
+		{"72h", false},
+		{"264h", true},
+		{"30%", false},
+		{"34%", true},
+	} {
+		c := New(nil, nil)
+		renewBefore, fraction, err := ParseRenewBefore(v.value)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", v.value, err)
+		}
+		c.SetRenewBefore(renewBefore)
+		c.SetRenewBeforeFraction(fraction)
+		if err := c.verifyValidity(cert, now); (err != nil) != v.renew {
+			t.Errorf("%s: unexpected renewal result: %v", v.value, err)
+		}
+	}
+
+	c := New(nil, nil)
+	if err := c.verifyValidity(cert, now.Add(time.Hour*24*11)); err == nil {
+		t.Error("expected an error for an expired certificate")
+	}
+
+	for _, value := range []string{"100%", "-1h", "soon"} {
+		if _, _, err := ParseRenewBefore(value); err == nil {
+			t.Errorf("%s: expected an error parsing the renewal threshold", value)
+		}
+	}
+}
+
+func TestCert_Hooks(t *testing.T) {
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("failed to set token for test: %v", err)
+	}
+
+	// the hook appends the path of the changed certificate
+	out := filepath.Join(filepath.Dir(c.Destination()), "hook.out")
+	hooks := NewHooks(c.Log)
+	hooks.Exec = "echo $VAULT_HELPER_PATH >> " + out
+	c.SetHooks(hooks)
+
+	runs := func() int {
+		if err := c.RunCert(); err != nil {
+			t.Fatalf("error running cert: %v", err)
+		}
+		dat, err := ioutil.ReadFile(out)
+		if os.IsNotExist(err) {
+			return 0
+		} else if err != nil {
+			t.Fatalf("error reading hook output: %v", err)
+		}
+		return strings.Count(string(dat), c.Destination()+".pem\n")
+	}
+
+	if n := runs(); n != 1 || !c.Changed() {
+		t.Errorf("expected hook to run for issued certificate, ran %d times", n)
+	}
+	if n := runs(); n != 1 || c.Changed() {
+		t.Errorf("unexpected hook run for unchanged certificate, ran %d times", n)
+	}
+
+	c.SetRenewBefore(time.Hour * 24 * 31)
+	if n := runs(); n != 2 {
+		t.Errorf("expected hook to run for renewed certificate, ran %d times", n)
+	}
+	c.SetRenewBefore(0)
+
+	hooks.Exec = "exit 1"
+	hooks.Retries = 1
+	hooks.RetryDelay = time.Millisecond
+	os.Remove(c.Destination() + ".pem")
+	if err := c.RunCert(); err == nil {
+		t.Error("expected error of failing hook")
+	}
+
+	// the failed hooks stay pending for the next run, although the
+	// certificate is stored and doesn't change anymore
+	marker := c.Destination() + ".hook-pending"
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected pending hooks marker: %v", err)
+	}
+	hooks.Exec = "echo $VAULT_HELPER_PATH >> " + out
+	if n := runs(); n != 3 || c.Changed() {
+		t.Errorf("expected pending hook to run for unchanged certificate, ran %d times", n)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected pending hooks marker to be removed: %v", err)
+	}
+	if n := runs(); n != 3 {
+		t.Errorf("unexpected hook run after pending hooks succeeded, ran %d times", n)
+	}
+}
+
+// Test files are replaced as a set, keeping the previous set as backup
+func TestCert_Store(t *testing.T) {
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("failed to set token for test: %v", err)
+	}
+
+	read := func(path string) string {
+		dat, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading '%s': %v", path, err)
+		}
+		return string(dat)
+	}
+
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	before := map[string]string{}
+	for _, path := range c.setPaths() {
+		before[path] = read(path)
+	}
+
+	// a new key replaces the whole set
+	c.SetBitSize(4096)
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	for _, path := range c.setPaths() {
+		if read(path+".bak") != before[path] {
+			t.Errorf("expected previous file as backup of '%s'", path)
+		}
+		// the CA is the same
+		if read(path) == before[path] && path != c.Destination()+"-ca.pem" {
+			t.Errorf("expected '%s' to be replaced", path)
+		}
+	}
+	checkFilePerm(t, c.Destination()+"-key.pem", os.FileMode(0600))
+	checkFilePerm(t, c.Destination()+".pem", os.FileMode(0644))
+	if err := c.verifyCertificates(); err != nil {
+		t.Errorf("unexpected error verifying stored set: %v", err)
+	}
+
+	temps, err := filepath.Glob(filepath.Join(filepath.Dir(c.Destination()), ".*.tmp*"))
+	if err != nil || len(temps) != 0 {
+		t.Errorf("unexpected temporary files left: %v %v", temps, err)
+	}
+
+	// a failing rename restores the files already renamed
+	certPem := c.Destination() + ".pem"
+	cert := read(certPem)
+	blocked := filepath.Join(filepath.Dir(c.Destination()), "blocked")
+	if err := os.MkdirAll(filepath.Join(blocked, "dir"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.storeFiles([]*storeFile{
+		{path: certPem, data: []byte("new"), perm: 0644},
+		{path: blocked, data: []byte("new"), perm: 0644},
+	}); err == nil {
+		t.Error("expected error renaming over a directory")
+	}
+	if read(certPem) != cert {
+		t.Error("expected certificate to be restored")
+	}
+
+	// runs wait for the lock of the destination
+	unlock, err := c.lock()
+	if err != nil {
+		t.Fatalf("error locking: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		other := *c
+		done <- other.RunCert()
+	}()
+	select {
+	case <-done:
+		t.Error("expected run to wait for the lock")
+	case <-time.After(500 * time.Millisecond):
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// A set failing after the key has been renamed into place is rolled back,
+// Test the CA file holds the trust bundle while a CA is rotated and
+// certificates are reissued by the active CA once it is retired
+func TestCert_CA_Rotate(t *testing.T) {
+	k := kubernetes.New(vaultDev.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test-cluster-rotate")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring kubernetes: %v", err)
+	}
+
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+	c.SetRole("test-cluster-rotate/pki/k8s/sign/kube-apiserver")
+
+	caCerts := func() []*x509.Certificate {
+		dat, err := ioutil.ReadFile(c.Destination() + "-ca.pem")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		certs, err := parseCertificates(dat)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return certs
+	}
+
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	if exp, act := 1, len(caCerts()); exp != act {
+		t.Errorf("unexpected number of CA certificates, exp=%d got=%d", exp, act)
+	}
+
+	if err := k.RotatePKI("k8s"); err != nil {
+		t.Fatalf("error rotating: %v", err)
+	}
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	if !c.Changed() {
+		t.Error("expected the CA file to change while rotating")
+	}
+	if exp, act := 4, len(caCerts()); exp != act {
+		t.Errorf("unexpected number of CA certificates while rotating, exp=%d got=%d", exp, act)
+	}
+
+	if err := k.RetirePKI("k8s"); err != nil {
+		t.Fatalf("error retiring: %v", err)
+	}
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	if !c.Changed() {
+		t.Error("expected the certificates to be reissued after retiring")
+	}
+	ca := caCerts()
+	if exp, act := 1, len(ca); exp != act {
+		t.Fatalf("unexpected number of CA certificates after retiring, exp=%d got=%d", exp, act)
+	}
+	if err := c.verifyCertificates(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := c.verifyCA(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// a set interrupted there is reissued for the new key by the next run
+func TestCert_Store_Interrupted(t *testing.T) {
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("failed to set token for test: %v", err)
+	}
+	defer func() { rename = os.Rename }()
+
+	read := func(path string) string {
+		dat, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading '%s': %v", path, err)
+		}
+		return string(dat)
+	}
+
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	before := map[string]string{}
+	for _, path := range c.setPaths() {
+		before[path] = read(path)
+	}
+
+	keyPem := c.Destination() + "-key.pem"
+	certPem := c.Destination() + ".pem"
+	var renamed []string
+	failCert := func(oldpath, newpath string) error {
+		if newpath == certPem {
+			return errors.New("failed rename")
+		}
+		renamed = append(renamed, newpath)
+		return os.Rename(oldpath, newpath)
+	}
+
+	// the new key is renamed first and put back when the certificate fails
+	rename = failCert
+	c.SetBitSize(4096)
+	if err := c.RunCert(); err == nil {
+		t.Fatal("expected error storing the set")
+	}
+	if len(renamed) == 0 || renamed[0] != keyPem {
+		t.Errorf("expected the key to be renamed first, got %v", renamed)
+	}
+	for _, path := range c.setPaths() {
+		if read(path) != before[path] {
+			t.Errorf("expected '%s' to be restored", path)
+		}
+	}
+	if err := c.verifyCertificates(); err != nil {
+		t.Errorf("unexpected error verifying restored set: %v", err)
+	}
+
+	// restoring fails as well, like a crash after renaming the key
+	rename = func(oldpath, newpath string) error {
+		if strings.HasSuffix(oldpath, ".restore") {
+			return errors.New("failed rename")
+		}
+		return failCert(oldpath, newpath)
+	}
+	if err := c.RunCert(); err == nil {
+		t.Fatal("expected error storing the set")
+	}
+	key := read(keyPem)
+	if key == before[keyPem] || read(certPem) != before[certPem] {
+		t.Fatal("expected a new key with the previous certificate")
+	}
+	if err := c.verifyCertificates(); err == nil {
+		t.Error("expected the mixed set to fail verification")
+	}
+	for _, path := range c.setPaths() {
+		if read(path+".bak") != before[path] {
+			t.Errorf("expected the previous set as backup of '%s'", path)
+		}
+	}
+
+	rename = os.Rename
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error running cert: %v", err)
+	}
+	if read(keyPem) != key {
+		t.Error("expected the certificate to be reissued for the new key")
+	}
+	if err := c.verifyCertificates(); err != nil {
+		t.Errorf("unexpected error verifying reissued set: %v", err)
+	}
+}
+
 func TestCert_Busy_Vault(t *testing.T) {
 	dir, err := ioutil.TempDir("", "test-cluster-dir")
 	if err != nil {
@@ -0,0 +1,220 @@
+package cert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse the renewal threshold, either a duration like '72h' or a percentage
+// of the validity of certificates like '30%'
+func ParseRenewBefore(value string) (time.Duration, float64, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid percentage '%s': %v", value, err)
+		}
+		if percent < 0 || percent >= 100 {
+			return 0, 0, fmt.Errorf("invalid percentage '%s', expected at least 0%% and less than 100%%", value)
+		}
+		return 0, percent / 100, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid duration '%s': %v", value, err)
+	}
+	if d < 0 {
+		return 0, 0, fmt.Errorf("invalid duration '%s', expected a positive duration", value)
+	}
+	return d, 0, nil
+}
+
+// Make sure the existing certificate was issued for the key, by the CA and
+// for the requested names, and is not about to expire
+func (c *Cert) verifyCertificates() error {
+	certPath := filepath.Clean(c.Destination() + ".pem")
+	caPath := filepath.Clean(c.Destination() + "-ca.pem")
+	keyPath := filepath.Clean(c.Destination() + "-key.pem")
+
+	certPEM, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return fmt.Errorf("error reading certificate: %v", err)
+	}
+	caPEM, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return fmt.Errorf("error reading ca certificate: %v", err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("error reading key: %v", err)
+	}
+
+	// fails if the key doesn't match the public key of the certificate
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("error loading key pair: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("error parsing certificate '%s': %v", certPath, err)
+	}
+
+	roots, err := parseCertificates(caPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing ca certificate '%s': %v", caPath, err)
+	}
+	pool := x509.NewCertPool()
+	for _, root := range roots {
+		pool.AddCert(root)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("error verifying certificate '%s': %v", certPath, err)
+	}
+
+	if err := c.verifyNames(cert); err != nil {
+		return err
+	}
+
+	return c.verifyValidity(cert, time.Now())
+}
+
+// The certificate has to be issued for the common name and SANs, vault adds
+// the common name to the DNS names
+func (c *Cert) verifyNames(cert *x509.Certificate) error {
+	if cert.Subject.CommonName != c.CommonName() {
+		return fmt.Errorf("certificate common name doesn't match. exp=%s got=%s", c.CommonName(), cert.Subject.CommonName)
+	}
+
+	var ips []string
+	for _, ip := range c.IPSans() {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ips = append(ips, parsed.String())
+		}
+	}
+	var certIPs []string
+	for _, ip := range cert.IPAddresses {
+		certIPs = append(certIPs, ip.String())
+	}
+	if !equalNames(ips, certIPs) {
+		return fmt.Errorf("certificate IP sans don't match. exp=%v got=%v", ips, certIPs)
+	}
+
+	var hosts []string
+	for _, host := range c.SanHosts() {
+		if host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	var certHosts []string
+	for _, host := range cert.DNSNames {
+		if host != c.CommonName() || contains(hosts, host) {
+			certHosts = append(certHosts, host)
+		}
+	}
+	if !equalNames(hosts, certHosts) {
+		return fmt.Errorf("certificate DNS sans don't match. exp=%v got=%v", hosts, certHosts)
+	}
+
+	return nil
+}
+
+// The certificate is renewed if less than the renewal duration or fraction
+// of its validity remains
+func (c *Cert) verifyValidity(cert *x509.Certificate, now time.Time) error {
+	remaining := cert.NotAfter.Sub(now).Truncate(time.Second)
+	if remaining <= 0 {
+		return fmt.Errorf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if threshold := c.renewThreshold(cert); remaining < threshold {
+		return fmt.Errorf("certificate expires in %s, renewing %s before expiry", remaining, threshold.Truncate(time.Second))
+	}
+
+	return nil
+}
+
+// The larger of the renewal duration and fraction of the validity, extended
+// by the jitter
+func (c *Cert) renewThreshold(cert *x509.Certificate) time.Duration {
+	threshold := c.RenewBefore()
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	if fraction := time.Duration(float64(validity) * c.RenewBeforeFraction()); fraction > threshold {
+		threshold = fraction
+	}
+	return time.Duration(float64(threshold) * (1 + c.RenewJitter()))
+}
+
+// When the existing certificate is due for renewal
+func (c *Cert) RenewAt() (time.Time, error) {
+	certPath := filepath.Clean(c.Destination() + ".pem")
+	dat, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error reading certificate: %v", err)
+	}
+	certs, err := parseCertificates(dat)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing certificate '%s': %v", certPath, err)
+	}
+
+	return certs[0].NotAfter.Add(-c.renewThreshold(certs[0])), nil
+}
+
+func parseCertificates(dat []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, dat = pem.Decode(dat)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// Compare names as sets, duplicates are dropped by vault
+func equalNames(a, b []string) bool {
+	for _, name := range b {
+		if !contains(a, name) {
+			return false
+		}
+	}
+	for _, name := range a {
+		if !contains(b, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
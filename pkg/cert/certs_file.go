@@ -0,0 +1,171 @@
+package cert
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v2"
+)
+
+const FlagCertsFile = "certs-file"
+
+// CertsFile lists certificates to issue and keep renewed with the same
+// instance token
+type CertsFile struct {
+	Certificates []*CertsFileCert `yaml:"certificates" json:"certificates"`
+}
+
+// CertsFileCert describes a certificate like the arguments and flags of
+// 'cert', unset fields default to the flags
+type CertsFileCert struct {
+	Role        string   `yaml:"role" json:"role"`
+	CommonName  string   `yaml:"commonName" json:"commonName"`
+	Destination string   `yaml:"destination" json:"destination"`
+	KeyType     string   `yaml:"keyType,omitempty" json:"keyType,omitempty"`
+	KeyBitSize  int      `yaml:"keyBitSize,omitempty" json:"keyBitSize,omitempty"`
+	IPSans      []string `yaml:"ipSans,omitempty" json:"ipSans,omitempty"`
+	SanHosts    []string `yaml:"sanHosts,omitempty" json:"sanHosts,omitempty"`
+	Owner       string   `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Group       string   `yaml:"group,omitempty" json:"group,omitempty"`
+	// RenewBefore is a duration like '72h' or a percentage like '30%'
+	RenewBefore string `yaml:"renewBefore,omitempty" json:"renewBefore,omitempty"`
+	// Setting any hook replaces the hooks of the flags, keeping their
+	// timeout and retries
+	OnChangeExec    string `yaml:"onChangeExec,omitempty" json:"onChangeExec,omitempty"`
+	OnChangeSignal  string `yaml:"onChangeSignal,omitempty" json:"onChangeSignal,omitempty"`
+	Pidfile         string `yaml:"pidfile,omitempty" json:"pidfile,omitempty"`
+	OnChangeWebhook string `yaml:"onChangeWebhook,omitempty" json:"onChangeWebhook,omitempty"`
+}
+
+// Read a certificates file in YAML or JSON
+func LoadCertsFile(path string) (*CertsFile, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading certificates file '%s': %v", path, err)
+	}
+
+	f := &CertsFile{}
+	if err := yaml.Unmarshal(dat, f); err != nil {
+		return nil, fmt.Errorf("error parsing certificates file '%s': %v", path, err)
+	}
+
+	return f, nil
+}
+
+// Build the certificates of the file, based on a certificate configured by
+// the flags
+func (f *CertsFile) Certs(defaults *Cert) ([]*Cert, error) {
+	var certs []*Cert
+	var result error
+
+	destinations := map[string]bool{}
+	for n, fc := range f.Certificates {
+		c, err := fc.cert(defaults)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("certificate %d: %v", n+1, err))
+			continue
+		}
+		if destinations[c.Destination()] {
+			result = multierror.Append(result, fmt.Errorf("certificate %d: duplicate destination '%s'", n+1, c.Destination()))
+			continue
+		}
+		destinations[c.Destination()] = true
+		certs = append(certs, c)
+	}
+
+	return certs, result
+}
+
+func (fc *CertsFileCert) cert(defaults *Cert) (*Cert, error) {
+	if fc.Role == "" || fc.CommonName == "" || fc.Destination == "" {
+		return nil, fmt.Errorf("role, commonName and destination are required")
+	}
+
+	copied := *defaults
+	c := &copied
+	c.SetData(nil)
+
+	c.SetRole(fc.Role)
+	c.SetCommonName(fc.CommonName)
+	abs, err := filepath.Abs(fc.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate absolute path from destination '%s': %v", fc.Destination, err)
+	}
+	c.SetDestination(abs)
+
+	if fc.KeyType != "" {
+		keyType, err := ParseKeyType(fc.KeyType)
+		if err != nil {
+			return nil, err
+		}
+		c.SetKeyType(keyType)
+		c.SetBitSize(DefaultBitSize(keyType))
+	}
+	if fc.KeyBitSize != 0 {
+		c.SetBitSize(fc.KeyBitSize)
+	}
+	if err := c.verifyKeyParams(); err != nil {
+		return nil, err
+	}
+
+	if fc.IPSans != nil {
+		c.SetIPSans(fc.IPSans)
+	}
+	if fc.SanHosts != nil {
+		c.SetSanHosts(fc.SanHosts)
+	}
+	if fc.Owner != "" {
+		c.SetOwner(fc.Owner)
+	}
+	if fc.Group != "" {
+		c.SetGroup(fc.Group)
+	}
+
+	if fc.RenewBefore != "" {
+		renewBefore, fraction, err := ParseRenewBefore(fc.RenewBefore)
+		if err != nil {
+			return nil, err
+		}
+		c.SetRenewBefore(renewBefore)
+		c.SetRenewBeforeFraction(fraction)
+	}
+
+	if fc.OnChangeExec != "" || fc.OnChangeSignal != "" || fc.OnChangeWebhook != "" {
+		hooks, err := fc.hooks(defaults)
+		if err != nil {
+			return nil, err
+		}
+		c.SetHooks(hooks)
+	}
+
+	return c, nil
+}
+
+func (fc *CertsFileCert) hooks(defaults *Cert) (*Hooks, error) {
+	hooks := &Hooks{
+		Exec:    fc.OnChangeExec,
+		Pidfile: fc.Pidfile,
+		Webhook: fc.OnChangeWebhook,
+		Log:     defaults.Log,
+	}
+	if h := defaults.Hooks(); h != nil {
+		hooks.Timeout = h.Timeout
+		hooks.Retries = h.Retries
+		hooks.RetryDelay = h.RetryDelay
+	}
+
+	if fc.OnChangeSignal != "" {
+		signal, err := ParseSignal(fc.OnChangeSignal)
+		if err != nil {
+			return nil, err
+		}
+		if fc.Pidfile == "" {
+			return nil, fmt.Errorf("onChangeSignal requires a pidfile")
+		}
+		hooks.Signal = signal
+	}
+
+	return hooks, nil
+}
@@ -0,0 +1,260 @@
+package cert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagOnChangeExec = "on-change-exec"
+const FlagOnChangeSignal = "on-change-signal"
+const FlagOnChangePidfile = "pidfile"
+const FlagOnChangeWebhook = "on-change-webhook"
+const FlagHookTimeout = "hook-timeout"
+const FlagHookRetries = "hook-retries"
+
+var hookSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// Hooks tell the processes using a certificate that it changed, by running a
+// command, signalling a process or calling a webhook
+type Hooks struct {
+	Exec    string
+	Signal  syscall.Signal
+	Pidfile string
+	Webhook string
+
+	// Each hook is retried with a doubling delay, every attempt is limited
+	// by the timeout
+	Timeout    time.Duration
+	Retries    int
+	RetryDelay time.Duration
+
+	Log *logrus.Entry
+}
+
+func NewHooks(logger *logrus.Entry) *Hooks {
+	return &Hooks{
+		Timeout:    30 * time.Second,
+		Retries:    3,
+		RetryDelay: time.Second,
+		Log:        logger,
+	}
+}
+
+// HookEvent describes the change, passed to commands in VAULT_HELPER_*
+// environment variables and to webhooks as JSON
+type HookEvent struct {
+	Path       string `json:"path"`
+	CommonName string `json:"commonName"`
+}
+
+// Parse a signal name like 'HUP' or 'SIGHUP', or number
+func ParseSignal(value string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if s, ok := hookSignals[strings.TrimPrefix(strings.ToUpper(value), "SIG")]; ok {
+		return s, nil
+	}
+	return 0, fmt.Errorf("unknown signal '%s'", value)
+}
+
+func (h *Hooks) Empty() bool {
+	return h == nil || (h.Exec == "" && h.Signal == 0 && h.Webhook == "")
+}
+
+// Run all hooks, each one is retried independently
+func (h *Hooks) Run(event *HookEvent) error {
+	if h.Empty() {
+		return nil
+	}
+
+	var result error
+	if h.Exec != "" {
+		if err := h.retry("exec", event, h.exec); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if h.Signal != 0 {
+		if err := h.retry("signal", event, h.signal); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if h.Webhook != "" {
+		if err := h.retry("webhook", event, h.webhook); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result
+}
+
+// Record the hooks of a change as pending, before the change is stored. The
+// marker file stays until the hooks succeeded, so a run after failing hooks
+// runs them again.
+func (h *Hooks) MarkPending(marker string) error {
+	if h.Empty() {
+		return nil
+	}
+	if err := ioutil.WriteFile(marker, nil, 0600); err != nil {
+		return fmt.Errorf("failed to write pending hooks marker '%s': %v", marker, err)
+	}
+	return nil
+}
+
+// Run the hooks if they are pending, removing the marker once they succeeded
+func (h *Hooks) RunPending(marker string, event *HookEvent) error {
+	if h.Empty() {
+		return nil
+	}
+	if _, err := os.Stat(marker); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read pending hooks marker '%s': %v", marker, err)
+	}
+
+	if err := h.Run(event); err != nil {
+		return err
+	}
+	if err := os.Remove(marker); err != nil {
+		return fmt.Errorf("failed to remove pending hooks marker '%s': %v", marker, err)
+	}
+	return nil
+}
+
+func (h *Hooks) retry(name string, event *HookEvent, hook func(*HookEvent) error) error {
+	delay := h.RetryDelay
+	var err error
+	for attempt := 0; attempt <= h.Retries; attempt++ {
+		if attempt > 0 {
+			h.Log.Infof("Retrying %s hook in %s", name, delay)
+			time.Sleep(delay)
+			delay *= 2
+		}
+
+		if err = hook(event); err == nil {
+			h.Log.Infof("Ran %s hook for '%s'", name, event.Path)
+			return nil
+		}
+		h.Log.Warnf("Error running %s hook for '%s', attempt %d of %d: %v", name, event.Path, attempt+1, h.Retries+1, err)
+	}
+
+	return fmt.Errorf("error running %s hook for '%s': %v", name, event.Path, err)
+}
+
+// Run the command with a shell, in its own process group so commands started
+// by the shell get killed on timeout too
+func (h *Hooks) exec(event *HookEvent) error {
+	ctx, cancel := h.context()
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.Command("/bin/sh", "-c", h.Exec)
+	cmd.Env = append(os.Environ(),
+		"VAULT_HELPER_PATH="+event.Path,
+		"VAULT_HELPER_COMMON_NAME="+event.CommonName,
+	)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting command '%s': %v", h.Exec, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	if output := strings.TrimSpace(out.String()); output != "" {
+		h.Log.Infof("Output of '%s': %s", h.Exec, output)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command '%s' timed out after %s", h.Exec, h.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("command '%s' failed: %v", h.Exec, err)
+	}
+
+	return nil
+}
+
+// Signal the process of the pidfile
+func (h *Hooks) signal(event *HookEvent) error {
+	dat, err := ioutil.ReadFile(h.Pidfile)
+	if err != nil {
+		return fmt.Errorf("error reading pidfile: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(dat)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("invalid pid in '%s'", h.Pidfile)
+	}
+
+	if err := syscall.Kill(pid, h.Signal); err != nil {
+		return fmt.Errorf("error sending %s to process %d: %v", h.Signal, pid, err)
+	}
+	h.Log.Infof("Sent %s to process %d", h.Signal, pid)
+
+	return nil
+}
+
+// POST the event, the webhook has to respond with a 2xx status
+func (h *Hooks) webhook(event *HookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := h.context()
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, h.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook '%s' responded with %s", h.Webhook, resp.Status)
+	}
+
+	return nil
+}
+
+func (h *Hooks) context() (context.Context, context.CancelFunc) {
+	if h.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), h.Timeout)
+}
@@ -0,0 +1,157 @@
+package cert
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestParseSignal(t *testing.T) {
+	for value, exp := range map[string]syscall.Signal{
+		"HUP":    syscall.SIGHUP,
+		"sighup": syscall.SIGHUP,
+		"USR1":   syscall.SIGUSR1,
+		"15":     syscall.SIGTERM,
+	} {
+		s, err := ParseSignal(value)
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %v", value, err)
+		} else if s != exp {
+			t.Errorf("unexpected signal of '%s'. exp=%s got=%s", value, exp, s)
+		}
+	}
+
+	for _, value := range []string{"", "FOO", "-1"} {
+		if _, err := ParseSignal(value); err == nil {
+			t.Errorf("expected error parsing '%s'", value)
+		}
+	}
+}
+
+func TestHooks_Exec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "out")
+	h := initHooks()
+	h.Exec = "echo $VAULT_HELPER_COMMON_NAME $VAULT_HELPER_PATH > " + out
+	if err := h.Run(&HookEvent{Path: "/etc/vault/etcd.pem", CommonName: "etcd"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dat, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("error reading hook output: %v", err)
+	}
+	if exp := "etcd /etc/vault/etcd.pem\n"; string(dat) != exp {
+		t.Errorf("unexpected hook output. exp=%q got=%q", exp, dat)
+	}
+
+	// fails until the third attempt
+	count := filepath.Join(dir, "count")
+	h.Exec = "echo >> " + count + "; test $(wc -l < " + count + ") -ge 3"
+	h.Retries = 1
+	if err := h.Run(&HookEvent{}); err == nil {
+		t.Error("expected error after retries")
+	}
+	h.Retries = 2
+	if err := h.Run(&HookEvent{}); err != nil {
+		t.Errorf("unexpected error after retries: %v", err)
+	}
+
+	h.Exec = "sleep 5"
+	h.Retries = 0
+	h.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if err := h.Run(&HookEvent{}); err == nil {
+		t.Error("expected error of timed out command")
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Error("expected command to be killed after the timeout")
+	}
+}
+
+func TestHooks_Signal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	h := initHooks()
+	h.Signal = syscall.SIGUSR1
+	h.Pidfile = filepath.Join(dir, "pid")
+	if err := h.Run(&HookEvent{}); err == nil {
+		t.Error("expected error of missing pidfile")
+	}
+
+	if err := ioutil.WriteFile(h.Pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(&HookEvent{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-signals:
+	case <-time.After(5 * time.Second):
+		t.Error("expected SIGUSR1")
+	}
+}
+
+func TestHooks_Webhook(t *testing.T) {
+	events := make(chan *HookEvent, 3)
+	fail := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail > 0 {
+			fail--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event := &HookEvent{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events <- event
+	}))
+	defer server.Close()
+
+	h := initHooks()
+	h.Webhook = server.URL
+	if err := h.Run(&HookEvent{Path: "/etc/vault/etcd.pem", CommonName: "etcd"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	event := <-events
+	if event.Path != "/etc/vault/etcd.pem" || event.CommonName != "etcd" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	fail = 2
+	h.Retries = 1
+	if err := h.Run(&HookEvent{}); err == nil {
+		t.Error("expected error of failing webhook")
+	}
+}
+
+func initHooks() *Hooks {
+	h := NewHooks(logrus.NewEntry(logrus.New()))
+	h.Timeout = 5 * time.Second
+	h.RetryDelay = time.Millisecond
+	return h
+}
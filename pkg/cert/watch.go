@@ -0,0 +1,248 @@
+package cert
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/jetstack/vault-helper/pkg/instanceToken"
+)
+
+const FlagWatch = "watch"
+
+// Bounds of the exponential backoff after errors
+const watchMinBackoff = 5 * time.Second
+const watchMaxBackoff = 5 * time.Minute
+
+// Longest time between checks, so removed or changed files get replaced
+const watchMaxInterval = time.Hour
+
+// Renewal thresholds are extended by up to this fraction, so certificates
+// issued at the same time are not all renewed at once
+const watchJitter = 0.1
+
+// Watcher keeps the instance token and certificates renewed, until it gets
+// stopped by a signal
+type Watcher struct {
+	Log *logrus.Entry
+
+	instanceToken *instanceToken.InstanceToken
+	// load returns the certificates to watch, it is called again on SIGHUP
+	load  func() ([]*Cert, error)
+	certs []*Cert
+
+	tokenRenewAt time.Time
+	backoff      time.Duration
+
+	// notify sends the state to systemd
+	notify func(state string)
+}
+
+func NewWatcher(logger *logrus.Entry, i *instanceToken.InstanceToken, load func() ([]*Cert, error)) *Watcher {
+	return &Watcher{
+		Log:           logger,
+		instanceToken: i,
+		load:          load,
+		backoff:       watchMinBackoff,
+		notify: func(state string) {
+			daemon.SdNotify(false, state)
+		},
+	}
+}
+
+// Run renews the token and certificates when they are due. SIGHUP reloads
+// the certificates, any other signal stops the watcher. Renewals run in the
+// background, so the watchdog keeps being notified and a stopping signal
+// doesn't wait for vault or hook retries. Files are stored safely to be
+// interrupted and interrupted hooks stay pending, see Hooks.MarkPending.
+func (w *Watcher) Run(signals <-chan os.Signal) error {
+	if err := w.reload(); err != nil {
+		return err
+	}
+
+	var watchdog <-chan time.Time
+	if interval, err := daemon.SdWatchdogEnabled(false); err != nil {
+		w.Log.Warnf("Error reading watchdog interval: %v", err)
+	} else if interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	ready := false
+	for {
+		done := make(chan time.Time, 1)
+		go func(now time.Time) {
+			done <- w.run(now)
+		}(time.Now())
+
+		// the certificates can't be replaced while they get renewed, a
+		// SIGHUP reloads them afterwards
+		var next time.Time
+		reload := false
+	renew:
+		for {
+			select {
+			case next = <-done:
+				break renew
+			case <-watchdog:
+				w.notify("WATCHDOG=1")
+			case s := <-signals:
+				if s != syscall.SIGHUP {
+					w.Log.Infof("Received %s while renewing, stopping", s)
+					w.notify("STOPPING=1")
+					return nil
+				}
+				w.Log.Info("Received SIGHUP while renewing, reloading afterwards")
+				reload = true
+			}
+		}
+
+		if !ready {
+			w.notify("READY=1")
+			ready = true
+		}
+		if reload {
+			w.reloadNotify()
+			continue
+		}
+		w.Log.Debugf("Next check at %s", next.UTC().Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+	wait:
+		for {
+			select {
+			case <-timer.C:
+				break wait
+			case <-watchdog:
+				w.notify("WATCHDOG=1")
+			case s := <-signals:
+				if s != syscall.SIGHUP {
+					w.Log.Infof("Received %s, stopping", s)
+					timer.Stop()
+					w.notify("STOPPING=1")
+					return nil
+				}
+
+				w.reloadNotify()
+				timer.Stop()
+				break wait
+			}
+		}
+	}
+}
+
+// Reload the certificates on SIGHUP, keeping the current ones on errors
+func (w *Watcher) reloadNotify() {
+	w.Log.Info("Received SIGHUP, reloading")
+	w.notify("RELOADING=1")
+	if err := w.reload(); err != nil {
+		w.Log.Errorf("Error reloading, keeping the current certificates: %v", err)
+	}
+	w.notify("READY=1")
+}
+
+func (w *Watcher) reload() error {
+	certs, err := w.load()
+	if err != nil {
+		return err
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no certificates to watch")
+	}
+
+	for _, c := range certs {
+		c.SetRenewJitter(rand.Float64() * watchJitter)
+	}
+	w.certs = certs
+	w.backoff = watchMinBackoff
+
+	return nil
+}
+
+// Renew what is due, returning when to check next. Errors are retried with
+// exponential backoff.
+func (w *Watcher) run(now time.Time) time.Time {
+	next, err := w.renew(now)
+	if err != nil {
+		retry := now.Add(w.backoff)
+		w.Log.Errorf("Error renewing, retrying in %s: %v", w.backoff, err)
+		w.notify(fmt.Sprintf("STATUS=Error renewing, retrying at %s", retry.UTC().Format(time.RFC3339)))
+
+		w.backoff *= 2
+		if w.backoff > watchMaxBackoff {
+			w.backoff = watchMaxBackoff
+		}
+		return retry
+	}
+
+	w.backoff = watchMinBackoff
+	w.notify(fmt.Sprintf("STATUS=Watching %d certificates, next check at %s", len(w.certs), next.UTC().Format(time.RFC3339)))
+	return next
+}
+
+func (w *Watcher) renew(now time.Time) (time.Time, error) {
+	next := now.Add(watchMaxInterval)
+
+	if !now.Before(w.tokenRenewAt) {
+		if err := w.renewToken(now); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if w.tokenRenewAt.Before(next) {
+		next = w.tokenRenewAt
+	}
+
+	var result error
+	for _, c := range w.certs {
+		if err := c.RunCert(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error ensuring certificate '%s': %v", c.Destination(), err))
+			continue
+		}
+
+		renewAt, err := c.RenewAt()
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		// the threshold exceeds the validity vault issues certificates with
+		if renewAt.Before(now.Add(watchMinBackoff)) {
+			w.Log.Warnf("Certificate '%s' is due for renewal right after being issued, renewing it at the next check", c.Destination())
+			continue
+		}
+		if renewAt.Before(next) {
+			next = renewAt
+		}
+	}
+
+	return next, result
+}
+
+// Renew the token and schedule its next renewal after two thirds of its
+// TTL, less the jitter
+func (w *Watcher) renewToken(now time.Time) error {
+	if err := w.instanceToken.TokenRenewRun(); err != nil {
+		return fmt.Errorf("error renewing token: %v", err)
+	}
+
+	ttl, err := w.instanceToken.TokenTTL()
+	if err != nil {
+		return err
+	}
+	if ttl == 0 {
+		w.tokenRenewAt = now.Add(watchMaxInterval)
+		return nil
+	}
+
+	renewIn := time.Duration(float64(ttl) * 2 / 3 * (1 - rand.Float64()*watchJitter))
+	w.tokenRenewAt = now.Add(renewIn)
+	w.Log.Infof("Token expires in %s, renewing in %s", ttl, renewIn.Truncate(time.Second))
+
+	return nil
+}
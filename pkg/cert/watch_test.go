@@ -0,0 +1,243 @@
+package cert
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestCertsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-certs-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "certs.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+certificates:
+- role: test-cluster/pki/k8s/sign/kubelet
+  commonName: system:node:kube-1
+  destination: /etc/vault/kubelet
+  keyType: ecdsa
+  renewBefore: 30%
+- role: test-cluster/pki/k8s/sign/kube-apiserver
+  commonName: kube-apiserver
+  destination: /etc/vault/kube-apiserver
+  sanHosts: [kubernetes]
+  onChangeSignal: HUP
+  pidfile: /run/kube-apiserver.pid
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadCertsFile(path)
+	if err != nil {
+		t.Fatalf("error loading certificates file: %v", err)
+	}
+
+	defaults := New(nil, nil)
+	defaults.SetIPSans([]string{"127.0.0.1"})
+	defaults.SetHooks(&Hooks{Exec: "systemctl reload kubelet", Timeout: time.Minute})
+	certs, err := f.Certs(defaults)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("unexpected number of certificates, exp=2 got=%d", len(certs))
+	}
+
+	if c := certs[0]; c.KeyType() != KeyTypeECDSA || c.BitSize() != 256 || c.RenewBeforeFraction() != 0.3 || c.Destination() != "/etc/vault/kubelet" {
+		t.Errorf("unexpected certificate: %+v", c)
+	}
+	if c := certs[1]; c.KeyType() != KeyTypeRSA || c.BitSize() != 2048 || c.RenewBefore() != time.Hour*72 || len(c.IPSans()) != 1 || len(c.SanHosts()) != 1 {
+		t.Errorf("unexpected certificate: %+v", c)
+	}
+	if h := certs[0].Hooks(); h.Exec != "systemctl reload kubelet" {
+		t.Errorf("expected hooks of the flags, got: %+v", h)
+	}
+	if h := certs[1].Hooks(); h.Exec != "" || h.Signal != syscall.SIGHUP || h.Pidfile != "/run/kube-apiserver.pid" || h.Timeout != time.Minute {
+		t.Errorf("unexpected hooks: %+v", h)
+	}
+
+	f.Certificates = append(f.Certificates,
+		&CertsFileCert{Role: "test-cluster/pki/k8s/sign/kubelet", CommonName: "kube-1"},
+		&CertsFileCert{Role: "test-cluster/pki/k8s/sign/kubelet", CommonName: "kube-1", Destination: "/etc/vault/kubelet"},
+		&CertsFileCert{Role: "test-cluster/pki/k8s/sign/kubelet", CommonName: "kube-1", Destination: "/etc/vault/other", KeyType: "dsa"},
+		&CertsFileCert{Role: "test-cluster/pki/k8s/sign/kubelet", CommonName: "kube-1", Destination: "/etc/vault/signal", OnChangeSignal: "HUP"},
+	)
+	_, err = f.Certs(defaults)
+	if err == nil {
+		t.Fatal("expected an error for invalid certificates")
+	}
+	for _, exp := range []string{"certificate 3: role", "certificate 4: duplicate", "certificate 5: unknown key type", "certificate 6: onChangeSignal requires a pidfile"} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("expected error containing '%s', got: %v", exp, err)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	vaultDev := initVaultDev()
+	defer vaultDev.Stop()
+	initKubernetes(vaultDev)
+
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+	other, _ := initCert(t, vaultDev)
+	other.SetInstanceToken(i)
+
+	certs := []*Cert{c}
+	w := NewWatcher(c.Log, i, func() ([]*Cert, error) {
+		return certs, nil
+	})
+	states := make(chan string, 100)
+	w.notify = func(state string) {
+		states <- state
+	}
+	waitFor := func(exp string) {
+		timeout := time.After(30 * time.Second)
+		for {
+			select {
+			case state := <-states:
+				if strings.HasPrefix(state, exp) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %s", exp)
+			}
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error)
+	go func() {
+		stopped <- w.Run(signals)
+	}()
+
+	waitFor("READY=1")
+	if _, err := os.Stat(c.Destination() + ".pem"); err != nil {
+		t.Errorf("expected certificate to be issued: %v", err)
+	}
+	if w.tokenRenewAt.IsZero() {
+		t.Error("expected token renewal to be scheduled")
+	}
+
+	// certificates get reloaded
+	certs = append(certs, other)
+	signals <- syscall.SIGHUP
+	waitFor("RELOADING=1")
+	waitFor("READY=1")
+	waitFor("STATUS=Watching 2 certificates")
+	if _, err := os.Stat(other.Destination() + ".pem"); err != nil {
+		t.Errorf("expected certificate to be issued after reload: %v", err)
+	}
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for watcher to stop")
+	}
+}
+
+// The watchdog is notified while a renewal blocks on hooks, which a stopping
+// signal doesn't wait for
+func TestWatcher_Renewing(t *testing.T) {
+	vaultDev := initVaultDev()
+	defer vaultDev.Stop()
+	initKubernetes(vaultDev)
+
+	c, i := initCert(t, vaultDev)
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+	hooks := NewHooks(c.Log)
+	hooks.Exec = "sleep 10"
+	c.SetHooks(hooks)
+
+	os.Setenv("WATCHDOG_USEC", "100000")
+	defer os.Unsetenv("WATCHDOG_USEC")
+
+	w := NewWatcher(c.Log, i, func() ([]*Cert, error) {
+		return []*Cert{c}, nil
+	})
+	states := make(chan string, 100)
+	w.notify = func(state string) {
+		states <- state
+	}
+
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan error)
+	go func() {
+		stopped <- w.Run(signals)
+	}()
+
+	watchdogs := 0
+	timeout := time.After(30 * time.Second)
+	for watchdogs < 3 {
+		select {
+		case state := <-states:
+			if strings.HasPrefix(state, "READY=1") {
+				t.Fatal("unexpected renewal finishing while the hook runs")
+			}
+			if state == "WATCHDOG=1" {
+				watchdogs++
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the watchdog")
+		}
+	}
+
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for watcher to stop while renewing")
+	}
+	if _, err := os.Stat(c.Destination() + ".hook-pending"); err != nil {
+		t.Errorf("expected the interrupted hook to stay pending: %v", err)
+	}
+}
+
+func TestWatcher_Backoff(t *testing.T) {
+	log := logrus.NewEntry(logrus.New())
+	c := New(log, nil)
+	c.SetKeyType("dsa")
+
+	w := NewWatcher(log, nil, nil)
+	w.notify = func(string) {}
+	w.certs = []*Cert{c}
+	w.tokenRenewAt = time.Now().Add(time.Hour)
+
+	now := time.Now()
+	for _, exp := range []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second} {
+		if next := w.run(now); next.Sub(now) != exp {
+			t.Errorf("unexpected backoff, exp=%s got=%s", exp, next.Sub(now))
+		}
+	}
+
+	w.backoff = watchMaxBackoff
+	if next := w.run(now); next.Sub(now) != watchMaxBackoff {
+		t.Errorf("unexpected backoff, exp=%s got=%s", watchMaxBackoff, next.Sub(now))
+	}
+	if w.backoff != watchMaxBackoff {
+		t.Errorf("expected backoff to be capped, got=%s", w.backoff)
+	}
+}
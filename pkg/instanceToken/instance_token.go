@@ -9,12 +9,28 @@ import (
 
 const FlagInitRole = "init-role"
 const FlagConfigPath = "config-path"
+const FlagRoleID = "role-id"
+const FlagAppRolePath = "approle-path"
+const FlagClientCert = "client-cert"
+const FlagClientKey = "client-key"
+const FlagCertAuthPath = "cert-auth-path"
+const FlagCertAuthRole = "cert-auth-role"
 
 type InstanceToken struct {
 	token           string
 	initRole        string
 	vaultConfigPath string
 
+	// AppRole to log in with instead of an init token
+	roleID      string
+	appRolePath string
+
+	// Client certificate to log in with once the node holds one
+	clientCert   string
+	clientKey    string
+	certAuthPath string
+	certAuthRole string
+
 	Log         *logrus.Entry
 	vaultClient *vault.Client
 }
@@ -27,6 +43,47 @@ func (i *InstanceToken) InitRole() (initRole string) {
 	return i.initRole
 }
 
+func (i *InstanceToken) SetRoleID(roleID string) {
+	i.roleID = roleID
+}
+
+func (i *InstanceToken) RoleID() (roleID string) {
+	return i.roleID
+}
+
+func (i *InstanceToken) SetAppRolePath(path string) {
+	i.appRolePath = path
+}
+
+func (i *InstanceToken) AppRolePath() (path string) {
+	return i.appRolePath
+}
+
+func (i *InstanceToken) SetClientCert(certPath, keyPath string) {
+	i.clientCert = certPath
+	i.clientKey = keyPath
+}
+
+func (i *InstanceToken) ClientCert() (certPath, keyPath string) {
+	return i.clientCert, i.clientKey
+}
+
+func (i *InstanceToken) SetCertAuthPath(path string) {
+	i.certAuthPath = path
+}
+
+func (i *InstanceToken) CertAuthPath() (path string) {
+	return i.certAuthPath
+}
+
+func (i *InstanceToken) SetCertAuthRole(role string) {
+	i.certAuthRole = role
+}
+
+func (i *InstanceToken) CertAuthRole() (role string) {
+	return i.certAuthRole
+}
+
 func (i *InstanceToken) SetToken(token string) {
 	i.token = token
 }
@@ -49,13 +106,19 @@ func (i *InstanceToken) TokenFilePath() (path string) {
 func (i *InstanceToken) InitTokenFilePath() (path string) {
 	return filepath.Join(i.VaultConfigPath(), "init-token")
 }
+func (i *InstanceToken) SecretIDFilePath() (path string) {
+	return filepath.Join(i.VaultConfigPath(), "secret-id")
+}
 
 func (i *InstanceToken) VaultClient() (vaultClient *vault.Client) {
 	return i.vaultClient
 }
 
 func New(vaultClient *vault.Client, logger *logrus.Entry) *InstanceToken {
-	i := &InstanceToken{}
+	i := &InstanceToken{
+		appRolePath:  "approle",
+		certAuthPath: "cert",
+	}
 
 	if vaultClient != nil {
 		i.vaultClient = vaultClient
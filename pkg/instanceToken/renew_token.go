@@ -1,11 +1,15 @@
 package instanceToken
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	vault "github.com/hashicorp/vault/api"
@@ -151,6 +155,132 @@ func (i *InstanceToken) initTokenNew() error {
 	return nil
 }
 
+// Log in with the role id and the secret id from file
+func (i *InstanceToken) appRoleNew() error {
+	exists, err := i.fileExists(i.SecretIDFilePath())
+	if err != nil {
+		return fmt.Errorf("error checking file exists: %v", err)
+	}
+	if !exists {
+		return fmt.Errorf("no secret id file: '%s' exiting.", i.SecretIDFilePath())
+	}
+	secretID, err := i.TokenFromFile(i.SecretIDFilePath())
+	if err != nil {
+		return fmt.Errorf("error reading secret id from file: %v", err)
+	}
+	if secretID == "" {
+		return fmt.Errorf("secret id was not read from file '%s' exiting", i.SecretIDFilePath())
+	}
+
+	path := filepath.Join("auth", i.AppRolePath(), "login")
+	s, err := i.vaultClient.Logical().Write(path, map[string]interface{}{
+		"role_id":   i.RoleID(),
+		"secret_id": secretID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to log in with AppRole: %v", err)
+	}
+	if s == nil || s.Auth == nil {
+		return fmt.Errorf("no token returned by '%s'", path)
+	}
+	i.SetToken(s.Auth.ClientToken)
+
+	i.Log.Infof("New token: %s", i.Token())
+
+	return nil
+}
+
+// Whether a client certificate is configured and has been written already
+func (i *InstanceToken) certLoginEnabled() (bool, error) {
+	if i.clientCert == "" || i.clientKey == "" {
+		return false, nil
+	}
+
+	for _, path := range []string{i.clientCert, i.clientKey} {
+		exists, err := i.fileExists(path)
+		if err != nil {
+			return false, fmt.Errorf("error checking file exists: %v", err)
+		}
+		if !exists {
+			i.Log.Debugf("Client certificate file '%s' doesn't exist yet", path)
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Use a client presenting the client certificate in the TLS handshake, as
+// vault checks it on login and on renewals of the token
+func (i *InstanceToken) useClientCert() error {
+	config := vault.DefaultConfig()
+	if err := config.ReadEnvironment(); err != nil {
+		return fmt.Errorf("error reading vault environment: %v", err)
+	}
+	config.Address = i.vaultClient.Address()
+
+	insecure, _ := strconv.ParseBool(os.Getenv(vault.EnvVaultInsecure))
+	if err := config.ConfigureTLS(&vault.TLSConfig{
+		CACert:        os.Getenv(vault.EnvVaultCACert),
+		CAPath:        os.Getenv(vault.EnvVaultCAPath),
+		ClientCert:    i.clientCert,
+		ClientKey:     i.clientKey,
+		TLSServerName: os.Getenv(vault.EnvVaultTLSServerName),
+		Insecure:      insecure,
+	}); err != nil {
+		return fmt.Errorf("error loading client certificate '%s': %v", i.clientCert, err)
+	}
+
+	client, err := vault.NewClient(config)
+	if err != nil {
+		return fmt.Errorf("error creating vault client: %v", err)
+	}
+	client.SetToken(i.vaultClient.Token())
+	i.vaultClient = client
+
+	return nil
+}
+
+// Log in with the client certificate
+func (i *InstanceToken) certLoginNew() error {
+	data := map[string]interface{}{}
+	if i.CertAuthRole() != "" {
+		data["name"] = i.CertAuthRole()
+	}
+
+	i.vaultClient.ClearToken()
+	path := filepath.Join("auth", i.CertAuthPath(), "login")
+	s, err := i.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		return fmt.Errorf("failed to log in with client certificate: %v", err)
+	}
+	if s == nil || s.Auth == nil {
+		return fmt.Errorf("no token returned by '%s'", path)
+	}
+	i.SetToken(s.Auth.ClientToken)
+
+	i.Log.Infof("New token: %s", i.Token())
+
+	return nil
+}
+
+// Vault denies lookups of tokens which expired or have been revoked
+func (i *InstanceToken) tokenExpired() (bool, error) {
+	_, err := i.TokenLookup()
+	if err != nil && strings.Contains(err.Error(), "Code: 403") {
+		return true, nil
+	}
+	return false, err
+}
+
+// The file holding the secret nodes get their first token with
+func (i *InstanceToken) bootstrapFilePath() string {
+	if i.RoleID() != "" {
+		return i.SecretIDFilePath()
+	}
+	return i.InitTokenFilePath()
+}
+
 func (i *InstanceToken) TokenPolicies() (policies []string, err error) {
 	s, err := i.TokenLookup()
 	if err != nil {
@@ -211,6 +341,25 @@ func (i *InstanceToken) TokenLookup() (secret *vault.Secret, err error) {
 	return s, nil
 }
 
+// Remaining TTL of the token, 0 if it never expires
+func (i *InstanceToken) TokenTTL() (time.Duration, error) {
+	s, err := i.TokenLookup()
+	if err != nil {
+		return 0, err
+	}
+
+	number, ok := s.Data["ttl"].(json.Number)
+	if !ok {
+		return 0, errors.New("unable to get ttl token data from secret")
+	}
+	ttl, err := number.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("error parsing token ttl '%s': %v", number, err)
+	}
+
+	return time.Duration(ttl) * time.Second, nil
+}
+
 func (i *InstanceToken) tokenRenew() error {
 	// Check if renewable
 
@@ -246,27 +395,46 @@ func (i *InstanceToken) EnsureToken() (newCreated bool, err error) {
 	if err != nil && os.IsExist(err) {
 		return false, fmt.Errorf("error retrieving token from file: %v", err)
 	}
+	certLogin, err := i.certLoginEnabled()
+	if err != nil {
+		return false, err
+	}
+	if certLogin {
+		if err := i.useClientCert(); err != nil {
+			return false, err
+		}
+	}
+
 	if token != "" {
 		// Token exists in file
 		logrus.Debugf("Token to renew: %s", token)
 		i.SetToken(token)
 		i.vaultClient.SetToken(i.Token())
-		return false, nil
+		if !certLogin {
+			return false, nil
+		}
+
+		expired, err := i.tokenExpired()
+		if err != nil || !expired {
+			return false, err
+		}
+
+		i.Log.Info("Token expired, logging in with client certificate")
+		if err := i.WipeTokenFile(i.TokenFilePath()); err != nil {
+			return false, fmt.Errorf("failed to wipe token from file: %v", err)
+		}
+	} else {
+		//Token Doesn't exist
+		i.Log.Info("Token doesn't exist, generating new")
 	}
 
-	//Token Doesn't exist
-	i.Log.Info("Token doesn't exist, generating new")
-	err = i.initTokenNew()
-	if err != nil {
+	if err := i.newToken(certLogin); err != nil {
 		return false, fmt.Errorf("failed to generate new token: %v", err)
 	}
 
 	if err := i.WriteTokenFile(i.TokenFilePath(), i.Token()); err != nil {
 		return false, fmt.Errorf("failed to write token to file: %v", err)
 	}
-	if err := i.WipeTokenFile(i.InitTokenFilePath()); err != nil {
-		return false, fmt.Errorf("failed to wipe token from file: %v", err)
-	}
 
 	i.Log.Infof("Token written to file: %s", i.TokenFilePath())
 	i.vaultClient.SetToken(i.Token())
@@ -274,6 +442,34 @@ func (i *InstanceToken) EnsureToken() (newCreated bool, err error) {
 	return true, nil
 }
 
+// Get a new token with the client certificate, falling back to the secret
+// nodes get their first token with
+func (i *InstanceToken) newToken(certLogin bool) error {
+	if certLogin {
+		err := i.certLoginNew()
+		if err == nil {
+			return nil
+		}
+		i.Log.Warnf("Falling back to bootstrap: %v", err)
+	}
+
+	var err error
+	if i.RoleID() != "" {
+		err = i.appRoleNew()
+	} else {
+		err = i.initTokenNew()
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := i.WipeTokenFile(i.bootstrapFilePath()); err != nil {
+		return fmt.Errorf("failed to wipe token from file: %v", err)
+	}
+
+	return nil
+}
+
 func (i *InstanceToken) TokenRenewRun() error {
 	newCreated, err := i.EnsureToken()
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 
@@ -158,6 +159,95 @@ func TestRenew_Token_NeitherExist(t *testing.T) {
 	return
 }
 
+// Client certificate not written yet - init token
+func TestRenew_Token_ClientCertMissing(t *testing.T) {
+	initKubernetes(t, vaultDev)
+	i := initInstanceToken(t, vaultDev)
+	i.SetClientCert(filepath.Join(i.VaultConfigPath(), "kubelet.pem"), filepath.Join(i.VaultConfigPath(), "kubelet-key.pem"))
+
+	if err := i.WriteTokenFile(i.InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("failed to write init token: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error running token renewal: %v", err)
+	}
+
+	tokenCheckFiles(t, i)
+}
+
+// Log in with an AppRole secret id - new token
+func TestRenew_Token_AppRole(t *testing.T) {
+	k := initKubernetes(t, vaultDev)
+	k.Bootstrap = kubernetes.BootstrapAppRole
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring kubernetes: %v", err)
+	}
+
+	roleID, secretID, err := k.AppRoleSecretID("worker", nil)
+	if err != nil {
+		t.Fatalf("error creating secret id: %v", err)
+	}
+
+	// the node logs in with a client of its own
+	vaultClient, err := vault.NewClient(&vault.Config{Address: vaultDev.Client().Address()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultClient.ClearToken()
+
+	dir := initInstanceToken(t, vaultDev)
+	i := instanceToken.New(vaultClient, dir.Log)
+	i.SetVaultConfigPath(dir.VaultConfigPath())
+	i.SetRoleID(roleID)
+
+	if err := i.WriteTokenFile(i.SecretIDFilePath(), secretID); err != nil {
+		t.Fatalf("failed to write secret id: %v", err)
+	}
+
+	if err := i.TokenRenewRun(); err != nil {
+		t.Fatalf("error running token renewal: %v", err)
+	}
+
+	fileToken, err := i.TokenFromFile(i.TokenFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileToken == "" || fileToken != i.Token() {
+		t.Errorf("unexpected token in file, exp=%s got=%s", i.Token(), fileToken)
+	}
+	if fileSecretID, err := i.TokenFromFile(i.SecretIDFilePath()); err != nil {
+		t.Error(err)
+	} else if fileSecretID != "" {
+		t.Errorf("expected secret id file '%s' to be wiped, got='%s'", i.SecretIDFilePath(), fileSecretID)
+	}
+
+	policies, err := i.TokenPolicies()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	found := false
+	for _, p := range policies {
+		if p == "test-cluster/worker" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected policy 'test-cluster/worker', got %v", policies)
+	}
+
+	// secret ids can only be used once
+	if err := i.WipeTokenFile(i.TokenFilePath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.WriteTokenFile(i.SecretIDFilePath(), secretID); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.TokenRenewRun(); err == nil {
+		t.Error("expected an error logging in with a used secret id")
+	}
+}
+
 // Get ttl form vaultof given token
 func getTTL(v *vault_dev.VaultDev, token string, i *instanceToken.InstanceToken) (ttl int, err error) {
 	s, err := i.TokenLookup()
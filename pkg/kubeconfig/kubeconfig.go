@@ -1,6 +1,9 @@
 package kubeconfig
 
 import (
+	"fmt"
+	"path/filepath"
+
 	"github.com/Sirupsen/logrus"
 	"github.com/jetstack/vault-helper/pkg/cert"
 )
@@ -11,6 +14,11 @@ type Kubeconfig struct {
 	certCA64  string
 	cert64    string
 
+	// hooks run when the content of the kubeconfig or its certificate
+	// changed
+	hooks   *cert.Hooks
+	changed bool
+
 	cert *cert.Cert
 	Log  *logrus.Entry
 }
@@ -37,7 +45,30 @@ func (u *Kubeconfig) RunKube() error {
 		return err
 	}
 
-	return u.StoreYaml(yml)
+	// a changed certificate fires the hooks, even if the kubeconfig is the
+	// same
+	if u.Cert().Changed() {
+		if err := u.Hooks().MarkPending(u.hookMarkerPath()); err != nil {
+			return err
+		}
+	}
+	if err := u.StoreYaml(yml); err != nil {
+		return err
+	}
+
+	if err := u.Hooks().RunPending(u.hookMarkerPath(), &cert.HookEvent{
+		Path:       u.FilePath(),
+		CommonName: u.Cert().CommonName(),
+	}); err != nil {
+		return fmt.Errorf("error running hooks: %v", err)
+	}
+
+	return nil
+}
+
+// Marks the hooks of the kubeconfig pending until they succeeded
+func (u *Kubeconfig) hookMarkerPath() string {
+	return filepath.Clean(u.FilePath() + ".hook-pending")
 }
 
 func (u *Kubeconfig) SetCert(cert *cert.Cert) {
@@ -47,6 +78,18 @@ func (u *Kubeconfig) Cert() (c *cert.Cert) {
 	return u.cert
 }
 
+func (u *Kubeconfig) SetHooks(hooks *cert.Hooks) {
+	u.hooks = hooks
+}
+func (u *Kubeconfig) Hooks() *cert.Hooks {
+	return u.hooks
+}
+
+// Whether the last run changed the content of the kubeconfig
+func (u *Kubeconfig) Changed() bool {
+	return u.changed
+}
+
 func (u *Kubeconfig) SetFilePath(path string) {
 	u.filePath = path
 }
@@ -7,8 +7,10 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/jetstack/vault-helper/pkg/cert"
@@ -114,6 +116,76 @@ func TestKubeconf_File_Perms(t *testing.T) {
 	checkOwnerGroup(t, yaml)
 }
 
+// Hooks only run if the kubeconfig changed
+func TestKubeconf_Hooks(t *testing.T) {
+	initKubernetes(t, vaultDev)
+	c := initCert(t, vaultDev)
+
+	if err := c.InstanceToken().WriteTokenFile(c.InstanceToken().InitTokenFilePath(), vault_dev.RootTokenDev); err != nil {
+		t.Fatalf("error setting token for test: %v", err)
+	}
+
+	u := initKubeconf(t, c)
+	out := u.FilePath() + ".hook"
+	hooks := cert.NewHooks(u.Log)
+	hooks.Exec = "echo $VAULT_HELPER_PATH >> " + out
+	u.SetHooks(hooks)
+
+	runs := func() int {
+		if err := c.RunCert(); err != nil {
+			t.Fatalf("error runinning cert: %v", err)
+		}
+		if err := u.RunKube(); err != nil {
+			t.Fatalf("error runinning kubeconfig: %v", err)
+		}
+		dat, err := ioutil.ReadFile(out)
+		if os.IsNotExist(err) {
+			return 0
+		} else if err != nil {
+			t.Fatalf("error reading hook output: %v", err)
+		}
+		return strings.Count(string(dat), u.FilePath()+"\n")
+	}
+
+	if n := runs(); n != 1 || !u.Changed() {
+		t.Errorf("expected hook to run for new kubeconfig, ran %d times", n)
+	}
+	if n := runs(); n != 1 || u.Changed() {
+		t.Errorf("unexpected hook run for unchanged kubeconfig, ran %d times", n)
+	}
+
+	c.SetRenewBefore(time.Hour * 24 * 31)
+	if n := runs(); n != 2 {
+		t.Errorf("expected hook to run for kubeconfig of renewed certificate, ran %d times", n)
+	}
+
+	// failed hooks stay pending until a later run succeeds
+	hooks.Exec = "exit 1"
+	hooks.Retries = 1
+	hooks.RetryDelay = time.Millisecond
+	if err := c.RunCert(); err != nil {
+		t.Fatalf("error runinning cert: %v", err)
+	}
+	if err := u.RunKube(); err == nil {
+		t.Error("expected error of failing hook")
+	}
+	marker := u.FilePath() + ".hook-pending"
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("expected pending hooks marker: %v", err)
+	}
+	c.SetRenewBefore(0)
+	hooks.Exec = "echo $VAULT_HELPER_PATH >> " + out
+	if n := runs(); n != 3 || u.Changed() || c.Changed() {
+		t.Errorf("expected pending hook to run for unchanged kubeconfig, ran %d times", n)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("expected pending hooks marker to be removed: %v", err)
+	}
+	if n := runs(); n != 3 {
+		t.Errorf("unexpected hook run after pending hooks succeeded, ran %d times", n)
+	}
+}
+
 func TestKubeconf_Cert_Data(t *testing.T) {
 	initKubernetes(t, vaultDev)
 	c := initCert(t, vaultDev)
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	b64 "encoding/base64"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -75,6 +76,14 @@ func (u *Kubeconfig) EncodeCerts() error {
 func (u *Kubeconfig) StoreYaml(yml string) error {
 	path := filepath.Clean(u.FilePath())
 
+	dat, err := ioutil.ReadFile(path)
+	u.changed = err != nil || string(dat) != yml
+	if u.changed {
+		if err := u.Hooks().MarkPending(u.hookMarkerPath()); err != nil {
+			return err
+		}
+	}
+
 	file, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("error creating yaml file at '%s': %v", path, err)
@@ -0,0 +1,233 @@
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagBootstrap = "bootstrap"
+const FlagAppRoleSecretIDNumUses = "approle-secret-id-num-uses"
+const FlagAppRoleSecretIDTTL = "approle-secret-id-ttl"
+const FlagAppRoleCIDRs = "cidr"
+
+// How nodes get their first token
+const BootstrapInitToken = "init-token"
+const BootstrapAppRole = "approle"
+
+// Path of the AppRole auth backend, which is shared by all clusters
+const appRoleAuthPath = "approle"
+
+// An AppRole nodes of a class log in with, instead of an init token
+type AppRole struct {
+	Role       string
+	Policies   []string
+	kubernetes *Kubernetes
+}
+
+func (k *Kubernetes) NewAppRole(role string, policies []string) *AppRole {
+	return &AppRole{
+		Role:       role,
+		Policies:   policies,
+		kubernetes: k,
+	}
+}
+
+// Build the AppRoles of the spec, with the policies of its init tokens
+func (k *Kubernetes) specAppRoles() []*AppRole {
+	var appRoles []*AppRole
+	for _, i := range k.specInitTokens() {
+		appRoles = append(appRoles, k.NewAppRole(i.Role, i.Policies))
+	}
+	return appRoles
+}
+
+func (a *AppRole) Name() string {
+	return fmt.Sprintf("%s-%s", a.kubernetes.clusterID, a.Role)
+}
+
+func (a *AppRole) Path() string {
+	return filepath.Join("auth", appRoleAuthPath, "role", a.Name())
+}
+
+// Data of the AppRole, tokens are periodic like the ones created with init
+// tokens
+func (a *AppRole) roleData() map[string]interface{} {
+	// vault adds the default policy to every role
+	policies := append([]string{"default"}, a.Policies...)
+
+	return map[string]interface{}{
+		"policies":           strings.Join(policies, ","),
+		"period":             fmt.Sprintf("%ds", int(a.kubernetes.MaxValidityComponents.Seconds())),
+		"bind_secret_id":     true,
+		"secret_id_num_uses": a.kubernetes.AppRoleSecretIDNumUses,
+		"secret_id_ttl":      fmt.Sprintf("%ds", int(a.kubernetes.AppRoleSecretIDTTL.Seconds())),
+	}
+}
+
+func (a *AppRole) Ensure() error {
+	if _, err := a.kubernetes.vaultClient.Logical().Write(a.Path(), a.roleData()); err != nil {
+		return fmt.Errorf("error writing AppRole %s: %v", a.Path(), err)
+	}
+	return nil
+}
+
+func (a *AppRole) RoleID() (string, error) {
+	path := filepath.Join(a.Path(), "role-id")
+	s, err := a.kubernetes.vaultClient.Logical().Read(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading role id '%s': %v", path, err)
+	}
+	if s == nil {
+		return "", fmt.Errorf("AppRole '%s' not found, run setup first", a.Name())
+	}
+
+	return secretString(s, "role_id")
+}
+
+// Create a secret id, which can only be used from the given CIDRs if any
+func (a *AppRole) NewSecretID(cidrs []string) (string, error) {
+	data := map[string]interface{}{}
+	if len(cidrs) > 0 {
+		data["cidr_list"] = strings.Join(cidrs, ",")
+	}
+
+	path := filepath.Join(a.Path(), "secret-id")
+	s, err := a.kubernetes.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		return "", fmt.Errorf("error creating secret id '%s': %v", path, err)
+	}
+	if s == nil {
+		return "", fmt.Errorf("no secret id returned by '%s'", path)
+	}
+
+	return secretString(s, "secret_id")
+}
+
+func (a *AppRole) Plan() (*Change, error) {
+	s, err := a.kubernetes.vaultClient.Logical().Read(a.Path())
+	if err != nil {
+		return nil, fmt.Errorf("error reading AppRole '%s': %v", a.Path(), err)
+	}
+	return planData(KindAppRole, a.Path(), a.roleData(), s), nil
+}
+
+func (k *Kubernetes) appRoleAuthEnabled() (bool, error) {
+	auths, err := k.vaultClient.Sys().ListAuth()
+	if err != nil {
+		return false, fmt.Errorf("error listing auth backends: %v", err)
+	}
+
+	_, ok := auths[appRoleAuthPath+"/"]
+	return ok, nil
+}
+
+func (k *Kubernetes) ensureAppRoles() error {
+	enabled, err := k.appRoleAuthEnabled()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if err := k.vaultClient.Sys().EnableAuth(appRoleAuthPath, "approle", "AppRoles of kubernetes nodes"); err != nil {
+			return fmt.Errorf("error enabling AppRole auth: %v", err)
+		}
+		k.Log.Infof("Enabled AppRole auth at 'auth/%s'", appRoleAuthPath)
+	}
+
+	var result error
+	for _, appRole := range k.specAppRoles() {
+		if err := appRole.Ensure(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+func (k *Kubernetes) planAppRoles() ([]*Change, error) {
+	enabled, err := k.appRoleAuthEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Change{Kind: KindAuth, Path: filepath.Join("auth", appRoleAuthPath), Action: ActionNoop}
+	if !enabled {
+		auth.Action = ActionCreate
+	}
+	changes := []*Change{auth}
+
+	for _, appRole := range k.specAppRoles() {
+		change := &Change{Kind: KindAppRole, Path: appRole.Path(), Action: ActionCreate}
+		if enabled {
+			if change, err = appRole.Plan(); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// The role ids of the AppRoles by node class
+func (k *Kubernetes) AppRoleIDs() (map[string]string, error) {
+	var result error
+	output := map[string]string{}
+	for _, appRole := range k.specAppRoles() {
+		roleID, err := appRole.RoleID()
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		output[appRole.Role] = roleID
+	}
+	return output, result
+}
+
+// Create a secret id for a node class, see AppRole.NewSecretID
+func (k *Kubernetes) AppRoleSecretID(role string, cidrs []string) (roleID, secretID string, err error) {
+	initToken, err := k.initToken(role)
+	if err != nil {
+		return "", "", err
+	}
+	appRole := k.NewAppRole(initToken.Role, initToken.Policies)
+
+	if roleID, err = appRole.RoleID(); err != nil {
+		return "", "", err
+	}
+	if secretID, err = appRole.NewSecretID(cidrs); err != nil {
+		return "", "", err
+	}
+
+	return roleID, secretID, nil
+}
+
+// Delete the AppRoles of the cluster, the auth backend is shared and stays
+func (k *Kubernetes) deleteAppRoles() error {
+	enabled, err := k.appRoleAuthEnabled()
+	if err != nil || !enabled {
+		return err
+	}
+
+	appRoles := k.specAppRoles()
+
+	// nodes added with their own identity
+	names, err := k.nodeNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		appRoles = append(appRoles, k.NewAppRole(nodeRolePrefix+name, nil))
+	}
+
+	var result error
+	for _, appRole := range appRoles {
+		if _, err := k.vaultClient.Logical().Delete(appRole.Path()); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting AppRole '%s': %v", appRole.Path(), err))
+			continue
+		}
+		k.Log.Debugf("Deleted AppRole '%s'", appRole.Path())
+	}
+	return result
+}
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagAuditFormat = "format"
+
+// Roles and policies that differ from what setup would write
+type Audit struct {
+	ClusterID string    `json:"clusterID"`
+	Drift     []*Change `json:"drift"`
+}
+
+// Compare the PKI roles and policies in vault with what setup would write,
+// without writing anything
+func (k *Kubernetes) Audit() (*Audit, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	audit := &Audit{ClusterID: k.clusterID}
+	var changes []*Change
+	var result error
+
+	for _, p := range k.Spec().PKIs {
+		if err := k.PKI(p.Name).loadGenerations(); err != nil {
+			return nil, err
+		}
+	}
+	// policies grant access to secrets by the version of the mount
+	if _, err := k.secretsGeneric.KVVersion(); err != nil {
+		return nil, err
+	}
+
+	for _, p := range k.Spec().PKIs {
+		for _, role := range p.Roles {
+			change, err := k.PKI(p.Name).planRole(k.pkiRole(role))
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			changes = append(changes, change)
+		}
+	}
+
+	policies := k.Policies()
+	if k.Bootstrap == BootstrapAppRole {
+		for _, appRole := range k.specAppRoles() {
+			change, err := appRole.Plan()
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			changes = append(changes, change)
+		}
+	}
+	if enabled, err := k.certAuthEnabled(); err != nil {
+		result = multierror.Append(result, err)
+	} else if enabled {
+		caPEM, err := k.certAuthCAs()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range k.Spec().CertAuth {
+			change, err := k.planCertAuthRole(s, caPEM)
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			changes = append(changes, change)
+		}
+	}
+	for _, p := range policies {
+		change, err := k.planPolicy(p)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	for _, change := range changes {
+		if change.Action != ActionNoop {
+			audit.Drift = append(audit.Drift, change)
+		}
+	}
+
+	return audit, result
+}
+
+func (a *Audit) HasDrift() bool {
+	return len(a.Drift) > 0
+}
+
+func (a *Audit) JSON() ([]byte, error) {
+	return json.MarshalIndent(a, "", "  ")
+}
+
+func (a *Audit) String() string {
+	var buf bytes.Buffer
+
+	if !a.HasDrift() {
+		fmt.Fprintf(&buf, "No drift found for cluster '%s'.\n", a.ClusterID)
+		return buf.String()
+	}
+
+	fmt.Fprintf(&buf, "Drift found for cluster '%s':\n", a.ClusterID)
+	for _, c := range a.Drift {
+		if c.Action == ActionCreate {
+			fmt.Fprintf(&buf, "  - %-11s %s (missing)\n", c.Kind, c.Path)
+			continue
+		}
+
+		fmt.Fprintf(&buf, "  ~ %-11s %s\n", c.Kind, c.Path)
+		for _, f := range c.Fields {
+			fmt.Fprintf(&buf, "      %s: %s, expected %s\n", f.Field, formatValue(f.Current), formatValue(f.Desired))
+		}
+	}
+	fmt.Fprintf(&buf, "%d drifted.\n", len(a.Drift))
+
+	return buf.String()
+}
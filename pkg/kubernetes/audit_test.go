@@ -0,0 +1,286 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := parsePolicy("test", `
+path "a/b" {
+  capabilities = ["update", "create"]
+}
+
+path "a/c" {
+  policy = "read"
+}
+
+path "a/b" {
+  capabilities = ["read"]
+}
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	caps := p.capabilities()
+	if exp, act := "create,read,update", strings.Join(caps["a/b"], ","); exp != act {
+		t.Errorf("unexpected capabilities of 'a/b', exp=%s got=%s", exp, act)
+	}
+	if exp, act := "list,read", strings.Join(caps["a/c"], ","); exp != act {
+		t.Errorf("unexpected capabilities of 'a/c', exp=%s got=%s", exp, act)
+	}
+
+	// a written policy has to parse to the same capabilities
+	k := New(nil, nil)
+	k.SetClusterID("test")
+	worker := k.workerPolicy()
+	parsed, err := parsePolicy(worker.Name, worker.Policy())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fields := diffPolicies(worker, parsed); len(fields) != 0 {
+		t.Errorf("unexpected differences: %+v", fields)
+	}
+
+	// parameter constraints of node policies survive the round trip too
+	node, err := k.NewNode("node-1", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodePolicy := node.policy()
+	parsed, err = parsePolicy(nodePolicy.Name, nodePolicy.Policy())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fields := diffPolicies(nodePolicy, parsed); len(fields) != 0 {
+		t.Errorf("unexpected differences: %+v", fields)
+	}
+	if exp, act := `alt_names=["", "system:node:node-1"]`, parsed.constraints()["test/pki/k8s/sign/kubelet"]["allowed_parameters"]; !strings.Contains(act, exp) {
+		t.Errorf("unexpected parameters, exp to contain %s got=%s", exp, act)
+	}
+
+	if _, err := parsePolicy("test", `path "a" {`); err == nil {
+		t.Error("expected an error parsing invalid rules")
+	}
+}
+
+func TestParsePolicy_Constraints(t *testing.T) {
+	desired := &Policy{
+		Name: "test",
+		Policies: []*policyPath{
+			&policyPath{
+				path:         "test/pki/k8s/sign/kube-apiserver",
+				capabilities: []string{"create", "update"},
+				allowedParameters: map[string][]string{
+					"common_name": []string{"kube-apiserver"},
+					"alt_names":   []string{"kubernetes", "kubernetes.default"},
+				},
+				deniedParameters: map[string][]string{
+					"ttl": []string{},
+				},
+				requiredParameters: []string{"csr", "common_name"},
+				minWrappingTTL:     time.Minute,
+				maxWrappingTTL:     time.Hour,
+			},
+			&policyPath{
+				path: "test/secrets/service-accounts",
+				deny: true,
+			},
+		},
+	}
+
+	parsed, err := parsePolicy(desired.Name, desired.Policy())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fields := diffPolicies(desired, parsed); len(fields) != 0 {
+		t.Errorf("unexpected differences: %+v", fields)
+	}
+	if !parsed.Policies[1].deny {
+		t.Error("expected the path to be denied")
+	}
+	if exp, act := time.Hour, parsed.Policies[0].maxWrappingTTL; exp != act {
+		t.Errorf("unexpected max_wrapping_ttl, exp=%s got=%s", exp, act)
+	}
+
+	// the order of values and the format of TTLs don't matter
+	current, err := parsePolicy("test", `
+path "test/pki/k8s/sign/kube-apiserver" {
+  capabilities = ["update", "create"]
+  allowed_parameters = {
+    "alt_names" = ["kubernetes.default", "kubernetes"]
+    "common_name" = ["kube-apiserver"]
+  }
+  denied_parameters = {
+    "ttl" = []
+  }
+  required_parameters = ["common_name", "csr"]
+  min_wrapping_ttl = 60
+  max_wrapping_ttl = "1h"
+}
+
+path "test/secrets/service-accounts" {
+  policy = "deny"
+}
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fields := diffPolicies(desired, current); len(fields) != 0 {
+		t.Errorf("unexpected differences: %+v", fields)
+	}
+
+	current.Policies[0].allowedParameters["alt_names"] = []string{"*"}
+	current.Policies[0].maxWrappingTTL = 0
+	current.Policies[1].deny = false
+	current.Policies[1].capabilities = []string{"read"}
+	fields := diffPolicies(desired, current)
+	var names []string
+	for _, field := range fields {
+		names = append(names, field.Field)
+	}
+	if exp, act := `path "test/secrets/service-accounts",path "test/pki/k8s/sign/kube-apiserver" allowed_parameters,path "test/pki/k8s/sign/kube-apiserver" max_wrapping_ttl`, strings.Join(names, ","); exp != act {
+		t.Errorf("unexpected differences, exp=%s got=%s", exp, act)
+	}
+
+	if _, err := parsePolicy("test", `path "a" { max_wrapping_ttl = "forever" }`); err == nil {
+		t.Error("expected an error parsing an invalid wrapping TTL")
+	}
+}
+
+// Values of a spec containing quotes or backslashes can't break out of their
+// HCL string
+func TestParsePolicy_Escaping(t *testing.T) {
+	desired := &Policy{
+		Name: "test",
+		Policies: []*policyPath{
+			&policyPath{
+				path:         "test/pki/k8s/sign/kube-apiserver",
+				capabilities: []string{"create", "update"},
+				allowedParameters: map[string][]string{
+					"common_name": []string{`a"] } path "*" { capabilities = ["sudo"`, `b\`},
+				},
+			},
+		},
+	}
+
+	parsed, err := parsePolicy(desired.Name, desired.Policy())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := 1, len(parsed.Policies); exp != act {
+		t.Fatalf("unexpected number of paths, exp=%d got=%d:\n%s", exp, act, desired.Policy())
+	}
+	if fields := diffPolicies(desired, parsed); len(fields) != 0 {
+		t.Errorf("unexpected differences: %+v", fields)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	audit, err := k.Audit()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if audit.HasDrift() {
+		t.Errorf("expected no drift after ensure:\n%s", audit)
+	}
+
+	// edit by hand
+	if _, err := vault.Client().Logical().Write("test/pki/k8s/roles/kubelet", map[string]interface{}{
+		"allowed_domains": "evil",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := vault.Client().Sys().PutPolicy("test/worker", `path "test/pki/k8s/sign/kubelet" { capabilities = ["sudo"] }`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := vault.Client().Sys().DeletePolicy("test/etcd"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	audit, err = k.Audit()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	drift := map[string]*Change{}
+	for _, c := range audit.Drift {
+		drift[c.Path] = c
+	}
+	if exp, act := 3, len(drift); exp != act {
+		t.Errorf("unexpected number of drifted objects, exp=%d got=%d:\n%s", exp, act, audit)
+	}
+	if c, ok := drift["test/etcd"]; !ok || c.Action != ActionCreate {
+		t.Errorf("expected policy 'test/etcd' to be missing:\n%s", audit)
+	}
+	if c, ok := drift["test/worker"]; !ok || len(c.Fields) != 5 {
+		t.Errorf("expected five paths of policy 'test/worker' to differ:\n%s", audit)
+	}
+	if c, ok := drift["test/pki/k8s/roles/kubelet"]; !ok {
+		t.Errorf("expected role 'kubelet' to differ:\n%s", audit)
+	} else {
+		found := false
+		for _, f := range c.Fields {
+			found = found || f.Field == "allowed_domains"
+		}
+		if !found {
+			t.Errorf("expected field 'allowed_domains' of role 'kubelet' to differ:\n%s", audit)
+		}
+	}
+
+	// setup reconciles the drift
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	audit, err = k.Audit()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if audit.HasDrift() {
+		t.Errorf("expected no drift after ensure:\n%s", audit)
+	}
+
+	// fields the role data leaves at their defaults are audited too
+	s, err := vault.Client().Logical().Read("test/pki/k8s/roles/admin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Data["allow_any_name"] = true
+	s.Data["allow_glob_domains"] = true
+	if _, err := vault.Client().Logical().Write("test/pki/k8s/roles/admin", s.Data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	audit, err = k.Audit()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := 1, len(audit.Drift); exp != act {
+		t.Fatalf("unexpected number of drifted objects, exp=%d got=%d:\n%s", exp, act, audit)
+	}
+	var fields []string
+	for _, f := range audit.Drift[0].Fields {
+		fields = append(fields, f.Field)
+	}
+	if exp, act := "allow_any_name,allow_glob_domains", strings.Join(fields, ","); audit.Drift[0].Path != "test/pki/k8s/roles/admin" || exp != act {
+		t.Errorf("expected fields %s of role 'admin' to differ:\n%s", exp, audit)
+	}
+}
@@ -0,0 +1,689 @@
+package kubernetes
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	vault "github.com/hashicorp/vault/api"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	// keys without hash preferences default to RIPEMD160
+	_ "golang.org/x/crypto/ripemd160"
+
+	"github.com/jetstack/vault-helper/pkg/kv"
+)
+
+const FlagBackupPassphraseFile = "passphrase-file"
+const FlagBackupPGPRecipient = "pgp-recipient"
+const FlagBackupPGPKey = "pgp-key"
+const FlagRestoreAllowNewCA = "allow-new-ca"
+
+const BackupVersion = "v1"
+
+// Everything setup writes for a cluster, except auth backends, which get
+// recreated by running setup after a restore
+type Backup struct {
+	Metadata   *BackupMetadata `json:"metadata"`
+	Mounts     []*BackupMount  `json:"mounts"`
+	CAs        []*BackupCA     `json:"cas"`
+	Roles      []*BackupData   `json:"roles"`
+	Policies   []*BackupPolicy `json:"policies"`
+	TokenRoles []*BackupData   `json:"tokenRoles"`
+	Secrets    []*BackupData   `json:"secrets"`
+}
+
+type BackupMetadata struct {
+	Version   string    `json:"version"`
+	ClusterID string    `json:"clusterID"`
+	Created   time.Time `json:"created"`
+}
+
+type BackupMount struct {
+	Path            string `json:"path"`
+	Type            string `json:"type"`
+	Description     string `json:"description"`
+	DefaultLeaseTTL int    `json:"defaultLeaseTTL"`
+	MaxLeaseTTL     int    `json:"maxLeaseTTL"`
+	// KVVersion of the secrets mount
+	KVVersion int `json:"kvVersion,omitempty"`
+}
+
+// The CA of a PKI mount, the private key is only known for exported CAs
+type BackupCA struct {
+	Path        string `json:"path"`
+	Certificate string `json:"certificate"`
+	PrivateKey  string `json:"privateKey,omitempty"`
+}
+
+// What a restore couldn't bring back as it was backed up
+type RestoreResult struct {
+	// NewCAs are the PKI mounts without a CA, which was not exported, so
+	// the next setup generates a new one
+	NewCAs []string
+}
+
+// Data of a vault path, for secrets the key within the secrets mount
+type BackupData struct {
+	Path string                 `json:"path"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// Data to write back, vault reads lists of values, which older versions only
+// accept as comma separated strings
+func (d *BackupData) writeData() map[string]interface{} {
+	data := map[string]interface{}{}
+	for key, value := range d.Data {
+		if list, ok := value.([]interface{}); ok {
+			var values []string
+			for _, v := range list {
+				values = append(values, fmt.Sprintf("%v", v))
+			}
+			value = strings.Join(values, ",")
+		}
+		data[key] = value
+	}
+	return data
+}
+
+type BackupPolicy struct {
+	Name  string `json:"name"`
+	Rules string `json:"rules"`
+}
+
+// The files of a backup archive and the sections stored in them
+func (b *Backup) files() []struct {
+	name string
+	v    interface{}
+} {
+	return []struct {
+		name string
+		v    interface{}
+	}{
+		{"metadata.json", &b.Metadata},
+		{"mounts.json", &b.Mounts},
+		{"cas.json", &b.CAs},
+		{"roles.json", &b.Roles},
+		{"policies.json", &b.Policies},
+		{"token_roles.json", &b.TokenRoles},
+		{"secrets.json", &b.Secrets},
+	}
+}
+
+// Encryption of backup archives, either with a passphrase or for PGP
+// recipients. Decrypting a PGP encrypted archive needs the private keys of a
+// recipient, the passphrase unlocks them if they are encrypted.
+type BackupEncryption struct {
+	Passphrase []byte
+	Recipients openpgp.EntityList
+	Keys       openpgp.EntityList
+}
+
+// Read armored PGP keys from a file
+func ReadPGPKeys(path string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading PGP keys from '%s': %v", path, err)
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing PGP keys from '%s': %v", path, err)
+	}
+
+	return keys, nil
+}
+
+// Read a passphrase from a file, ignoring trailing line breaks
+func ReadPassphrase(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading passphrase from '%s': %v", path, err)
+	}
+
+	passphrase := bytes.TrimRight(data, "\r\n")
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase file '%s' is empty", path)
+	}
+
+	return passphrase, nil
+}
+
+// Collect everything setup wrote for the cluster. Private keys of CAs are
+// only included if they have been generated exported.
+func (k *Kubernetes) Backup() (*Backup, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	b := &Backup{
+		Metadata: &BackupMetadata{
+			Version:   BackupVersion,
+			ClusterID: k.clusterID,
+			Created:   time.Now().UTC(),
+		},
+	}
+
+	if err := k.backupMounts(b); err != nil {
+		return nil, err
+	}
+
+	var result error
+	for _, m := range b.Mounts {
+		if m.Type != "pki" {
+			continue
+		}
+		if err := k.backupPKI(b, m.Path); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if err := k.backupPolicies(b); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := k.backupTokenRoles(b); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := k.secretsGeneric.backupSecrets(b); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if result != nil {
+		return nil, result
+	}
+
+	return b, nil
+}
+
+// The PKI mounts of all CA generations and the secrets mount
+func (k *Kubernetes) backupMounts(b *Backup) error {
+	mounts, err := k.vaultClient.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("error listing mounts: %v", err)
+	}
+
+	prefix := filepath.Join(k.Path(), "pki") + "/"
+	for path, mount := range mounts {
+		path = filepath.Clean(path)
+		if !(strings.HasPrefix(path, prefix) && mount.Type == "pki") && path != k.secretsGeneric.Path() {
+			continue
+		}
+
+		m := &BackupMount{
+			Path:            path,
+			Type:            mount.Type,
+			Description:     mount.Description,
+			DefaultLeaseTTL: mount.Config.DefaultLeaseTTL,
+			MaxLeaseTTL:     mount.Config.MaxLeaseTTL,
+		}
+		if path == k.secretsGeneric.Path() {
+			if m.KVVersion, err = k.secretsGeneric.KVVersion(); err != nil {
+				return err
+			}
+		}
+		b.Mounts = append(b.Mounts, m)
+	}
+	sort.Slice(b.Mounts, func(i, j int) bool { return b.Mounts[i].Path < b.Mounts[j].Path })
+
+	return nil
+}
+
+// The CA and the roles of a PKI mount
+func (k *Kubernetes) backupPKI(b *Backup, path string) error {
+	s, err := k.vaultClient.Logical().Read(filepath.Join(path, "cert", "ca"))
+	if err != nil {
+		return fmt.Errorf("error reading CA of '%s': %v", path, err)
+	}
+	certPEM, err := secretString(s, "certificate")
+	if err != nil || certPEM == "" {
+		k.Log.Warnf("Skipping CA of '%s', it has no certificate", path)
+	} else {
+		key, err := k.secretsGeneric.CAKeyStore(filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		if key == "" {
+			k.Log.Warnf("Private key of CA '%s' is not exported, it can't be restored", path)
+		}
+		b.CAs = append(b.CAs, &BackupCA{Path: path, Certificate: certPEM, PrivateKey: key})
+	}
+
+	rolesPath := filepath.Join(path, "roles")
+	roles, err := listKeys(k.vaultClient, rolesPath)
+	if err != nil {
+		return err
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		rolePath := filepath.Join(rolesPath, role)
+		s, err := k.vaultClient.Logical().Read(rolePath)
+		if err != nil {
+			return fmt.Errorf("error reading role '%s': %v", rolePath, err)
+		}
+		if s == nil {
+			continue
+		}
+		b.Roles = append(b.Roles, &BackupData{Path: rolePath, Data: s.Data})
+	}
+
+	return nil
+}
+
+func (k *Kubernetes) backupPolicies(b *Backup) error {
+	policies, err := k.vaultClient.Sys().ListPolicies()
+	if err != nil {
+		return fmt.Errorf("error listing policies: %v", err)
+	}
+	sort.Strings(policies)
+
+	for _, name := range policies {
+		if !strings.HasPrefix(name, k.clusterID+"/") {
+			continue
+		}
+
+		rules, err := k.vaultClient.Sys().GetPolicy(name)
+		if err != nil {
+			return fmt.Errorf("error reading policy '%s': %v", name, err)
+		}
+		b.Policies = append(b.Policies, &BackupPolicy{Name: name, Rules: rules})
+	}
+
+	return nil
+}
+
+func (k *Kubernetes) backupTokenRoles(b *Backup) error {
+	path := "auth/token/roles"
+	roles, err := listKeys(k.vaultClient, path)
+	if err != nil {
+		return err
+	}
+	sort.Strings(roles)
+
+	for _, role := range roles {
+		if !strings.HasPrefix(role, k.clusterID+"-") {
+			continue
+		}
+
+		// the prefix is shared with clusters like '<cluster>-2'
+		rolePath := filepath.Join(path, role)
+		s, err := k.vaultClient.Logical().Read(rolePath)
+		if err != nil {
+			return fmt.Errorf("error reading token role '%s': %v", rolePath, err)
+		}
+		if s == nil {
+			continue
+		}
+		if suffix, ok := s.Data["path_suffix"].(string); !ok || !strings.HasPrefix(suffix, k.clusterID+"/") {
+			continue
+		}
+
+		b.TokenRoles = append(b.TokenRoles, &BackupData{Path: rolePath, Data: s.Data})
+	}
+
+	return nil
+}
+
+// The secrets, without the escrowed CA keys, which are part of the CAs
+func (g *Generic) backupSecrets(b *Backup) error {
+	version, err := g.KVVersion()
+	if err != nil {
+		return err
+	}
+
+	keys, err := listKeysRecursive(g.kubernetes.vaultClient, kv.MetadataPath(g.Path(), version, ""))
+	if err != nil {
+		return err
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if strings.HasPrefix(key, g.caKeyKey("")) {
+			continue
+		}
+
+		s, err := g.readSecret(key)
+		if err != nil {
+			return fmt.Errorf("error reading secret '%s': %v", filepath.Join(g.Path(), key), err)
+		}
+		if s == nil {
+			continue
+		}
+		b.Secrets = append(b.Secrets, &BackupData{Path: key, Data: s.Data})
+	}
+
+	return nil
+}
+
+// Recreate the content of a backup. Existing CAs are kept, everything else
+// gets overwritten. Run setup afterwards to recreate the auth backends and to
+// replace init tokens, which are only valid on the vault they were created
+// with. CAs that were not exported and are missing in vault fail the restore,
+// as certificates of the new CA setup would generate aren't trusted by
+// anything, unless allowNewCA is set.
+func (k *Kubernetes) Restore(b *Backup, allowNewCA bool) (*RestoreResult, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+	if b.Metadata == nil || b.Metadata.Version != BackupVersion {
+		return nil, errors.New("unsupported backup version")
+	}
+	if b.Metadata.ClusterID != k.clusterID {
+		return nil, fmt.Errorf("backup is of cluster '%s', not '%s'", b.Metadata.ClusterID, k.clusterID)
+	}
+
+	// check before writing anything, a failed restore leaves vault as it was
+	restoreResult := &RestoreResult{}
+	for _, ca := range b.CAs {
+		if ca.PrivateKey != "" {
+			continue
+		}
+		exists, err := k.caExists(ca.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			restoreResult.NewCAs = append(restoreResult.NewCAs, ca.Path)
+		}
+	}
+	if len(restoreResult.NewCAs) > 0 && !allowNewCA {
+		return restoreResult, fmt.Errorf("CAs of %s have not been exported, setup would generate new ones, which nothing trusts. Pass --%s to restore anyway", strings.Join(restoreResult.NewCAs, ", "), FlagRestoreAllowNewCA)
+	}
+
+	for _, m := range b.Mounts {
+		if err := k.restoreMount(m); err != nil {
+			return restoreResult, err
+		}
+	}
+
+	// CA keys get escrowed in the secrets mount, it has to exist first
+	if err := k.secretsGeneric.restoreSecrets(b); err != nil {
+		return restoreResult, err
+	}
+
+	var result error
+	for _, ca := range b.CAs {
+		if err := k.restoreCA(ca); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	for _, r := range b.Roles {
+		if _, err := k.vaultClient.Logical().Write(r.Path, r.writeData()); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error writing role '%s': %v", r.Path, err))
+		}
+	}
+
+	for _, p := range b.Policies {
+		if err := k.vaultClient.Sys().PutPolicy(p.Name, p.Rules); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error writing policy '%s': %v", p.Name, err))
+		}
+	}
+
+	for _, r := range b.TokenRoles {
+		if _, err := k.vaultClient.Logical().Write(r.Path, r.writeData()); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error writing token role '%s': %v", r.Path, err))
+		}
+	}
+
+	if result != nil {
+		return restoreResult, result
+	}
+
+	k.Log.Infof("Restored %d mounts, %d CAs, %d roles, %d policies, %d token roles and %d secrets of cluster '%s'", len(b.Mounts), len(b.CAs), len(b.Roles), len(b.Policies), len(b.TokenRoles), len(b.Secrets), k.clusterID)
+
+	return restoreResult, nil
+}
+
+// Mount a missing mount and tune the lease TTLs of existing ones
+func (k *Kubernetes) restoreMount(m *BackupMount) error {
+	mount, err := GetMountByPath(k.vaultClient, m.Path)
+	if err != nil {
+		return err
+	}
+
+	if mount != nil && mount.Type != m.Type {
+		return fmt.Errorf("mount '%s' exists with type '%s', expected '%s'", m.Path, mount.Type, m.Type)
+	}
+
+	if mount == nil && m.Path == k.secretsGeneric.Path() {
+		if err := k.secretsGeneric.mount(m.KVVersion); err != nil {
+			return err
+		}
+	} else if mount == nil {
+		if err := k.vaultClient.Sys().Mount(m.Path, &vault.MountInput{Type: m.Type, Description: m.Description}); err != nil {
+			return fmt.Errorf("error mounting '%s': %v", m.Path, err)
+		}
+		k.Log.Infof("Mounted '%s'", m.Path)
+	}
+
+	config := vault.MountConfigInput{
+		DefaultLeaseTTL: fmt.Sprintf("%ds", m.DefaultLeaseTTL),
+		MaxLeaseTTL:     fmt.Sprintf("%ds", m.MaxLeaseTTL),
+	}
+	if err := k.vaultClient.Sys().TuneMount(m.Path, config); err != nil {
+		return fmt.Errorf("error tuning mount '%s': %v", m.Path, err)
+	}
+
+	return nil
+}
+
+// Whether the PKI mount of the path has a CA
+func (k *Kubernetes) caExists(path string) (bool, error) {
+	s, err := k.vaultClient.Logical().Read(filepath.Join(path, "cert", "ca"))
+	if err != nil {
+		return false, fmt.Errorf("error reading CA of '%s': %v", path, err)
+	}
+	certPEM, err := secretString(s, "certificate")
+	return err == nil && certPEM != "", nil
+}
+
+// Import a CA with its private key, CAs without a key get generated by the
+// next setup
+func (k *Kubernetes) restoreCA(ca *BackupCA) error {
+	exists, err := k.caExists(ca.Path)
+	if err != nil {
+		return err
+	}
+	if exists {
+		k.Log.Infof("Keeping existing CA of '%s'", ca.Path)
+		return nil
+	}
+
+	if ca.PrivateKey == "" {
+		k.Log.Warnf("CA of '%s' has not been exported, setup will generate a new CA", ca.Path)
+		return nil
+	}
+
+	path := filepath.Join(ca.Path, "config", "ca")
+	data := map[string]interface{}{
+		"pem_bundle": strings.TrimSpace(ca.PrivateKey) + "\n" + ca.Certificate,
+	}
+	if _, err := k.vaultClient.Logical().Write(path, data); err != nil {
+		return fmt.Errorf("error importing CA of '%s': %v", ca.Path, err)
+	}
+
+	if err := k.secretsGeneric.SetCAKeyStore(filepath.Base(ca.Path), ca.PrivateKey); err != nil {
+		return err
+	}
+	k.Log.Infof("Imported CA of '%s'", ca.Path)
+
+	return nil
+}
+
+func (g *Generic) restoreSecrets(b *Backup) error {
+	for _, s := range b.Secrets {
+		if err := g.writeSecret(s.Path, s.Data); err != nil {
+			return fmt.Errorf("error writing secret '%s': %v", filepath.Join(g.Path(), s.Path), err)
+		}
+	}
+	return nil
+}
+
+// Write the backup as an encrypted, gzip compressed tar archive holding a
+// JSON file per section
+func (b *Backup) Write(w io.Writer, e *BackupEncryption) error {
+	var encrypted io.WriteCloser
+	var err error
+	switch {
+	case len(e.Recipients) > 0:
+		encrypted, err = openpgp.Encrypt(w, e.Recipients, nil, nil, nil)
+	case len(e.Passphrase) > 0:
+		encrypted, err = openpgp.SymmetricallyEncrypt(w, e.Passphrase, nil, nil)
+	default:
+		return errors.New("backups have to be encrypted with a passphrase or for PGP recipients")
+	}
+	if err != nil {
+		return fmt.Errorf("error encrypting backup: %v", err)
+	}
+
+	gz := gzip.NewWriter(encrypted)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range b.files() {
+		data, err := json.MarshalIndent(f.v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding '%s': %v", f.name, err)
+		}
+
+		header := &tar.Header{
+			Name:    f.name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: b.Metadata.Created,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("error writing '%s': %v", f.name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("error writing '%s': %v", f.name, err)
+		}
+	}
+
+	for _, c := range []io.Closer{tw, gz, encrypted} {
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("error writing backup: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Decrypt and read a backup archive
+func ReadBackup(r io.Reader, e *BackupEncryption) (*Backup, error) {
+	// armored messages are accepted, e.g. when encrypted with gpg --armor
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup: %v", err)
+	}
+	var message io.Reader = bytes.NewReader(data)
+	if block, err := armor.Decode(bytes.NewReader(data)); err == nil {
+		message = block.Body
+	}
+
+	tried := false
+	prompt := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if tried || len(e.Passphrase) == 0 {
+			return nil, errors.New("wrong passphrase or missing private key")
+		}
+		tried = true
+
+		if symmetric {
+			return e.Passphrase, nil
+		}
+		for _, key := range keys {
+			if key.PrivateKey != nil && key.PrivateKey.Encrypted {
+				key.PrivateKey.Decrypt(e.Passphrase)
+			}
+		}
+		return nil, nil
+	}
+
+	md, err := openpgp.ReadMessage(message, e.Keys, prompt, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting backup: %v", err)
+	}
+
+	body := &stickyReader{r: md.UnverifiedBody}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing backup: %v", err)
+	}
+
+	b := &Backup{}
+	files := map[string]interface{}{}
+	for _, f := range b.files() {
+		files[f.name] = f.v
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading backup: %v", err)
+		}
+
+		v, ok := files[header.Name]
+		if !ok {
+			return nil, fmt.Errorf("unexpected file '%s' in backup", header.Name)
+		}
+		decoder := json.NewDecoder(tr)
+		decoder.UseNumber()
+		if err := decoder.Decode(v); err != nil {
+			return nil, fmt.Errorf("error decoding '%s': %v", header.Name, err)
+		}
+	}
+
+	// the gzip checksum and the modification detection code of the message
+	// are only checked at their end, so truncated or tampered backups fail
+	if _, err := io.Copy(ioutil.Discard, gz); err != nil {
+		return nil, fmt.Errorf("error decompressing backup: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("error decompressing backup: %v", err)
+	}
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return nil, fmt.Errorf("error verifying backup: %v", err)
+	}
+	if md.IsSigned && md.SignatureError != nil {
+		return nil, fmt.Errorf("error verifying signature of backup: %v", md.SignatureError)
+	}
+
+	if b.Metadata == nil {
+		return nil, errors.New("backup has no metadata")
+	}
+
+	return b, nil
+}
+
+// A reader repeating the error it ended with. The body of a message checks
+// its modification detection code once, reading it again after its end
+// fails.
+type stickyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *stickyReader) Read(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.r.Read(p)
+	s.err = err
+	return n, err
+}
@@ -0,0 +1,204 @@
+package kubernetes
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestBackup_Encryption(t *testing.T) {
+	b := &Backup{
+		Metadata: &BackupMetadata{Version: BackupVersion, ClusterID: "test", Created: time.Now().UTC()},
+		Policies: []*BackupPolicy{&BackupPolicy{Name: "test/worker", Rules: `path "test/secrets/*" {}`}},
+		Secrets:  []*BackupData{&BackupData{Path: "service-accounts", Data: map[string]interface{}{"key": "secret"}}},
+	}
+
+	entity, err := openpgp.NewEntity("backup", "", "backup@example.com", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, c := range map[string]struct {
+		encrypt, decrypt, wrong *BackupEncryption
+	}{
+		"passphrase": {
+			encrypt: &BackupEncryption{Passphrase: []byte("secret")},
+			decrypt: &BackupEncryption{Passphrase: []byte("secret")},
+			wrong:   &BackupEncryption{Passphrase: []byte("wrong")},
+		},
+		"pgp": {
+			encrypt: &BackupEncryption{Recipients: openpgp.EntityList{entity}},
+			decrypt: &BackupEncryption{Keys: openpgp.EntityList{entity}},
+			wrong:   &BackupEncryption{Passphrase: []byte("secret")},
+		},
+	} {
+		var buf bytes.Buffer
+		if err := b.Write(&buf, c.encrypt); err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if bytes.Contains(buf.Bytes(), []byte("service-accounts")) {
+			t.Errorf("%s: backup is not encrypted", name)
+		}
+
+		if _, err := ReadBackup(bytes.NewReader(buf.Bytes()), c.wrong); err == nil {
+			t.Errorf("%s: expected an error decrypting without the right secret", name)
+		}
+
+		read, err := ReadBackup(bytes.NewReader(buf.Bytes()), c.decrypt)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if read.Metadata.ClusterID != "test" || len(read.Policies) != 1 || read.Policies[0].Rules != b.Policies[0].Rules {
+			t.Errorf("%s: unexpected backup read: %+v", name, read)
+		}
+		if len(read.Secrets) != 1 || read.Secrets[0].Data["key"] != "secret" {
+			t.Errorf("%s: unexpected secrets read: %+v", name, read.Secrets)
+		}
+
+		// the end of the message holds the checksums, which have to be
+		// verified although the archive is read completely before
+		dat := buf.Bytes()
+		tampered := append([]byte{}, dat...)
+		tampered[len(tampered)-1] ^= 0x01
+		if _, err := ReadBackup(bytes.NewReader(tampered), c.decrypt); err == nil {
+			t.Errorf("%s: expected an error reading a tampered backup", name)
+		}
+		if _, err := ReadBackup(bytes.NewReader(dat[:len(dat)-10]), c.decrypt); err == nil {
+			t.Errorf("%s: expected an error reading a truncated backup", name)
+		}
+	}
+
+	if err := b.Write(&bytes.Buffer{}, &BackupEncryption{}); err == nil {
+		t.Error("expected an error writing an unencrypted backup")
+	}
+}
+
+func TestBackup_Restore(t *testing.T) {
+	source := vault_dev.New()
+	if err := source.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer source.Stop()
+
+	k := New(source.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	k.CAExported = true
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	ca, err := k.PKI("k8s").readCACert()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	b, err := k.Backup()
+	if err != nil {
+		t.Fatalf("error creating backup: %v", err)
+	}
+	for _, c := range b.CAs {
+		if c.PrivateKey == "" {
+			t.Errorf("expected the private key of exported CA '%s'", c.Path)
+		}
+	}
+	for _, s := range b.Secrets {
+		if filepath.Base(s.Path) == "ca_key_k8s" {
+			t.Error("unexpected CA key in the secrets of the backup")
+		}
+	}
+
+	var buf bytes.Buffer
+	e := &BackupEncryption{Passphrase: []byte("secret")}
+	if err := b.Write(&buf, e); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+	b, err = ReadBackup(&buf, e)
+	if err != nil {
+		t.Fatalf("error reading backup: %v", err)
+	}
+
+	target := vault_dev.New()
+	if err := target.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer target.Stop()
+
+	other := New(target.Client(), logrus.NewEntry(logrus.New()))
+	other.SetClusterID("other")
+	if _, err := other.Restore(b, false); err == nil {
+		t.Error("expected an error restoring the backup of another cluster")
+	}
+
+	restored := New(target.Client(), logrus.NewEntry(logrus.New()))
+	restored.SetClusterID("test")
+	result, err := restored.Restore(b, false)
+	if err != nil {
+		t.Fatalf("error restoring: %v", err)
+	}
+	if len(result.NewCAs) != 0 {
+		t.Errorf("unexpected new CAs: %v", result.NewCAs)
+	}
+
+	restoredCA, err := restored.PKI("k8s").readCACert()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !restoredCA.Equal(ca) {
+		t.Error("expected the restored CA to equal the backed up one")
+	}
+
+	// setup only recreates auth backends and init tokens
+	restored.CAExported = true
+	if err := restored.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	restoredCA, err = restored.PKI("k8s").readCACert()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !restoredCA.Equal(ca) {
+		t.Error("expected setup to keep the restored CA")
+	}
+
+	plan, err := restored.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("unexpected changes after restore:\n%s", plan)
+	}
+
+	// CAs that weren't exported would be replaced by new ones on another vault
+	plain := New(source.Client(), logrus.NewEntry(logrus.New()))
+	plain.SetClusterID("plain")
+	if err := plain.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	b, err = plain.Backup()
+	if err != nil {
+		t.Fatalf("error creating backup: %v", err)
+	}
+
+	restored = New(target.Client(), logrus.NewEntry(logrus.New()))
+	restored.SetClusterID("plain")
+	if _, err := restored.Restore(b, false); err == nil || !strings.Contains(err.Error(), FlagRestoreAllowNewCA) {
+		t.Errorf("expected an error restoring CAs that weren't exported, got: %v", err)
+	}
+	if mount, err := GetMountByPath(restored.vaultClient, "plain/pki/k8s"); err != nil || mount != nil {
+		t.Errorf("expected the failed restore to write nothing, got mount=%v err=%v", mount, err)
+	}
+
+	result, err = restored.Restore(b, true)
+	if err != nil {
+		t.Fatalf("error restoring: %v", err)
+	}
+	if exp, act := len(b.CAs), len(result.NewCAs); exp != act || exp == 0 {
+		t.Errorf("unexpected number of new CAs, exp=%d got=%d", exp, act)
+	}
+}
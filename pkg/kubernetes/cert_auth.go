@@ -0,0 +1,217 @@
+package kubernetes
+
+import (
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// The PKI whose client certificates nodes log in with
+const certAuthPKI = "k8s"
+
+// Names of the kubernetes components running on masters, as in the common
+// names of their certificates
+var masterComponents = []string{"kube-controller-manager", "kube-scheduler"}
+
+// The default cert auth roles: kubelets log in as workers, the controller
+// manager and the scheduler as masters
+func (k *Kubernetes) defaultSpecCertAuth() []*SpecCertAuth {
+	var masterNames []string
+	for _, name := range masterComponents {
+		masterNames = append(masterNames, name, "system:"+name)
+	}
+
+	return []*SpecCertAuth{
+		&SpecCertAuth{
+			Role:         "master",
+			AllowedNames: masterNames,
+			Policies:     []string{"master", "worker"},
+		},
+		&SpecCertAuth{
+			Role:         "worker",
+			AllowedNames: []string{"system:node:*"},
+			Policies:     []string{"worker"},
+		},
+	}
+}
+
+// Path of the cert auth backend of the cluster, below auth/
+func (k *Kubernetes) certAuthPath() string {
+	return filepath.Join(k.clusterID, "cert")
+}
+
+func (k *Kubernetes) certAuthRolePath(role string) string {
+	return filepath.Join("auth", k.certAuthPath(), "certs", role)
+}
+
+// Data of a cert auth role, trusting the given CAs
+func (k *Kubernetes) certAuthRoleData(s *SpecCertAuth, caPEM string) map[string]interface{} {
+	// vault adds the default policy to every role
+	policies := []string{"default"}
+	for _, policy := range s.Policies {
+		policies = append(policies, k.policyName(policy))
+	}
+
+	data := map[string]interface{}{
+		"display_name":  s.Role,
+		"policies":      strings.Join(policies, ","),
+		"allowed_names": strings.Join(s.AllowedNames, ","),
+		"ttl":           fmt.Sprintf("%ds", int(k.MaxValidityComponents.Seconds())),
+	}
+	if caPEM != "" {
+		data["certificate"] = caPEM
+	}
+
+	return data
+}
+
+// The CAs of all mounted generations of the cert auth PKI, so nodes with a
+// certificate of the previous CA can log in during a rotation
+func (k *Kubernetes) certAuthCAs() (string, error) {
+	p := k.PKI(certAuthPKI)
+	if err := p.loadGenerations(); err != nil {
+		return "", err
+	}
+
+	var certs []*x509.Certificate
+	for _, path := range p.generationPaths() {
+		s, err := k.vaultClient.Logical().Read(filepath.Join(path, "cert", "ca"))
+		if err != nil {
+			return "", fmt.Errorf("error reading ca path '%s': %v", path, err)
+		}
+		// intermediate CAs waiting to be signed have no certificate yet
+		certPEM, err := secretString(s, "certificate")
+		if err != nil || certPEM == "" {
+			continue
+		}
+
+		cert, err := parseCert(certPEM)
+		if err != nil {
+			return "", fmt.Errorf("error parsing CA of '%s': %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	return encodeCerts(certs...), nil
+}
+
+func (k *Kubernetes) certAuthEnabled() (bool, error) {
+	auths, err := k.vaultClient.Sys().ListAuth()
+	if err != nil {
+		return false, fmt.Errorf("error listing auth backends: %v", err)
+	}
+
+	_, ok := auths[k.certAuthPath()+"/"]
+	return ok, nil
+}
+
+func (k *Kubernetes) ensureCertAuth() error {
+	roles := k.Spec().CertAuth
+	if len(roles) == 0 {
+		return nil
+	}
+
+	caPEM, err := k.certAuthCAs()
+	if err != nil {
+		return err
+	}
+	if caPEM == "" {
+		k.Log.Warnf("No CA of '%s' found, skipping cert auth", k.PKI(certAuthPKI).Path())
+		return nil
+	}
+
+	enabled, err := k.certAuthEnabled()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		if err := k.vaultClient.Sys().EnableAuth(k.certAuthPath(), "cert", "Kubernetes "+k.clusterID+" node certificates"); err != nil {
+			return fmt.Errorf("error enabling cert auth: %v", err)
+		}
+		k.Log.Infof("Enabled cert auth at 'auth/%s'", k.certAuthPath())
+	}
+
+	var result error
+	for _, s := range roles {
+		path := k.certAuthRolePath(s.Role)
+		if _, err := k.vaultClient.Logical().Write(path, k.certAuthRoleData(s, caPEM)); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error writing cert auth role '%s': %v", path, err))
+		}
+	}
+	return result
+}
+
+func (k *Kubernetes) planCertAuthRole(s *SpecCertAuth, caPEM string) (*Change, error) {
+	path := k.certAuthRolePath(s.Role)
+	current, err := k.vaultClient.Logical().Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cert auth role '%s': %v", path, err)
+	}
+
+	desired := k.certAuthRoleData(s, caPEM)
+	// vault before 0.8 doesn't know allowed names
+	if current != nil {
+		if _, ok := current.Data["allowed_names"]; !ok {
+			delete(desired, "allowed_names")
+		}
+	}
+
+	return planData(KindCertAuth, path, desired, current), nil
+}
+
+func (k *Kubernetes) planCertAuth() ([]*Change, error) {
+	roles := k.Spec().CertAuth
+	if len(roles) == 0 {
+		return nil, nil
+	}
+
+	caPEM, err := k.certAuthCAs()
+	if err != nil {
+		return nil, err
+	}
+	// setup skips cert auth until the intermediate CA has been signed
+	if caPEM == "" && k.specCA(certAuthPKI).Mode == CAModeIntermediate {
+		return nil, nil
+	}
+
+	enabled, err := k.certAuthEnabled()
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &Change{Kind: KindAuth, Path: filepath.Join("auth", k.certAuthPath()), Action: ActionNoop}
+	if !enabled {
+		auth.Action = ActionCreate
+	}
+	changes := []*Change{auth}
+
+	for _, s := range roles {
+		change := &Change{Kind: KindCertAuth, Path: k.certAuthRolePath(s.Role), Action: ActionCreate}
+		if enabled {
+			if change, err = k.planCertAuthRole(s, caPEM); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// Disable the cert auth backend of the cluster, which removes its roles
+func (k *Kubernetes) disableCertAuth() error {
+	enabled, err := k.certAuthEnabled()
+	if err != nil || !enabled {
+		return err
+	}
+
+	if err := k.vaultClient.Sys().DisableAuth(k.certAuthPath()); err != nil {
+		return fmt.Errorf("error disabling cert auth '%s': %v", k.certAuthPath(), err)
+	}
+	k.Log.Debugf("Disabled cert auth '%s'", k.certAuthPath())
+
+	return nil
+}
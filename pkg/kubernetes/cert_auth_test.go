@@ -0,0 +1,85 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func countCertAuthCAs(t *testing.T, k *Kubernetes, role string) int {
+	s, err := k.vaultClient.Logical().Read(k.certAuthRolePath(role))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s == nil {
+		t.Fatalf("cert auth role '%s' not found", role)
+	}
+
+	certPEM, err := secretString(s, "certificate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return strings.Count(certPEM, "BEGIN CERTIFICATE")
+}
+
+func TestCertAuth(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	enabled, err := k.certAuthEnabled()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !enabled {
+		t.Fatal("expected cert auth to be enabled")
+	}
+
+	s, err := vault.Client().Logical().Read("auth/test/cert/certs/master")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s == nil {
+		t.Fatal("expected cert auth role 'master'")
+	}
+	if !equalValues("default,test/master,test/worker", s.Data["policies"]) {
+		t.Errorf("unexpected policies of role 'master': %v", s.Data["policies"])
+	}
+	if n := countCertAuthCAs(t, k, "worker"); n != 1 {
+		t.Errorf("expected 1 trusted CA, got %d", n)
+	}
+
+	// nodes holding a certificate of the old CA can still log in
+	if err := k.RotatePKI("k8s"); err != nil {
+		t.Fatalf("error rotating: %v", err)
+	}
+	if n := countCertAuthCAs(t, k, "worker"); n != 2 {
+		t.Errorf("expected 2 trusted CAs after rotating, got %d", n)
+	}
+	if err := k.RetirePKI("k8s"); err != nil {
+		t.Fatalf("error retiring: %v", err)
+	}
+	if n := countCertAuthCAs(t, k, "worker"); n != 1 {
+		t.Errorf("expected 1 trusted CA after retiring, got %d", n)
+	}
+
+	if err := k.Teardown(TeardownOptions{}); err != nil {
+		t.Fatalf("error tearing down: %v", err)
+	}
+	if enabled, err := k.certAuthEnabled(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if enabled {
+		t.Error("expected cert auth to be disabled")
+	}
+}
@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagSpecDir = "spec-dir"
+const FlagParallel = "parallel"
+
+// The outcome of converging a single cluster
+type EnsureResult struct {
+	ClusterID  string
+	InitTokens map[string]string
+	RoleIDs    map[string]string
+	Err        error
+}
+
+// Converge all clusters, running at most parallel of them at the same time.
+// The results are in the order of the clusters.
+func EnsureAll(clusters []*Kubernetes, parallel int) []*EnsureResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]*EnsureResult, len(clusters))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < len(clusters); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pos := range jobs {
+				k := clusters[pos]
+				result := &EnsureResult{ClusterID: k.clusterID}
+				if result.Err = k.Ensure(); result.Err == nil {
+					result.InitTokens = k.InitTokens()
+					if k.Bootstrap == BootstrapAppRole {
+						result.RoleIDs, result.Err = k.AppRoleIDs()
+					}
+				}
+				results[pos] = result
+			}
+		}()
+	}
+
+	for pos := range clusters {
+		jobs <- pos
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// Summarise the results, one line per cluster followed by its errors
+func EnsureSummary(results []*EnsureResult) string {
+	var buf bytes.Buffer
+	failed := 0
+
+	for _, r := range results {
+		if r.Err == nil {
+			fmt.Fprintf(&buf, "  ok      %s\n", r.ClusterID)
+			continue
+		}
+
+		failed++
+		errs := []error{r.Err}
+		if merr, ok := r.Err.(*multierror.Error); ok {
+			errs = merr.Errors
+		}
+		fmt.Fprintf(&buf, "  failed  %s (%d errors)\n", r.ClusterID, len(errs))
+		for _, err := range errs {
+			fmt.Fprintf(&buf, "          - %v\n", err)
+		}
+	}
+	fmt.Fprintf(&buf, "%d clusters succeeded, %d failed.\n", len(results)-failed, failed)
+
+	return buf.String()
+}
@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestEnsureAll(t *testing.T) {
+	dev := vault_dev.New()
+	if err := dev.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer dev.Stop()
+
+	shared := NewCachedVault(dev.Client())
+	var clusters []*Kubernetes
+	for _, clusterID := range []string{"test-1", "test-2", "test-3", "-invalid"} {
+		k := NewWithVault(shared, logrus.NewEntry(logrus.New()).WithField("cluster", clusterID))
+		k.SetClusterID(clusterID)
+		// the clusters share the AppRole auth backend
+		k.Bootstrap = BootstrapAppRole
+		clusters = append(clusters, k)
+	}
+
+	results := EnsureAll(clusters, 3)
+	if exp, act := len(clusters), len(results); exp != act {
+		t.Fatalf("unexpected number of results, exp=%d got=%d", exp, act)
+	}
+
+	for pos, r := range results[:3] {
+		if r.ClusterID != clusters[pos].ClusterID() {
+			t.Errorf("unexpected cluster of result %d, exp=%s got=%s", pos, clusters[pos].ClusterID(), r.ClusterID)
+		}
+		if r.Err != nil {
+			t.Errorf("unexpected error ensuring '%s': %v", r.ClusterID, r.Err)
+		}
+		if len(r.RoleIDs) == 0 {
+			t.Errorf("expected AppRole IDs of '%s'", r.ClusterID)
+		}
+
+		for _, path := range []string{"pki/k8s", "pki/etcd-k8s", "secrets"} {
+			if mount, err := GetMountByPath(shared, r.ClusterID+"/"+path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			} else if mount == nil {
+				t.Errorf("expected mount '%s/%s'", r.ClusterID, path)
+			}
+		}
+	}
+	if results[3].Err == nil {
+		t.Error("expected an error ensuring an invalid cluster")
+	}
+
+	summary := EnsureSummary(results)
+	for _, line := range []string{"ok      test-2", "failed  -invalid (1 errors)", "3 clusters succeeded, 1 failed."} {
+		if !strings.Contains(summary, line) {
+			t.Errorf("expected '%s' in summary:\n%s", line, summary)
+		}
+	}
+}
+
+func TestCachedVault_ListMounts(t *testing.T) {
+	fake := NewFakeVault(t)
+	defer fake.Finish()
+
+	c := &cachedVaultSys{VaultSys: fake.fakeSys}
+
+	gomock.InOrder(
+		fake.fakeSys.EXPECT().ListMounts().Times(1).Return(map[string]*vault.MountOutput{"a/": {Type: "pki"}}, nil),
+		fake.fakeSys.EXPECT().Mount("b", gomock.Any()).Times(1).Return(nil),
+		fake.fakeSys.EXPECT().ListMounts().Times(1).Return(map[string]*vault.MountOutput{"a/": {Type: "pki"}, "b/": {Type: "pki"}}, nil),
+	)
+
+	for i := 0; i < 3; i++ {
+		mounts, err := c.ListMounts()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp, act := 1, len(mounts); exp != act {
+			t.Errorf("unexpected number of mounts, exp=%d got=%d", exp, act)
+		}
+		// changing the copy must not change the cache
+		delete(mounts, "a/")
+	}
+
+	if err := c.Mount("b", &vault.MountInput{Type: "pki"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mounts, err := c.ListMounts()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := 2, len(mounts); exp != act {
+		t.Errorf("unexpected number of mounts after mounting, exp=%d got=%d", exp, act)
+	}
+}
+
+func TestCachedVault_EnableAuth(t *testing.T) {
+	fake := NewFakeVault(t)
+	defer fake.Finish()
+
+	c := &cachedVaultSys{VaultSys: fake.fakeSys}
+
+	gomock.InOrder(
+		fake.fakeSys.EXPECT().ListAuth().Times(1).Return(map[string]*vault.AuthMount{"token/": {Type: "token"}}, nil),
+		fake.fakeSys.EXPECT().EnableAuth("approle", "approle", gomock.Any()).Times(1).Return(nil),
+		fake.fakeSys.EXPECT().ListAuth().Times(1).Return(map[string]*vault.AuthMount{"token/": {Type: "token"}, "approle/": {Type: "approle"}}, nil),
+	)
+
+	// clusters check the backend before any of them enabled it
+	for i := 0; i < 2; i++ {
+		auths, err := c.ListAuth()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := auths["approle/"]; ok {
+			t.Fatal("unexpected AppRole auth")
+		}
+	}
+
+	// only the first one enables it
+	for i := 0; i < 2; i++ {
+		if err := c.EnableAuth("approle", "approle", "AppRoles of kubernetes nodes"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	auths, err := c.ListAuth()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := auths["approle/"]; !ok {
+		t.Error("expected AppRole auth after enabling it")
+	}
+}
@@ -1,16 +1,12 @@
 package kubernetes
 
 import (
-	"bufio"
-	"bytes"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"path/filepath"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/go-multierror"
 	vault "github.com/hashicorp/vault/api"
 )
 
@@ -18,6 +14,11 @@ type Generic struct {
 	kubernetes *Kubernetes
 	initTokens map[string]string
 
+	// KV version of the mount, 0 until detected
+	kvVersion int
+	// Versions of read KV version 2 secrets, used to check-and-set
+	versions map[string]int
+
 	Log *logrus.Entry
 }
 
@@ -31,81 +32,17 @@ func (g *Generic) Path() string {
 }
 
 func (g *Generic) GenerateSecretsMount() error {
-	mount, err := GetMountByPath(g.kubernetes.vaultClient, g.Path())
-	if err != nil {
+	if err := g.ensureMount(); err != nil {
 		return err
 	}
 
-	if mount == nil {
-		g.Log.Debugf("No secrects mount found for: %s", g.Path())
-		err = g.kubernetes.vaultClient.Sys().Mount(
-			g.Path(),
-			&vault.MountInput{
-				Description: "Kubernetes " + g.kubernetes.clusterID + " secrets",
-				Type:        "generic",
-			},
-		)
-
-		if err != nil {
-			return fmt.Errorf("error creating mount: %v", err)
-		}
-
-		g.Log.Infof("Mounted secrets: '%s'", g.Path())
-	}
-
-	rsaKeyPath := filepath.Join(g.Path(), "service-accounts")
-	if secret, err := g.kubernetes.vaultClient.Logical().Read(rsaKeyPath); err != nil {
-		return fmt.Errorf("error checking for secret %s: %v", rsaKeyPath, err)
-	} else if secret == nil {
-		err = g.writeNewRSAKey(rsaKeyPath, 4096)
-		if err != nil {
-			return fmt.Errorf("error creating rsa key at %s: %v", rsaKeyPath, err)
-		}
-	}
-
-	return nil
-}
-
-func (g *Generic) writeNewRSAKey(secretPath string, bitSize int) error {
-	reader := rand.Reader
-	key, err := rsa.GenerateKey(reader, bitSize)
-	if err != nil {
-		return fmt.Errorf("error generating rsa key: %v", err)
-	}
-
-	var privateKey = &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
-	}
-
-	var buf bytes.Buffer
-	writer := bufio.NewWriter(&buf)
-	err = pem.Encode(writer, privateKey)
-	if err != nil {
-		return fmt.Errorf("error encoding rsa key in PEM: %v", err)
-	}
-	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("error flushing buffer: %v", err)
-	}
-
-	writeData := map[string]interface{}{
-		"key": buf.String(),
-	}
-
-	_, err = g.kubernetes.vaultClient.Logical().Write(secretPath, writeData)
-	if err != nil {
-		return fmt.Errorf("error writting key to secrets: %v", err)
-	}
-
-	g.Log.Infof("Key written to secrets '%s'", secretPath)
-
-	return nil
+	return g.ensureServiceAccountKeys()
 }
 
 func (g *Generic) InitToken(name, role string, policies []string, expectedToken string) (string, error) {
 	path := g.initTokenPath(role)
 
-	if secret, err := g.kubernetes.vaultClient.Logical().Read(path); err != nil {
+	if secret, err := g.readSecret(g.initTokenKey(role)); err != nil {
 		return "", fmt.Errorf("error checking for secret %s: %v", path, err)
 	} else if secret != nil {
 		key := "init_token"
@@ -119,10 +56,23 @@ func (g *Generic) InitToken(name, role string, policies []string, expectedToken
 			return "", fmt.Errorf("error secret %s key '%s' has wrong type: %T", path, key, token)
 		}
 
-		return tokenStr, nil
+		valid, err := g.validToken(tokenStr)
+		if err != nil {
+			return "", err
+		}
+		if valid {
+			return tokenStr, nil
+		}
+		g.Log.Warnf("Stored init token '%s' is not valid anymore, creating a new one", role)
 	}
 
-	// we have to create a new token
+	return g.createInitToken(name, role, policies, expectedToken)
+}
+
+// Create a new init token and store it, replacing the stored one
+func (g *Generic) createInitToken(name, role string, policies []string, expectedToken string) (string, error) {
+	path := g.initTokenPath(role)
+
 	tokenRequest := &vault.TokenCreateRequest{
 		ID:          expectedToken,
 		DisplayName: name,
@@ -144,14 +94,35 @@ func (g *Generic) InitToken(name, role string, policies []string, expectedToken
 	return token.Auth.ClientToken, nil
 }
 
+// Check a token with a lookup, tokens vault doesn't know are invalid
+func (g *Generic) validToken(token string) (bool, error) {
+	s, err := g.kubernetes.vaultClient.Auth().Token().Lookup(token)
+	if err != nil {
+		if isBadToken(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("error looking up init token: %v", err)
+	}
+
+	return s != nil, nil
+}
+
+func isBadToken(err error) bool {
+	return strings.Contains(err.Error(), "bad token")
+}
+
+func (g *Generic) initTokenKey(role string) string {
+	return fmt.Sprintf("init_token_%s", role)
+}
+
 func (g *Generic) initTokenPath(role string) string {
-	return filepath.Join(g.Path(), fmt.Sprintf("init_token_%s", role))
+	return filepath.Join(g.Path(), g.initTokenKey(role))
 }
 
 func (g *Generic) InitTokenStore(role string) (token string, err error) {
 	path := g.initTokenPath(role)
 
-	s, err := g.kubernetes.vaultClient.Logical().Read(path)
+	s, err := g.readSecret(g.initTokenKey(role))
 	if err != nil {
 		return "", fmt.Errorf("failed to read init token: %v", err)
 	}
@@ -188,12 +159,207 @@ func (g *Generic) SetInitTokenStore(role string, token string) error {
 	data := map[string]interface{}{
 		"init_token": token,
 	}
-	_, err := g.kubernetes.vaultClient.Logical().Write(path, data)
-	if err != nil {
-		return fmt.Errorf("error writting init token at path:  %v", path)
+	if err := g.writeSecretCAS(g.initTokenKey(role), data); err != nil {
+		return fmt.Errorf("error writting init token at path %s: %v", path, err)
 	}
 
 	g.Log.Infof("Init token written for '%s' at '%s'", role, path)
 
 	return nil
 }
+
+// Revoke all stored init tokens and remove them from the store
+func (g *Generic) revokeInitTokens() error {
+	var result error
+
+	keys, err := g.listSecrets()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "init_token_") {
+			continue
+		}
+		role := strings.TrimPrefix(key, "init_token_")
+		path := g.initTokenPath(role)
+
+		token, err := g.InitTokenStore(role)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+
+		if token != "" {
+			if err := g.revokeToken(token, path, role); err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+		}
+
+		if err := g.deleteSecret(g.initTokenKey(role)); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting init token at '%s': %v", path, err))
+		}
+	}
+
+	return result
+}
+
+func (g *Generic) csrKey(pkiName string) string {
+	return fmt.Sprintf("ca_csr_%s", pkiName)
+}
+
+func (g *Generic) csrPath(pkiName string) string {
+	return filepath.Join(g.Path(), g.csrKey(pkiName))
+}
+
+// Get the stored CSR of a pending intermediate CA, empty if there is none
+func (g *Generic) CSRStore(pkiName string) (string, error) {
+	path := g.csrPath(pkiName)
+
+	s, err := g.readSecret(g.csrKey(pkiName))
+	if err != nil {
+		return "", fmt.Errorf("failed to read CSR: %v", err)
+	}
+	if s == nil {
+		return "", nil
+	}
+
+	csr, err := secretString(s, "csr")
+	if err != nil {
+		return "", fmt.Errorf("failed to read CSR at '%s': %v", path, err)
+	}
+
+	return csr, nil
+}
+
+func (g *Generic) SetCSRStore(pkiName, csr string) error {
+	path := g.csrPath(pkiName)
+
+	data := map[string]interface{}{
+		"csr": csr,
+	}
+	if err := g.writeSecret(g.csrKey(pkiName), data); err != nil {
+		return fmt.Errorf("error writting CSR at path %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func (g *Generic) deleteCSRStore(pkiName string) error {
+	path := g.csrPath(pkiName)
+
+	if err := g.deleteSecret(g.csrKey(pkiName)); err != nil {
+		return fmt.Errorf("error deleting CSR at '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+func (g *Generic) caBundleKey(pkiName string) string {
+	return fmt.Sprintf("ca_bundle_%s", pkiName)
+}
+
+func (g *Generic) caBundlePath(pkiName string) string {
+	return filepath.Join(g.Path(), g.caBundleKey(pkiName))
+}
+
+// Get the trust bundle of a PKI with more than one CA generation, empty if
+// there is none
+func (g *Generic) CABundleStore(pkiName string) (string, error) {
+	path := g.caBundlePath(pkiName)
+
+	s, err := g.readSecret(g.caBundleKey(pkiName))
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA bundle: %v", err)
+	}
+	if s == nil {
+		return "", nil
+	}
+
+	bundle, err := secretString(s, "certificate")
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA bundle at '%s': %v", path, err)
+	}
+
+	return bundle, nil
+}
+
+func (g *Generic) SetCABundleStore(pkiName, bundle string) error {
+	path := g.caBundlePath(pkiName)
+
+	data := map[string]interface{}{
+		"certificate": bundle,
+	}
+	if err := g.writeSecret(g.caBundleKey(pkiName), data); err != nil {
+		return fmt.Errorf("error writting CA bundle at path %s: %v", path, err)
+	}
+
+	g.Log.Infof("CA bundle written for '%s' at '%s'", pkiName, path)
+
+	return nil
+}
+
+func (g *Generic) deleteCABundleStore(pkiName string) error {
+	path := g.caBundlePath(pkiName)
+
+	if err := g.deleteSecret(g.caBundleKey(pkiName)); err != nil {
+		return fmt.Errorf("error deleting CA bundle at '%s': %v", path, err)
+	}
+
+	return nil
+}
+
+// CA keys are stored per generation, keyed by the name of the PKI mount
+func (g *Generic) caKeyKey(mountName string) string {
+	return fmt.Sprintf("ca_key_%s", mountName)
+}
+
+func (g *Generic) caKeyPath(mountName string) string {
+	return filepath.Join(g.Path(), g.caKeyKey(mountName))
+}
+
+// Get the escrowed private key of an exported CA, empty if there is none
+func (g *Generic) CAKeyStore(mountName string) (string, error) {
+	path := g.caKeyPath(mountName)
+
+	s, err := g.readSecret(g.caKeyKey(mountName))
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA key: %v", err)
+	}
+	if s == nil {
+		return "", nil
+	}
+
+	key, err := secretString(s, "private_key")
+	if err != nil {
+		return "", fmt.Errorf("failed to read CA key at '%s': %v", path, err)
+	}
+
+	return key, nil
+}
+
+func (g *Generic) SetCAKeyStore(mountName, key string) error {
+	path := g.caKeyPath(mountName)
+
+	data := map[string]interface{}{
+		"private_key": key,
+	}
+	if err := g.writeSecret(g.caKeyKey(mountName), data); err != nil {
+		return fmt.Errorf("error writting CA key at path %s: %v", path, err)
+	}
+
+	g.Log.Infof("CA key escrowed for '%s' at '%s'", mountName, path)
+
+	return nil
+}
+
+func (g *Generic) deleteCAKeyStore(mountName string) error {
+	path := g.caKeyPath(mountName)
+
+	if err := g.deleteSecret(g.caKeyKey(mountName)); err != nil {
+		return fmt.Errorf("error deleting CA key at '%s': %v", path, err)
+	}
+
+	return nil
+}
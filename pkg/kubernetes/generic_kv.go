@@ -0,0 +1,291 @@
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/kv"
+)
+
+const FlagSecretsKVVersion = "secrets-kv-version"
+
+// Mount the secrets, migrating a version 1 mount to version 2 if asked to
+func (g *Generic) ensureMount() error {
+	desired := g.kubernetes.SecretsKVVersion
+	if desired < 0 || desired > 2 {
+		return fmt.Errorf("unsupported KV version %d of secrets mount, expected 1 or 2", desired)
+	}
+
+	mount, err := GetMountByPath(g.kubernetes.vaultClient, g.Path())
+	if err != nil {
+		return err
+	}
+	backup, err := GetMountByPath(g.kubernetes.vaultClient, g.backupPath())
+	if err != nil {
+		return err
+	}
+
+	if mount == nil {
+		g.Log.Debugf("No secrects mount found for: %s", g.Path())
+
+		version := desired
+		if backup != nil {
+			// a migration got interrupted after moving the old mount
+			version = 2
+		} else if version == 0 {
+			version = 1
+		}
+		if err := g.mount(version); err != nil {
+			return err
+		}
+	}
+
+	version, err := g.KVVersion()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case desired == 2 && version == 1:
+		return g.migrate()
+	case desired == 1 && version == 2:
+		return fmt.Errorf("secrets mount '%s' is KV version 2, downgrading to version 1 is not supported", g.Path())
+	case backup != nil && version == 2:
+		return g.migrate()
+	}
+
+	return nil
+}
+
+func (g *Generic) mount(version int) error {
+	description := "Kubernetes " + g.kubernetes.clusterID + " secrets"
+
+	var err error
+	if version == 2 {
+		// the mount input of the client has no options
+		_, err = g.kubernetes.vaultClient.Logical().Write(filepath.Join("sys/mounts", g.Path()), map[string]interface{}{
+			"type":        "kv",
+			"description": description,
+			"options":     map[string]interface{}{"version": "2"},
+		})
+	} else {
+		err = g.kubernetes.vaultClient.Sys().Mount(
+			g.Path(),
+			&vault.MountInput{
+				Description: description,
+				Type:        "generic",
+			},
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating mount: %v", err)
+	}
+
+	g.kvVersion = version
+	g.Log.Infof("Mounted secrets: '%s' (KV version %d)", g.Path(), version)
+
+	return nil
+}
+
+// The old mount is kept here while migrating to KV version 2
+func (g *Generic) backupPath() string {
+	return g.Path() + "-v1"
+}
+
+// Move the contents of a version 1 mount into a new version 2 mount. The old
+// mount is kept until everything has been copied, so a failed migration
+// continues with the next setup.
+func (g *Generic) migrate() error {
+	version, err := g.KVVersion()
+	if err != nil {
+		return err
+	}
+
+	if version == 1 {
+		if err := g.kubernetes.vaultClient.Sys().Remount(g.Path(), g.backupPath()); err != nil {
+			return fmt.Errorf("error moving secrets mount '%s' to '%s': %v", g.Path(), g.backupPath(), err)
+		}
+		g.kvVersion = 0
+		if err := g.mount(2); err != nil {
+			return err
+		}
+	}
+
+	keys, err := listKeysRecursive(g.kubernetes.vaultClient, g.backupPath())
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, key := range keys {
+		path := filepath.Join(g.backupPath(), key)
+		s, err := g.kubernetes.vaultClient.Logical().Read(path)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error reading secret '%s': %v", path, err))
+			continue
+		}
+		if s == nil {
+			continue
+		}
+
+		// skip secrets copied by an earlier attempt
+		if existing, err := g.readSecret(key); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error reading secret '%s': %v", key, err))
+			continue
+		} else if existing != nil {
+			continue
+		}
+
+		if err := g.writeSecretCAS(key, s.Data); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error copying secret '%s': %v", key, err))
+		}
+	}
+	if result != nil {
+		return fmt.Errorf("error migrating secrets, the old mount is kept at '%s': %v", g.backupPath(), result)
+	}
+
+	if err := g.kubernetes.vaultClient.Sys().Unmount(g.backupPath()); err != nil {
+		return fmt.Errorf("error unmounting '%s': %v", g.backupPath(), err)
+	}
+	g.Log.Infof("Migrated %d secrets of '%s' to KV version 2", len(keys), g.Path())
+
+	return nil
+}
+
+// KV version of the secrets mount, detected on first use
+func (g *Generic) KVVersion() (int, error) {
+	if g.kvVersion != 0 {
+		return g.kvVersion, nil
+	}
+
+	mount, err := GetMountByPath(g.kubernetes.vaultClient, g.Path())
+	if err != nil {
+		return 0, err
+	}
+	if mount == nil || mount.Type == "generic" {
+		return 1, nil
+	}
+
+	_, version, err := kv.MountVersion(g.kubernetes.vaultClient.Logical(), g.Path())
+	if err != nil {
+		return 0, err
+	}
+	g.kvVersion = version
+
+	return version, nil
+}
+
+// The path policies grant access to a secret with, once setup converged the
+// mount
+func (g *Generic) policyPath(key string) string {
+	version := g.kubernetes.SecretsKVVersion
+	if version == 0 {
+		version = g.kvVersion
+	}
+	return kv.DataPath(g.Path(), version, key)
+}
+
+// Read a secret, nil if it doesn't exist. The version of KV version 2 secrets
+// is remembered for check-and-set writes.
+func (g *Generic) readSecret(key string) (*vault.Secret, error) {
+	version, err := g.KVVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := g.kubernetes.vaultClient.Logical().Read(kv.DataPath(g.Path(), version, key))
+	if err != nil || version != 2 {
+		return s, err
+	}
+
+	g.versions[key] = kv.Version(s)
+	return kv.Unwrap(s), nil
+}
+
+func (g *Generic) writeSecret(key string, data map[string]interface{}) error {
+	return g.write(key, data, false)
+}
+
+// Write a secret, which in KV version 2 mounts fails if it changed since it
+// has been read
+func (g *Generic) writeSecretCAS(key string, data map[string]interface{}) error {
+	return g.write(key, data, true)
+}
+
+func (g *Generic) write(key string, data map[string]interface{}, cas bool) error {
+	version, err := g.KVVersion()
+	if err != nil {
+		return err
+	}
+
+	if version == 2 {
+		body := map[string]interface{}{"data": data}
+		if cas {
+			body["options"] = map[string]interface{}{"cas": g.versions[key]}
+		}
+		data = body
+	}
+
+	s, err := g.kubernetes.vaultClient.Logical().Write(kv.DataPath(g.Path(), version, key), data)
+	if err != nil {
+		return err
+	}
+	if version == 2 {
+		g.versions[key] = kv.Version(s)
+	}
+
+	return nil
+}
+
+// Delete a secret, including all its versions
+func (g *Generic) deleteSecret(key string) error {
+	version, err := g.KVVersion()
+	if err != nil {
+		return err
+	}
+
+	if _, err := g.kubernetes.vaultClient.Logical().Delete(kv.MetadataPath(g.Path(), version, key)); err != nil {
+		return err
+	}
+	delete(g.versions, key)
+
+	return nil
+}
+
+func (g *Generic) listSecrets() ([]string, error) {
+	version, err := g.KVVersion()
+	if err != nil {
+		return nil, err
+	}
+	return listKeys(g.kubernetes.vaultClient, kv.MetadataPath(g.Path(), version, ""))
+}
+
+// List the keys below a path, including the ones of sub directories
+func listKeysRecursive(vaultClient Vault, path string) ([]string, error) {
+	keys, err := listKeys(vaultClient, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			result = append(result, key)
+			continue
+		}
+
+		sub, err := listKeysRecursive(vaultClient, filepath.Join(path, key))
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range sub {
+			result = append(result, key+s)
+		}
+	}
+
+	return result, nil
+}
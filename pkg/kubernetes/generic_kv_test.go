@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	vault "github.com/hashicorp/vault/api"
+)
+
+func TestGeneric_MigrateKV2(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+
+	k := fv.Kubernetes()
+	k.SecretsKVVersion = 2
+	g := k.secretsGeneric
+
+	fv.fakeSys.EXPECT().ListMounts().AnyTimes().Return(map[string]*vault.MountOutput{
+		"test-cluster-inside/secrets/": &vault.MountOutput{Type: "generic"},
+	}, nil)
+
+	gomock.InOrder(
+		fv.fakeSys.EXPECT().Remount("test-cluster-inside/secrets", "test-cluster-inside/secrets-v1").Return(nil),
+		fv.fakeLogical.EXPECT().Write("sys/mounts/test-cluster-inside/secrets", map[string]interface{}{
+			"type":        "kv",
+			"description": "Kubernetes test-cluster-inside secrets",
+			"options":     map[string]interface{}{"version": "2"},
+		}).Return(nil, nil),
+		fv.fakeLogical.EXPECT().List("test-cluster-inside/secrets-v1").Return(&vault.Secret{Data: map[string]interface{}{
+			"keys": []interface{}{"init_token_etcd", "service-accounts"},
+		}}, nil),
+	)
+
+	// the first secret has been copied by an earlier attempt
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets-v1/init_token_etcd").Return(&vault.Secret{Data: map[string]interface{}{
+		"init_token": "etcd-token",
+	}}, nil)
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/data/init_token_etcd").Return(&vault.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"init_token": "etcd-token"},
+		"metadata": map[string]interface{}{"version": json.Number("1")},
+	}}, nil)
+
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets-v1/service-accounts").Return(&vault.Secret{Data: map[string]interface{}{
+		"key": "my-key",
+	}}, nil)
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/data/service-accounts").Return(nil, nil)
+	fv.fakeLogical.EXPECT().Write("test-cluster-inside/secrets/data/service-accounts", map[string]interface{}{
+		"data":    map[string]interface{}{"key": "my-key"},
+		"options": map[string]interface{}{"cas": 0},
+	}).Return(&vault.Secret{Data: map[string]interface{}{"version": json.Number("1")}}, nil)
+
+	fv.fakeSys.EXPECT().Unmount("test-cluster-inside/secrets-v1").Return(nil)
+
+	if err := g.ensureMount(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, act := "test-cluster-inside/secrets/data/service-accounts", g.policyPath("service-accounts"); exp != act {
+		t.Errorf("unexpected policy path, exp=%s got=%s", exp, act)
+	}
+}
+
+func TestGeneric_InitTokenStoreKV2(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+
+	g := fv.Kubernetes().secretsGeneric
+	g.kvVersion = 2
+
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/data/init_token_worker").Return(&vault.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"init_token": "old-token"},
+		"metadata": map[string]interface{}{"version": json.Number("3")},
+	}}, nil)
+
+	token, err := g.InitTokenStore("worker")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "old-token", token; exp != act {
+		t.Errorf("unexpected token, exp=%s got=%s", exp, act)
+	}
+
+	// the write only succeeds if nobody changed the token since it was read
+	fv.fakeLogical.EXPECT().Write("test-cluster-inside/secrets/data/init_token_worker", map[string]interface{}{
+		"data":    map[string]interface{}{"init_token": "new-token"},
+		"options": map[string]interface{}{"cas": 3},
+	}).Return(&vault.Secret{Data: map[string]interface{}{"version": json.Number("4")}}, nil)
+
+	if err := g.SetInitTokenStore("worker", "new-token"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fv.fakeLogical.EXPECT().List("test-cluster-inside/secrets/metadata").Return(&vault.Secret{Data: map[string]interface{}{
+		"keys": []interface{}{"init_token_worker"},
+	}}, nil)
+	keys, err := g.listSecrets()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "init_token_worker" {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+}
+
+// teardown revokes the init tokens and deletes them with all their versions
+func TestGeneric_RevokeInitTokensKV2(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+
+	g := fv.Kubernetes().secretsGeneric
+	g.kvVersion = 2
+
+	fv.fakeLogical.EXPECT().List("test-cluster-inside/secrets/metadata").Return(&vault.Secret{Data: map[string]interface{}{
+		"keys": []interface{}{"init_token_worker", "service-accounts"},
+	}}, nil)
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/data/init_token_worker").Return(&vault.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"init_token": "worker-token"},
+		"metadata": map[string]interface{}{"version": json.Number("1")},
+	}}, nil)
+	fv.fakeToken.EXPECT().RevokeOrphan("worker-token").Return(nil)
+	fv.fakeLogical.EXPECT().Delete("test-cluster-inside/secrets/metadata/init_token_worker").Return(nil, nil)
+
+	if err := g.revokeInitTokens(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -60,19 +60,22 @@ func (i *InitToken) Path() string {
 	return filepath.Join("auth/token/roles", i.Name())
 }
 
-// Write token role to vault
-func (i *InitToken) writeTokenRole() error {
+// Data of the token role
+func (i *InitToken) tokenRoleData() map[string]interface{} {
 	policies := i.Policies
 	policies = append(policies, "default")
 
-	writeData := map[string]interface{}{
+	return map[string]interface{}{
 		"period":           fmt.Sprintf("%ds", int(i.kubernetes.MaxValidityComponents.Seconds())),
 		"orphan":           true,
 		"allowed_policies": strings.Join(policies, ","),
 		"path_suffix":      i.namePath(),
 	}
+}
 
-	_, err := i.kubernetes.vaultClient.Logical().Write(i.Path(), writeData)
+// Write token role to vault
+func (i *InitToken) writeTokenRole() error {
+	_, err := i.kubernetes.vaultClient.Logical().Write(i.Path(), i.tokenRoleData())
 	if err != nil {
 		return fmt.Errorf("error writing token role %s: %v", i.Path(), err)
 	}
@@ -80,9 +83,9 @@ func (i *InitToken) writeTokenRole() error {
 	return nil
 }
 
-// Construct policy and send to kubernetes to be written to vault
-func (i *InitToken) writeInitTokenPolicy() error {
-	p := &Policy{
+// Construct policy allowing to create tokens of the token role
+func (i *InitToken) initTokenPolicy() *Policy {
+	return &Policy{
 		Name: fmt.Sprintf("%s-creator", i.namePath()),
 		Policies: []*policyPath{
 			&policyPath{
@@ -91,7 +94,11 @@ func (i *InitToken) writeInitTokenPolicy() error {
 			},
 		},
 	}
-	return i.kubernetes.WritePolicy(p)
+}
+
+// Send policy to kubernetes to be written to vault
+func (i *InitToken) writeInitTokenPolicy() error {
+	return i.kubernetes.WritePolicy(i.initTokenPolicy())
 }
 
 // Return init token if token exists
@@ -102,15 +109,74 @@ func (i *InitToken) InitToken() (string, error) {
 	}
 
 	// get init token from generic
-	token, err := i.secretsGeneric().InitToken(i.Name(), i.Role, []string{fmt.Sprintf("%s-creator", i.namePath())}, i.ExpectedToken)
+	token, err := i.secretsGeneric().InitToken(i.Name(), i.Role, []string{i.initTokenPolicy().Name}, i.ExpectedToken)
+	if err != nil {
+		return "", err
+	}
+
+	i.token = &token
+	return token, nil
+}
+
+// Replace the init token with a new one, the old token gets revoked once the
+// new one is stored
+func (i *InitToken) Rotate() (string, error) {
+	old, err := i.secretsGeneric().InitTokenStore(i.Role)
 	if err != nil {
 		return "", err
 	}
 
+	token, err := i.secretsGeneric().createInitToken(i.Name(), i.Role, []string{i.initTokenPolicy().Name}, "")
+	if err != nil {
+		return "", err
+	}
 	i.token = &token
+
+	if old != "" {
+		if err := i.revokeToken(old); err != nil {
+			return token, err
+		}
+	}
+
 	return token, nil
 }
 
+// Revoke the init token and remove it from the store
+func (i *InitToken) Revoke() error {
+	token, err := i.secretsGeneric().InitTokenStore(i.Role)
+	if err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("no init token stored for role '%s'", i.Role)
+	}
+
+	if err := i.revokeToken(token); err != nil {
+		return err
+	}
+	i.token = nil
+
+	if err := i.secretsGeneric().deleteSecret(i.secretsGeneric().initTokenKey(i.Role)); err != nil {
+		return fmt.Errorf("error deleting init token at '%s': %v", i.secretsGeneric().initTokenPath(i.Role), err)
+	}
+
+	return nil
+}
+
+// Revoke a token, unless vault already considers it invalid
+func (i *InitToken) revokeToken(token string) error {
+	valid, err := i.secretsGeneric().validToken(token)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		i.kubernetes.Log.Debugf("Init token '%s' is already invalid", i.Role)
+		return nil
+	}
+
+	return i.secretsGeneric().revokeToken(token, i.secretsGeneric().initTokenPath(i.Role), i.Role)
+}
+
 func (i *InitToken) secretsGeneric() *Generic {
 	return i.kubernetes.secretsGeneric
 }
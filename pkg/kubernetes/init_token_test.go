@@ -1,10 +1,15 @@
 package kubernetes
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/golang/mock/gomock"
 	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
 )
 
 type tokenCreateRequestMatcher struct {
@@ -101,6 +106,9 @@ func TestInitToken_Ensure_NoExpectedToken_AlreadyExisting(t *testing.T) {
 		nil,
 	)
 
+	// expect a lookup of the stored token, which is valid
+	fv.fakeToken.EXPECT().Lookup("existing-token").Return(&vault.Secret{}, nil)
+
 	InitTokenEnsure_EXPECTs(fv)
 
 	err := i.Ensure()
@@ -120,6 +128,58 @@ func TestInitToken_Ensure_NoExpectedToken_AlreadyExisting(t *testing.T) {
 	return
 }
 
+// expected token not set, init token already exists but has been revoked
+func TestInitToken_Ensure_NoExpectedToken_Invalid(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+
+	i := &InitToken{
+		Role:          "etcd",
+		Policies:      []string{"etcd"},
+		kubernetes:    fv.Kubernetes(),
+		ExpectedToken: "",
+	}
+
+	// expect a read and vault says secret is existing
+	genericPath := "test-cluster-inside/secrets/init_token_etcd"
+	fv.fakeLogical.EXPECT().Read(genericPath).Return(
+		&vault.Secret{
+			Data: map[string]interface{}{"init_token": "revoked-token"},
+		},
+		nil,
+	)
+
+	// expect a lookup of the stored token, which vault doesn't know
+	fv.fakeToken.EXPECT().Lookup("revoked-token").Return(nil, errors.New("Code: 403. Errors:\n\n* bad token"))
+
+	// expect a create new orphan and a write of the new token
+	fv.fakeToken.EXPECT().CreateOrphan(&tokenCreateRequestMatcher{}).Return(&vault.Secret{
+		Auth: &vault.SecretAuth{
+			ClientToken: "my-new-random-token",
+		},
+	}, nil)
+	fv.fakeLogical.EXPECT().Write(genericPath, map[string]interface{}{"init_token": "my-new-random-token"}).Return(
+		nil,
+		nil,
+	)
+
+	InitTokenEnsure_EXPECTs(fv)
+
+	err := i.Ensure()
+	if err != nil {
+		t.Error("unexpected error: ", err)
+	}
+
+	token, err := i.InitToken()
+	if err != nil {
+		t.Error("unexpected error: ", err)
+	}
+
+	if exp, act := "my-new-random-token", token; exp != act {
+		t.Errorf("unexpected token: act=%s exp=%s", act, exp)
+	}
+}
+
 // excpected token set, init token already exists and it's matching
 func TestInitToken_Ensure_ExpectedToken_Existing_Match(t *testing.T) {
 	fv := NewFakeVault(t)
@@ -141,6 +201,9 @@ func TestInitToken_Ensure_ExpectedToken_Existing_Match(t *testing.T) {
 		nil,
 	)
 
+	// expect a lookup of the stored token, which is valid
+	fv.fakeToken.EXPECT().Lookup("expected-token").Return(&vault.Secret{}, nil)
+
 	InitTokenEnsure_EXPECTs(fv)
 
 	err := i.Ensure()
@@ -219,8 +282,106 @@ func TestInitToken_Ensure_ExpectedToken_NotExisting(t *testing.T) {
 	return
 }
 
+// revoking an init token removes it from a KV version 2 mount with all its
+// versions
+func TestInitToken_RevokeKV2(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+
+	k := fv.Kubernetes()
+	k.secretsGeneric.kvVersion = 2
+	i := &InitToken{
+		Role:       "etcd",
+		Policies:   []string{"etcd"},
+		kubernetes: k,
+	}
+
+	fv.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/data/init_token_etcd").Return(&vault.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"init_token": "etcd-token"},
+		"metadata": map[string]interface{}{"version": json.Number("2")},
+	}}, nil)
+	fv.fakeToken.EXPECT().Lookup("etcd-token").Return(&vault.Secret{}, nil)
+	fv.fakeToken.EXPECT().RevokeOrphan("etcd-token").Return(nil)
+	fv.fakeLogical.EXPECT().Delete("test-cluster-inside/secrets/metadata/init_token_etcd").Return(nil, nil)
+
+	if err := i.Revoke(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
 // General policy and write calls when init token ensuring
 func InitTokenEnsure_EXPECTs(fv *fakeVault) {
+	fv.fakeSys.EXPECT().ListMounts().AnyTimes().Return(map[string]*vault.MountOutput{
+		"test-cluster-inside/secrets/": &vault.MountOutput{Type: "generic"},
+	}, nil)
 	fv.fakeLogical.EXPECT().Write("auth/token/roles/test-cluster-inside-etcd", gomock.Any()).AnyTimes().Return(nil, nil)
 	fv.fakeSys.EXPECT().PutPolicy(gomock.Any(), gomock.Any()).AnyTimes().Return(nil)
 }
+
+func TestInitToken_RotateRevoke(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	old := k.InitTokens()["worker"]
+
+	token, err := k.RotateInitToken("worker")
+	if err != nil {
+		t.Fatalf("error rotating: %v", err)
+	}
+	if token == old || token == "" {
+		t.Errorf("expected a new init token, got '%s'", token)
+	}
+	if _, err := vault.Client().Auth().Token().Lookup(old); err == nil {
+		t.Error("expected old init token to be revoked")
+	}
+	if stored, err := k.secretsGeneric.InitTokenStore("worker"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if stored != token {
+		t.Errorf("unexpected stored token, exp=%s got=%s", token, stored)
+	}
+
+	if _, err := k.RotateInitToken("unknown"); err == nil {
+		t.Error("expected an error rotating an unknown role")
+	}
+
+	if err := k.RevokeInitToken("worker"); err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	if _, err := vault.Client().Auth().Token().Lookup(token); err == nil {
+		t.Error("expected init token to be revoked")
+	}
+	if stored, err := k.secretsGeneric.InitTokenStore("worker"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if stored != "" {
+		t.Errorf("expected the revoked init token to be removed from the store, got '%s'", stored)
+	}
+
+	// revoke a token behind setup's back, setup has to replace it
+	master := k.InitTokens()["master"]
+	if err := vault.Client().Auth().Token().RevokeOrphan(master); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	k = New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	tokens := k.InitTokens()
+	for role, token := range map[string]string{"worker": token, "master": master} {
+		if tokens[role] == "" || tokens[role] == token {
+			t.Errorf("expected a new init token for '%s'", role)
+		}
+		if _, err := vault.Client().Auth().Token().Lookup(tokens[role]); err != nil {
+			t.Errorf("expected init token for '%s' to be valid: %v", role, err)
+		}
+	}
+}
@@ -17,6 +17,9 @@ const FlagMaxValidityAdmin = "max-validity-admin"
 const FlagMaxValidityCA = "max-validity-ca"
 const FlagMaxValidityComponents = "max-validity-components"
 
+const FlagSpec = "spec"
+const FlagK8sRoles = "k8s-role"
+
 const FlagInitTokenEtcd = "init-token-etcd"
 const FlagInitTokenAll = "init-token-all"
 const FlagInitTokenMaster = "init-token-master"
@@ -24,12 +27,15 @@ const FlagInitTokenWorker = "init-token-worker"
 
 type Backend interface {
 	Ensure() error
+	Plan() ([]*Change, error)
 	Path() string
 }
 
 type VaultLogical interface {
 	Write(path string, data map[string]interface{}) (*vault.Secret, error)
 	Read(path string) (*vault.Secret, error)
+	List(path string) (*vault.Secret, error)
+	Delete(path string) (*vault.Secret, error)
 }
 
 type VaultSys interface {
@@ -37,7 +43,13 @@ type VaultSys interface {
 	ListPolicies() ([]string, error)
 
 	Mount(path string, mountInfo *vault.MountInput) error
+	Unmount(path string) error
+	Remount(from, to string) error
+	ListAuth() (map[string]*vault.AuthMount, error)
+	EnableAuth(path, authType, desc string) error
+	DisableAuth(path string) error
 	PutPolicy(name, rules string) error
+	DeletePolicy(name string) error
 	TuneMount(path string, config vault.MountConfigInput) error
 	GetPolicy(name string) (string, error)
 }
@@ -96,11 +108,56 @@ type Kubernetes struct {
 	// A generic backend for static secrets
 	secretsGeneric *Generic
 
+	// All PKIs by name, including the ones above
+	pkis map[string]*PKI
+
+	// The layout to converge to, if nil the default layout is used
+	spec *Spec
+	// Extra component roles of the kubernetes PKI, added to the spec when
+	// it is built, so the default layout still follows the flags
+	componentRoles []*SpecRole
+
 	MaxValidityAdmin      time.Duration
 	MaxValidityComponents time.Duration
 	MaxValidityCA         time.Duration
 	MaxValidityInitTokens time.Duration
 
+	// CA mode, parent PKI mount and key export of PKIs without a CA in the
+	// spec
+	CAMode     string
+	CAParent   string
+	CAExported bool
+
+	// Base URL of vault written into the issuing and CRL URLs of
+	// certificates and how long CRLs are valid, both unset if empty
+	PKIURL    string
+	CRLExpiry time.Duration
+
+	// Key type and bits of CSRs the PKI roles accept, matching the keys
+	// 'cert' generates. Roles keep their key settings if the type is empty.
+	PKIKeyType string
+	PKIKeyBits int
+
+	// Type of new service account keys and how long the public keys of
+	// rotated ones stay valid. Keeping previous keys ignores the overlap,
+	// as dropping a key invalidates the non-expiring legacy tokens it signed.
+	ServiceAccountKeyType      string
+	ServiceAccountKeyOverlap   time.Duration
+	ServiceAccountKeepPrevious bool
+
+	// KV version of the secrets mount, 0 keeps the version of an existing
+	// mount and creates new mounts as version 1
+	SecretsKVVersion int
+
+	// How nodes get their first token, with init tokens or AppRoles
+	Bootstrap              string
+	AppRoleSecretIDNumUses int
+	AppRoleSecretIDTTL     time.Duration
+
+	// Kubelet certificates are signed with the policies of single nodes
+	// instead of the worker policy
+	NodeIdentity bool
+
 	FlagInitTokens FlagInitTokens
 
 	initTokens []*InitToken
@@ -156,6 +213,15 @@ func isValidClusterID(clusterID string) error {
 }
 
 func New(vaultClient *vault.Client, logger *logrus.Entry) *Kubernetes {
+	var v Vault
+	if vaultClient != nil {
+		v = realVaultFromAPI(vaultClient)
+	}
+	return NewWithVault(v, logger)
+}
+
+// Create a cluster using the given vault, e.g. one shared between clusters
+func NewWithVault(vaultClient Vault, logger *logrus.Entry) *Kubernetes {
 
 	k := &Kubernetes{
 		// set default validity periods
@@ -163,6 +229,14 @@ func New(vaultClient *vault.Client, logger *logrus.Entry) *Kubernetes {
 		MaxValidityComponents: time.Hour * 24 * 30,       // Validity period of Component certificates
 		MaxValidityAdmin:      time.Hour * 24 * 365,      // Validity period of Admin ceritficate
 		MaxValidityInitTokens: time.Hour * 24 * 365 * 5,  // Validity of init tokens
+		CAMode:                CAModeRoot,
+
+		ServiceAccountKeyType:    SAKeyTypeRSA,
+		ServiceAccountKeyOverlap: time.Hour * 24 * 30,
+
+		Bootstrap:              BootstrapInitToken,
+		AppRoleSecretIDNumUses: 1,
+
 		FlagInitTokens: FlagInitTokens{
 			Etcd:   "",
 			Master: "",
@@ -172,16 +246,17 @@ func New(vaultClient *vault.Client, logger *logrus.Entry) *Kubernetes {
 	}
 
 	if vaultClient != nil {
-		k.vaultClient = realVaultFromAPI(vaultClient)
+		k.vaultClient = vaultClient
 	}
 	if logger != nil {
 		k.Log = logger
 	}
 
-	k.etcdKubernetesPKI = NewPKI(k, "etcd-k8s", k.Log)
-	k.etcdOverlayPKI = NewPKI(k, "etcd-overlay", k.Log)
-	k.kubernetesPKI = NewPKI(k, "k8s", k.Log)
-	k.kubernetesAPIProxy = NewPKI(k, "k8s-api-proxy", k.Log)
+	k.pkis = make(map[string]*PKI)
+	k.etcdKubernetesPKI = k.PKI("etcd-k8s")
+	k.etcdOverlayPKI = k.PKI("etcd-overlay")
+	k.kubernetesPKI = k.PKI("k8s")
+	k.kubernetesAPIProxy = k.PKI("k8s-api-proxy")
 
 	k.secretsGeneric = k.NewGeneric(k.Log)
 
@@ -192,20 +267,36 @@ func (k *Kubernetes) SetClusterID(clusterID string) {
 	k.clusterID = clusterID
 }
 
+func (k *Kubernetes) ClusterID() string {
+	return k.clusterID
+}
+
+// Set the validity of CA certificates, including the lease TTLs of the
+// existing PKIs, which copied the default on creation
+func (k *Kubernetes) SetMaxValidityCA(d time.Duration) {
+	k.MaxValidityCA = d
+	for _, p := range k.pkis {
+		p.MaxLeaseTTL = d
+		p.DefaultLeaseTTL = d
+	}
+}
+
 func (k *Kubernetes) backends() []Backend {
-	return []Backend{
-		k.etcdKubernetesPKI,
-		k.etcdOverlayPKI,
-		k.kubernetesPKI,
-		k.kubernetesAPIProxy,
-		k.secretsGeneric,
+	// secrets come first, as PKIs store pending CSRs in there
+	backends := []Backend{k.secretsGeneric}
+	for _, p := range k.Spec().PKIs {
+		backends = append(backends, k.PKI(p.Name))
 	}
+	return backends
 }
 
 func (k *Kubernetes) Ensure() error {
 	if err := isValidClusterID(k.clusterID); err != nil {
 		return fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
 	}
+	if err := k.checkNodeIdentity(); err != nil {
+		return err
+	}
 
 	// setup backends
 	var result error
@@ -219,26 +310,31 @@ func (k *Kubernetes) Ensure() error {
 	}
 
 	// setup pki roles
-	if err := k.ensurePKIRolesEtcd(k.etcdKubernetesPKI); err != nil {
-		result = multierror.Append(result, err)
-	}
-	if err := k.ensurePKIRolesEtcd(k.etcdOverlayPKI); err != nil {
-		result = multierror.Append(result, err)
+	for _, p := range k.Spec().PKIs {
+		if err := k.ensurePKIRoles(k.PKI(p.Name), p.Roles); err != nil {
+			result = multierror.Append(result, err)
+		}
 	}
-	if err := k.ensurePKIRolesK8S(k.kubernetesPKI); err != nil {
+
+	// setup policies
+	if err := k.ensurePolicies(); err != nil {
 		result = multierror.Append(result, err)
 	}
-	if err := k.ensurePKIRolesK8SAPIProxy(k.kubernetesAPIProxy); err != nil {
+	if err := k.ensureNodePolicies(); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	// setup policies
-	if err := k.ensurePolicies(); err != nil {
+	// setup the bootstrap of nodes
+	if k.Bootstrap == BootstrapAppRole {
+		if err := k.ensureAppRoles(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	} else if err := k.ensureInitTokens(); err != nil {
 		result = multierror.Append(result, err)
 	}
 
-	// setup init tokens
-	if err := k.ensureInitTokens(); err != nil {
+	// nodes holding a certificate log in with it
+	if err := k.ensureCertAuth(); err != nil {
 		result = multierror.Append(result, err)
 	}
 
@@ -253,6 +349,7 @@ func (k *Kubernetes) NewGeneric(logger *logrus.Entry) *Generic {
 	return &Generic{
 		kubernetes: k,
 		initTokens: make(map[string]string),
+		versions:   make(map[string]int),
 		Log:        logger,
 	}
 }
@@ -274,6 +371,51 @@ func GetMountByPath(vaultClient Vault, mountPath string) (*vault.MountOutput, er
 	return mount, nil
 }
 
+// Get a string field of a secret
+func secretString(s *vault.Secret, key string) (string, error) {
+	if s == nil || s.Data == nil {
+		return "", errors.New("no data returned")
+	}
+	dat, ok := s.Data[key]
+	if !ok {
+		return "", fmt.Errorf("key '%s' not found", key)
+	}
+	str, ok := dat.(string)
+	if !ok {
+		return "", fmt.Errorf("key '%s' has wrong type: %T", key, dat)
+	}
+	return str, nil
+}
+
+// List the keys stored at a path, returns nil if nothing exists
+func listKeys(vaultClient Vault, path string) ([]string, error) {
+	s, err := vaultClient.Logical().List(path)
+	if err != nil {
+		return nil, fmt.Errorf("error listing '%s': %v", path, err)
+	}
+	if s == nil || s.Data == nil {
+		return nil, nil
+	}
+
+	dat, ok := s.Data["keys"]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := dat.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("error keys at '%s' have wrong type: %T", path, dat)
+	}
+
+	keys := make([]string, len(list))
+	for pos, key := range list {
+		if keys[pos], ok = key.(string); !ok {
+			return nil, fmt.Errorf("error key at '%s' has wrong type: %T", path, key)
+		}
+	}
+
+	return keys, nil
+}
+
 func (k *Kubernetes) NewInitToken(role, expected string, policies []string) *InitToken {
 	return &InitToken{
 		Role:          role,
@@ -283,25 +425,23 @@ func (k *Kubernetes) NewInitToken(role, expected string, policies []string) *Ini
 	}
 }
 
+// Build the init tokens of the spec
+func (k *Kubernetes) specInitTokens() []*InitToken {
+	var initTokens []*InitToken
+	for _, i := range k.Spec().InitTokens {
+		var policies []string
+		for _, policy := range i.Policies {
+			policies = append(policies, k.policyName(policy))
+		}
+		initTokens = append(initTokens, k.NewInitToken(i.Role, k.FlagInitTokens.Expected(i.Role), policies))
+	}
+	return initTokens
+}
+
 func (k *Kubernetes) ensureInitTokens() error {
 	var result error
 
-	k.initTokens = append(k.initTokens, k.NewInitToken("etcd", k.FlagInitTokens.Etcd, []string{
-		k.etcdPolicy().Name,
-	}))
-	k.initTokens = append(k.initTokens, k.NewInitToken("master", k.FlagInitTokens.Master, []string{
-		k.masterPolicy().Name,
-		k.workerPolicy().Name,
-	}))
-	k.initTokens = append(k.initTokens, k.NewInitToken("worker", k.FlagInitTokens.Worker, []string{
-		k.workerPolicy().Name,
-	}))
-	k.initTokens = append(k.initTokens, k.NewInitToken("all", k.FlagInitTokens.All, []string{
-		k.etcdPolicy().Name,
-		k.masterPolicy().Name,
-		k.workerPolicy().Name,
-	}))
-
+	k.initTokens = k.specInitTokens()
 	for _, initToken := range k.initTokens {
 		if err := initToken.Ensure(); err != nil {
 			result = multierror.Append(result, err)
@@ -321,6 +461,101 @@ func (k *Kubernetes) InitTokens() map[string]string {
 	return output
 }
 
+// Get the init token of a role of the spec
+func (k *Kubernetes) initToken(role string) (*InitToken, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	for _, initToken := range k.specInitTokens() {
+		if initToken.Role == role {
+			return initToken, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown init token role '%s'", role)
+}
+
+// Get the spec of an init token role
+func (k *Kubernetes) specInitToken(role string) (*SpecInitToken, error) {
+	for _, s := range k.Spec().InitTokens {
+		if s.Role == role {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown init token role '%s'", role)
+}
+
+// Replace the init token of a role, revoking the old one
+func (k *Kubernetes) RotateInitToken(role string) (string, error) {
+	initToken, err := k.initToken(role)
+	if err != nil {
+		return "", err
+	}
+
+	return initToken.Rotate()
+}
+
+// Revoke the init token of a role, setup will create a new one
+func (k *Kubernetes) RevokeInitToken(role string) error {
+	initToken, err := k.initToken(role)
+	if err != nil {
+		return err
+	}
+
+	return initToken.Revoke()
+}
+
+// Add component roles to the kubernetes PKI
+func (k *Kubernetes) AddComponentRoles(roles []*SpecRole) error {
+	spec := k.Spec()
+	for _, role := range roles {
+		if err := spec.AddRole(k.kubernetesPKI.pkiName, role); err != nil {
+			return fmt.Errorf("error adding role '%s': %v", role.Name, err)
+		}
+	}
+	k.componentRoles = append(k.componentRoles, roles...)
+	return nil
+}
+
+// Get the CSRs of all intermediate CAs waiting for a signed certificate
+func (k *Kubernetes) PendingCSRs() (map[string]string, error) {
+	output := map[string]string{}
+	for _, p := range k.Spec().PKIs {
+		csr, err := k.secretsGeneric.CSRStore(p.Name)
+		if err != nil {
+			return nil, err
+		}
+		if csr != "" {
+			output[p.Name] = csr
+		}
+	}
+	return output, nil
+}
+
+// Import the signed certificate of a pending intermediate CA
+func (k *Kubernetes) ImportSignedCA(pkiName, certPEM string) error {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+	return k.PKI(pkiName).ImportSigned(certPEM)
+}
+
+// Get the expected token for an init token role, empty if none was given
+func (f FlagInitTokens) Expected(role string) string {
+	switch role {
+	case "etcd":
+		return f.Etcd
+	case "master":
+		return f.Master
+	case "worker":
+		return f.Worker
+	case "all":
+		return f.All
+	}
+	return ""
+}
+
 func (k *Kubernetes) SetInitFlags(flags FlagInitTokens) {
 	k.FlagInitTokens = flags
 }
@@ -53,6 +53,7 @@ func (v *fakeVault) Finish() {
 
 func (v *fakeVault) Ensure() {
 	v.fakeSys.EXPECT().ListMounts().AnyTimes().Return(nil, nil)
+	v.fakeSys.EXPECT().ListPolicies().AnyTimes().Return(nil, nil)
 
 	v.fakeSys.EXPECT().Mount("test-cluster-inside/pki/etcd-k8s", gomock.Any()).Times(1).Return(nil)
 	v.fakeSys.EXPECT().Mount("test-cluster-inside/pki/etcd-overlay", gomock.Any()).Times(1).Return(nil)
@@ -61,33 +62,48 @@ func (v *fakeVault) Ensure() {
 	v.fakeSys.EXPECT().Mount("test-cluster-inside/secrets", gomock.Any()).Times(1).Return(nil)
 
 	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/etcd-k8s/cert/ca").Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/ca_csr_etcd-k8s").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/etcd-k8s/root/generate/internal", gomock.Any()).Times(1).Return(nil, nil)
 
 	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/etcd-overlay/cert/ca").Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/ca_csr_etcd-overlay").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/etcd-overlay/root/generate/internal", gomock.Any()).Times(1).Return(nil, nil)
 
 	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/cert/ca").Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/ca_csr_k8s").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/root/generate/internal", gomock.Any()).Times(1).Return(nil, nil)
 
 	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s-api-proxy/cert/ca").Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/ca_csr_k8s-api-proxy").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s-api-proxy/root/generate/internal", gomock.Any()).Times(1).Return(nil, nil)
 
 	v.fakeLogical.EXPECT().Read("test-cluster-inside/secrets/service-accounts").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/secrets/service-accounts", gomock.Any()).Times(1).Return(nil, nil)
 
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/etcd-k8s/roles/client").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/etcd-k8s/roles/client", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/etcd-k8s/roles/server").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/etcd-k8s/roles/server", gomock.Any()).Times(1).Return(nil, nil)
 
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/etcd-overlay/roles/client").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/etcd-overlay/roles/client", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/etcd-overlay/roles/server").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/etcd-overlay/roles/server", gomock.Any()).Times(1).Return(nil, nil)
 
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/roles/admin").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/admin", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/roles/kube-apiserver").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/kube-apiserver", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/roles/kube-scheduler").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/kube-scheduler", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/roles/kube-controller-manager").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/kube-controller-manager", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/roles/kube-proxy").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/kube-proxy", gomock.Any()).Times(1).Return(nil, nil)
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s/roles/kubelet").Times(1).Return(nil, nil)
 	v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/kubelet", gomock.Any()).Times(1).Return(nil, nil)
 
+	v.fakeLogical.EXPECT().Read("test-cluster-inside/pki/k8s-api-proxy/roles/kube-apiserver").Times(1).Return(nil, nil)
 	last := v.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s-api-proxy/roles/kube-apiserver", gomock.Any()).Times(1).Return(nil, nil)
 	v.fakeSys.EXPECT().PutPolicy(gomock.Any(), gomock.Any()).AnyTimes().Return(nil).After(last)
 
@@ -153,6 +169,7 @@ func (v *fakeVault) PKIEnsure() {
 		Type:        "pki",
 	}
 	v.fakeSys.EXPECT().ListMounts().AnyTimes().Return(nil, nil)
+	v.fakeSys.EXPECT().ListPolicies().AnyTimes().Return(nil, nil)
 
 	v.fakeSys.EXPECT().Mount("test-cluster-inside/pki/etcd-k8s", mountInput1).Times(1).Return(nil)
 	v.fakeSys.EXPECT().Mount("test-cluster-inside/pki/etcd-overlay", mountInput2).Times(1).Return(nil)
@@ -44,6 +44,19 @@ func (_mr *MockBackendMockRecorder) Ensure() *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Ensure", reflect.TypeOf((*MockBackend)(nil).Ensure))
 }
 
+// Plan mocks base method
+func (_m *MockBackend) Plan() ([]*Change, error) {
+	ret := _m.ctrl.Call(_m, "Plan")
+	ret0, _ := ret[0].([]*Change)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Plan indicates an expected call of Plan
+func (_mr *MockBackendMockRecorder) Plan() *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Plan", reflect.TypeOf((*MockBackend)(nil).Plan))
+}
+
 // Path mocks base method
 func (_m *MockBackend) Path() string {
 	ret := _m.ctrl.Call(_m, "Path")
@@ -105,6 +118,32 @@ func (_mr *MockVaultLogicalMockRecorder) Read(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Read", reflect.TypeOf((*MockVaultLogical)(nil).Read), arg0)
 }
 
+// List mocks base method
+func (_m *MockVaultLogical) List(path string) (*api.Secret, error) {
+	ret := _m.ctrl.Call(_m, "List", path)
+	ret0, _ := ret[0].(*api.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (_mr *MockVaultLogicalMockRecorder) List(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "List", reflect.TypeOf((*MockVaultLogical)(nil).List), arg0)
+}
+
+// Delete mocks base method
+func (_m *MockVaultLogical) Delete(path string) (*api.Secret, error) {
+	ret := _m.ctrl.Call(_m, "Delete", path)
+	ret0, _ := ret[0].(*api.Secret)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Delete indicates an expected call of Delete
+func (_mr *MockVaultLogicalMockRecorder) Delete(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Delete", reflect.TypeOf((*MockVaultLogical)(nil).Delete), arg0)
+}
+
 // MockVaultSys is a mock of VaultSys interface
 type MockVaultSys struct {
 	ctrl     *gomock.Controller
@@ -166,6 +205,67 @@ func (_mr *MockVaultSysMockRecorder) Mount(arg0, arg1 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Mount", reflect.TypeOf((*MockVaultSys)(nil).Mount), arg0, arg1)
 }
 
+// Unmount mocks base method
+func (_m *MockVaultSys) Unmount(path string) error {
+	ret := _m.ctrl.Call(_m, "Unmount", path)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Unmount indicates an expected call of Unmount
+func (_mr *MockVaultSysMockRecorder) Unmount(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Unmount", reflect.TypeOf((*MockVaultSys)(nil).Unmount), arg0)
+}
+
+// Remount mocks base method
+func (_m *MockVaultSys) Remount(from string, to string) error {
+	ret := _m.ctrl.Call(_m, "Remount", from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Remount indicates an expected call of Remount
+func (_mr *MockVaultSysMockRecorder) Remount(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "Remount", reflect.TypeOf((*MockVaultSys)(nil).Remount), arg0, arg1)
+}
+
+// ListAuth mocks base method
+func (_m *MockVaultSys) ListAuth() (map[string]*api.AuthMount, error) {
+	ret := _m.ctrl.Call(_m, "ListAuth")
+	ret0, _ := ret[0].(map[string]*api.AuthMount)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAuth indicates an expected call of ListAuth
+func (_mr *MockVaultSysMockRecorder) ListAuth() *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "ListAuth", reflect.TypeOf((*MockVaultSys)(nil).ListAuth))
+}
+
+// EnableAuth mocks base method
+func (_m *MockVaultSys) EnableAuth(path string, authType string, desc string) error {
+	ret := _m.ctrl.Call(_m, "EnableAuth", path, authType, desc)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnableAuth indicates an expected call of EnableAuth
+func (_mr *MockVaultSysMockRecorder) EnableAuth(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "EnableAuth", reflect.TypeOf((*MockVaultSys)(nil).EnableAuth), arg0, arg1, arg2)
+}
+
+// DisableAuth mocks base method
+func (_m *MockVaultSys) DisableAuth(path string) error {
+	ret := _m.ctrl.Call(_m, "DisableAuth", path)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableAuth indicates an expected call of DisableAuth
+func (_mr *MockVaultSysMockRecorder) DisableAuth(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DisableAuth", reflect.TypeOf((*MockVaultSys)(nil).DisableAuth), arg0)
+}
+
 // PutPolicy mocks base method
 func (_m *MockVaultSys) PutPolicy(name string, rules string) error {
 	ret := _m.ctrl.Call(_m, "PutPolicy", name, rules)
@@ -178,6 +278,18 @@ func (_mr *MockVaultSysMockRecorder) PutPolicy(arg0, arg1 interface{}) *gomock.C
 	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "PutPolicy", reflect.TypeOf((*MockVaultSys)(nil).PutPolicy), arg0, arg1)
 }
 
+// DeletePolicy mocks base method
+func (_m *MockVaultSys) DeletePolicy(name string) error {
+	ret := _m.ctrl.Call(_m, "DeletePolicy", name)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePolicy indicates an expected call of DeletePolicy
+func (_mr *MockVaultSysMockRecorder) DeletePolicy(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCallWithMethodType(_mr.mock, "DeletePolicy", reflect.TypeOf((*MockVaultSys)(nil).DeletePolicy), arg0)
+}
+
 // TuneMount mocks base method
 func (_m *MockVaultSys) TuneMount(path string, config api.MountConfigInput) error {
 	ret := _m.ctrl.Call(_m, "TuneMount", path, config)
@@ -44,22 +44,6 @@ func (k *Kubernetes) etcdServerRole() *pkiRole {
 	}
 }
 
-// this makes sure all etcd PKI roles are setup correctly
-func (k *Kubernetes) ensurePKIRolesEtcd(p *PKI) error {
-	var err error
-	var result error
-
-	if err = p.WriteRole(k.etcdClientRole()); err != nil {
-		result = multierror.Append(result, err)
-	}
-
-	if err = p.WriteRole(k.etcdServerRole()); err != nil {
-		result = multierror.Append(result, err)
-	}
-
-	return result
-}
-
 func (k *Kubernetes) k8sAdminRole() *pkiRole {
 	return &pkiRole{
 		Name: "admin",
@@ -159,38 +143,47 @@ func (k *Kubernetes) k8sComponentRole(roleName string) *pkiRole {
 	}
 }
 
-// this makes sure all kubernetes PKI roles are setup correctly
-func (k *Kubernetes) ensurePKIRolesK8S(p *PKI) error {
-	var result error
+// Parse component roles given as '<role>=<class>', a role can be given more
+// than once to allow several classes to sign it
+func ParseComponentRoles(values []string) ([]*SpecRole, error) {
+	var roles []*SpecRole
+	byName := map[string]*SpecRole{}
 
-	roles := []*pkiRole{
-		k.k8sAdminRole(),
-		k.k8sAPIServerRole(),
-		k.k8sComponentRole("kube-scheduler"),
-		k.k8sComponentRole("kube-controller-manager"),
-		k.k8sComponentRole("kube-proxy"),
-		k.k8sKubeletRole(),
-	}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid role '%s', expected '<role>=<class>'", value)
+		}
 
-	for _, role := range roles {
-		if err := p.WriteRole(role); err != nil {
-			result = multierror.Append(result, err)
+		role, ok := byName[parts[0]]
+		if !ok {
+			role = &SpecRole{
+				Name:     parts[0],
+				Template: RoleTemplateComponent,
+				Validity: ValidityComponents,
+			}
+			byName[role.Name] = role
+			roles = append(roles, role)
 		}
+		role.Classes = append(role.Classes, parts[1])
 	}
 
-	return result
+	return roles, nil
 }
 
-// this makes sure all kubernetes API Proxy PKI roles are setup correctly
-func (k *Kubernetes) ensurePKIRolesK8SAPIProxy(p *PKI) error {
+// this makes sure all PKI roles of the spec are setup correctly
+func (k *Kubernetes) ensurePKIRoles(p *PKI, roles []*SpecRole) error {
 	var result error
 
-	roles := []*pkiRole{
-		k.k8sAPIServerProxyRole(),
-	}
-
 	for _, role := range roles {
-		if err := p.WriteRole(role); err != nil {
+		r := k.pkiRole(role)
+		if _, ok := r.Data["key_type"]; !ok {
+			if err := p.keepRoleKey(r); err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+		}
+		if err := p.WriteRole(r); err != nil {
 			result = multierror.Append(result, err)
 		}
 	}
@@ -20,25 +20,22 @@ func (k *Kubernetes) ensurePolicies() error {
 	var result error
 
 	str := "Policies written for: "
-	for _, p := range []*Policy{
-		k.etcdPolicy(),
-		k.masterPolicy(),
-		k.workerPolicy(),
-	} {
+	for _, specPolicy := range k.Spec().Policies {
+		p := k.policy(specPolicy)
 		if err := k.WritePolicy(p); err != nil {
 			result = multierror.Append(result, err)
 		} else {
 			str += "'" + p.Role + "'  "
 		}
 	}
-	k.Log.Infof(str)
+	k.Log.Info(str)
 
 	return result
 }
 
 func (k *Kubernetes) etcdPolicy() *Policy {
 	role := "etcd"
-	return &Policy{
+	p := &Policy{
 		Name: fmt.Sprintf("%s/%s", k.clusterID, role),
 		Role: role,
 		Policies: []*policyPath{
@@ -52,6 +49,9 @@ func (k *Kubernetes) etcdPolicy() *Policy {
 			},
 		},
 	}
+	p.Policies = append(p.Policies, k.caBundlePolicyPaths(k.etcdKubernetesPKI, k.etcdOverlayPKI)...)
+
+	return p
 }
 
 func (k *Kubernetes) masterPolicy() *Policy {
@@ -65,7 +65,7 @@ func (k *Kubernetes) masterPolicy() *Policy {
 				capabilities: []string{"create", "read", "update"},
 			},
 			&policyPath{
-				path:         filepath.Join(k.secretsGeneric.Path(), "service-accounts"),
+				path:         k.secretsGeneric.policyPath(serviceAccountsKey),
 				capabilities: []string{"read"},
 			},
 		},
@@ -90,6 +90,7 @@ func (k *Kubernetes) masterPolicy() *Policy {
 			capabilities: []string{"create", "read", "update"},
 		},
 	)
+	p.Policies = append(p.Policies, k.caBundlePolicyPaths(k.etcdKubernetesPKI, k.kubernetesAPIProxy)...)
 
 	// adds the roles from the worker
 	p.Policies = append(p.Policies, k.workerPolicyPaths()...)
@@ -98,11 +99,18 @@ func (k *Kubernetes) masterPolicy() *Policy {
 }
 
 func (k *Kubernetes) workerPolicyPaths() []*policyPath {
-	return []*policyPath{
-		&policyPath{
+	var paths []*policyPath
+
+	// with node identities only the policy of a node can sign its kubelet
+	// certificate
+	if !k.NodeIdentity {
+		paths = append(paths, &policyPath{
 			path:         filepath.Join(k.kubernetesPKI.Path(), "sign/kubelet"),
 			capabilities: []string{"create", "read", "update"},
-		},
+		})
+	}
+
+	paths = append(paths, []*policyPath{
 		&policyPath{
 			path:         filepath.Join(k.kubernetesPKI.Path(), "sign/kube-proxy"),
 			capabilities: []string{"create", "read", "update"},
@@ -111,7 +119,21 @@ func (k *Kubernetes) workerPolicyPaths() []*policyPath {
 			path:         filepath.Join(k.etcdOverlayPKI.Path(), "sign/client"),
 			capabilities: []string{"create", "read", "update"},
 		},
+	}...)
+
+	return append(paths, k.caBundlePolicyPaths(k.kubernetesPKI, k.etcdOverlayPKI)...)
+}
+
+// Read access to the trust bundles published while CAs are rotated
+func (k *Kubernetes) caBundlePolicyPaths(pkis ...*PKI) []*policyPath {
+	var paths []*policyPath
+	for _, p := range pkis {
+		paths = append(paths, &policyPath{
+			path:         k.secretsGeneric.policyPath(k.secretsGeneric.caBundleKey(p.pkiName)),
+			capabilities: []string{"read"},
+		})
 	}
+	return paths
 }
 
 func (k *Kubernetes) workerPolicy() *Policy {
@@ -0,0 +1,322 @@
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagNodeIdentity = "node-identity"
+const FlagNodeClass = "class"
+
+// Node names as accepted by kubernetes
+var nodeNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]*[a-z0-9])?$`)
+
+// Roles, policies and init tokens of a node are named after it
+const nodeRolePrefix = "node-"
+
+// A node with its own identity, its token can only sign kubelet certificates
+// for the node's name
+type Node struct {
+	Name string
+	// Class is the init token role the node gets its other policies from
+	Class      string
+	kubernetes *Kubernetes
+}
+
+// The secrets a node gets its first token with, depending on the bootstrap
+type NodeBootstrap struct {
+	InitToken string
+	RoleID    string
+	SecretID  string
+}
+
+func (k *Kubernetes) NewNode(name, class string) (*Node, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+	if !nodeNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid node name '%s'", name)
+	}
+	if class != "" {
+		if _, err := k.specInitToken(class); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Node{
+		Name:       name,
+		Class:      class,
+		kubernetes: k,
+	}, nil
+}
+
+func (n *Node) Role() string {
+	return nodeRolePrefix + n.Name
+}
+
+// The common name kubelets use in their certificates
+func (n *Node) CommonName() string {
+	return "system:node:" + n.Name
+}
+
+// The policy allowing to sign the node's kubelet certificate on all CA
+// generations. The certificate can't name other nodes, IP SANs are free.
+func (n *Node) policy() *Policy {
+	k := n.kubernetes
+	p := &Policy{
+		Name: k.policyName(n.Role()),
+		Role: n.Role(),
+	}
+
+	for _, path := range k.pkiGenerationPaths(filepath.Join("pki", certAuthPKI, "sign", "kubelet")) {
+		p.Policies = append(p.Policies, &policyPath{
+			path:         path,
+			capabilities: []string{"create", "read", "update"},
+			allowedParameters: map[string][]string{
+				"common_name": []string{n.CommonName()},
+				"alt_names":   []string{"", n.CommonName()},
+				"ip_sans":     []string{},
+				"csr":         []string{},
+			},
+		})
+	}
+
+	return p
+}
+
+// Policies of the node, relative to the cluster: the ones of its class and
+// its own
+func (n *Node) policies() ([]string, error) {
+	var policies []string
+	if n.Class != "" {
+		s, err := n.kubernetes.specInitToken(n.Class)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, s.Policies...)
+	}
+	return append(policies, n.Role()), nil
+}
+
+// Write the policy of the node and the role it logs in with. Returns the
+// secrets of the node's bootstrap.
+func (n *Node) Ensure() (*NodeBootstrap, error) {
+	k := n.kubernetes
+
+	if err := k.PKI(certAuthPKI).loadGenerations(); err != nil {
+		return nil, err
+	}
+	if err := k.WritePolicy(n.policy()); err != nil {
+		return nil, err
+	}
+
+	policies, err := n.policies()
+	if err != nil {
+		return nil, err
+	}
+	var policyNames []string
+	for _, policy := range policies {
+		policyNames = append(policyNames, k.policyName(policy))
+	}
+
+	bootstrap := &NodeBootstrap{}
+	if k.Bootstrap == BootstrapAppRole {
+		enabled, err := k.appRoleAuthEnabled()
+		if err != nil {
+			return nil, err
+		}
+		if !enabled {
+			return nil, fmt.Errorf("AppRole auth is not enabled, run setup with --%s %s first", FlagBootstrap, BootstrapAppRole)
+		}
+
+		appRole := k.NewAppRole(n.Role(), policyNames)
+		if err := appRole.Ensure(); err != nil {
+			return nil, err
+		}
+		if bootstrap.RoleID, err = appRole.RoleID(); err != nil {
+			return nil, err
+		}
+		if bootstrap.SecretID, err = appRole.NewSecretID(nil); err != nil {
+			return nil, err
+		}
+	} else {
+		initToken := k.NewInitToken(n.Role(), "", policyNames)
+		if err := initToken.Ensure(); err != nil {
+			return nil, err
+		}
+		if bootstrap.InitToken, err = initToken.InitToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	// nodes holding their kubelet certificate log in with it
+	enabled, err := k.certAuthEnabled()
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		caPEM, err := k.certAuthCAs()
+		if err != nil {
+			return nil, err
+		}
+		s := &SpecCertAuth{
+			Role:         n.Role(),
+			AllowedNames: []string{n.CommonName()},
+			Policies:     policies,
+		}
+		path := k.certAuthRolePath(s.Role)
+		if _, err := k.vaultClient.Logical().Write(path, k.certAuthRoleData(s, caPEM)); err != nil {
+			return nil, fmt.Errorf("error writing cert auth role '%s': %v", path, err)
+		}
+	}
+
+	return bootstrap, nil
+}
+
+// Remove the policy of the node and the roles it logs in with, revoking its
+// init token
+func (n *Node) Delete() error {
+	k := n.kubernetes
+	var result error
+
+	initToken := k.NewInitToken(n.Role(), "", nil)
+	token, err := k.secretsGeneric.InitTokenStore(initToken.Role)
+	if err != nil {
+		result = multierror.Append(result, err)
+	} else if token != "" {
+		if err := initToken.Revoke(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if _, err := k.vaultClient.Logical().Delete(initToken.Path()); err != nil {
+		result = multierror.Append(result, fmt.Errorf("error deleting token role '%s': %v", initToken.Path(), err))
+	}
+	for _, policy := range []string{initToken.initTokenPolicy().Name, k.policyName(n.Role())} {
+		if err := k.vaultClient.Sys().DeletePolicy(policy); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting policy '%s': %v", policy, err))
+		}
+	}
+
+	if enabled, err := k.appRoleAuthEnabled(); err != nil {
+		result = multierror.Append(result, err)
+	} else if enabled {
+		path := k.NewAppRole(n.Role(), nil).Path()
+		if _, err := k.vaultClient.Logical().Delete(path); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting AppRole '%s': %v", path, err))
+		}
+	}
+
+	if enabled, err := k.certAuthEnabled(); err != nil {
+		result = multierror.Append(result, err)
+	} else if enabled {
+		path := k.certAuthRolePath(n.Role())
+		if _, err := k.vaultClient.Logical().Delete(path); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting cert auth role '%s': %v", path, err))
+		}
+	}
+
+	return result
+}
+
+// Add a node with its own identity, see Node.Ensure
+func (k *Kubernetes) AddNode(name, class string) (*NodeBootstrap, error) {
+	n, err := k.NewNode(name, class)
+	if err != nil {
+		return nil, err
+	}
+	return n.Ensure()
+}
+
+// Remove a node added with AddNode
+func (k *Kubernetes) RemoveNode(name string) error {
+	n, err := k.NewNode(name, "")
+	if err != nil {
+		return err
+	}
+	return n.Delete()
+}
+
+// The names of the nodes added to the cluster, found by their policies
+func (k *Kubernetes) nodeNames() ([]string, error) {
+	policies, err := k.vaultClient.Sys().ListPolicies()
+	if err != nil {
+		return nil, fmt.Errorf("error listing policies: %v", err)
+	}
+
+	prefix := k.policyName(nodeRolePrefix)
+	nodePolicies := map[string]bool{}
+	for _, policy := range policies {
+		if strings.HasPrefix(policy, prefix) {
+			nodePolicies[policy] = true
+		}
+	}
+
+	var names []string
+	for policy := range nodePolicies {
+		// skip the init token policies of nodes
+		if strings.HasSuffix(policy, "-creator") && nodePolicies[strings.TrimSuffix(policy, "-creator")] {
+			continue
+		}
+		names = append(names, strings.TrimPrefix(policy, prefix))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// Rewrite the policies of all nodes, so they sign on all CA generations
+func (k *Kubernetes) ensureNodePolicies() error {
+	names, err := k.nodeNames()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, name := range names {
+		n, err := k.NewNode(name, "")
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		if err := k.WritePolicy(n.policy()); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// With node identities only the policies of nodes may sign kubelet
+// certificates, a policy of the spec signing them could do so for any node
+// name
+func (k *Kubernetes) checkNodeIdentity() error {
+	if !k.NodeIdentity {
+		return nil
+	}
+
+	var result error
+	for _, s := range k.Spec().Policies {
+		p := k.policy(s)
+		for _, path := range k.pkiGenerationPaths(filepath.Join("pki", certAuthPKI, "sign", "kubelet")) {
+			if !canWrite(checkPolicies([]*Policy{p}, path).Capabilities) {
+				continue
+			}
+			result = multierror.Append(result, fmt.Errorf("policy '%s' can sign kubelet certificates for any node at '%s', which --%s restricts to the policies of nodes", s.Name, path, FlagNodeIdentity))
+			break
+		}
+	}
+	return result
+}
+
+func canWrite(capabilities []string) bool {
+	for _, c := range capabilities {
+		if c == "create" || c == "update" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package kubernetes
+
+import (
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+// Sign a kubelet certificate with the given token
+func signKubelet(t *testing.T, vaultDev *vault_dev.VaultDev, token, commonName string) error {
+	c, err := vault.NewClient(&vault.Config{Address: vaultDev.Client().Address()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.SetToken(token)
+
+	csr, err := createCertificateSigningRequest(pkix.Name{CommonName: commonName}, time.Hour, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = c.Logical().Write("test/pki/k8s/sign/kubelet", map[string]interface{}{
+		"common_name": commonName,
+		"alt_names":   commonName,
+		"ip_sans":     "10.0.0.1",
+		"csr":         string(csr),
+	})
+	return err
+}
+
+// Component roles added before node identity is enabled, like the flags of
+// setup do, must not freeze the kubelet signing of the worker policy
+func TestNode_Identity_ComponentRoles(t *testing.T) {
+	k := New(nil, logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+
+	roles, err := ParseComponentRoles([]string{"metrics-server=worker"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.AddComponentRoles(roles); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	k.NodeIdentity = true
+
+	for path, exp := range map[string]string{
+		"test/pki/k8s/sign/kubelet":        "deny",
+		"test/pki/k8s/sign/metrics-server": "create, read, update",
+	} {
+		check, err := k.CheckPolicies("worker", path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if act := strings.Join(check.Capabilities, ", "); act != exp {
+			t.Errorf("unexpected capabilities of the worker policy for '%s', exp=%s got=%s", path, exp, act)
+		}
+	}
+	if err := k.checkNodeIdentity(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// a spec granting the worker policy kubelet certificates is rejected
+	spec := k.Spec()
+	for _, p := range spec.Policies {
+		if p.Name == "worker" {
+			p.Paths = append(p.Paths, &SpecPolicyPath{Path: "pki/k8s/sign/*", Capabilities: []string{"create", "read", "update"}})
+		}
+	}
+	if err := k.SetSpec(spec); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.Ensure(); err == nil || !strings.Contains(err.Error(), "policy 'worker' can sign kubelet certificates") {
+		t.Errorf("expected error of worker policy signing kubelet certificates, got: %v", err)
+	}
+	k.NodeIdentity = false
+	if err := k.checkNodeIdentity(); err != nil {
+		t.Errorf("unexpected error without node identity: %v", err)
+	}
+}
+
+func TestNode(t *testing.T) {
+	vaultDev := vault_dev.New()
+	if err := vaultDev.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vaultDev.Stop()
+
+	k := New(vaultDev.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	k.NodeIdentity = true
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	worker, err := vaultDev.Client().Auth().Token().CreateWithRole(&vault.TokenCreateRequest{}, "test-worker")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := signKubelet(t, vaultDev, worker.Auth.ClientToken, "system:node:kube-1"); err == nil {
+		t.Error("expected the worker policy to be denied signing kubelet certificates")
+	}
+
+	bootstrap, err := k.AddNode("kube-1", "worker")
+	if err != nil {
+		t.Fatalf("error adding node: %v", err)
+	}
+	if bootstrap.InitToken == "" {
+		t.Fatal("expected an init token for the node")
+	}
+
+	// the init token of a node creates tokens with the policies of its class
+	// and its own
+	s, err := vaultDev.Client().Logical().Read("auth/token/roles/test-node-kube-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !equalValues("default,test/node-kube-1,test/worker", s.Data["allowed_policies"]) {
+		t.Errorf("unexpected policies of the node's token role: %v", s.Data["allowed_policies"])
+	}
+
+	secret, err := vaultDev.Client().Auth().Token().CreateWithRole(&vault.TokenCreateRequest{}, "test-node-kube-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodeToken := secret.Auth.ClientToken
+
+	if err := signKubelet(t, vaultDev, nodeToken, "system:node:kube-1"); err != nil {
+		t.Errorf("expected the node to sign its kubelet certificate: %v", err)
+	}
+	if err := signKubelet(t, vaultDev, nodeToken, "system:node:kube-2"); err == nil {
+		t.Error("expected the node to be denied signing for another node")
+	}
+
+	names, err := k.nodeNames()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "kube-1", strings.Join(names, ","); exp != act {
+		t.Errorf("unexpected nodes, exp=%s got=%s", exp, act)
+	}
+
+	// the node policy covers the new CA generation after a rotation
+	if err := k.RotatePKI("k8s"); err != nil {
+		t.Fatalf("error rotating: %v", err)
+	}
+	rules, err := vaultDev.Client().Sys().GetPolicy("test/node-kube-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := strings.Count(rules, "sign/kubelet"); n != 2 {
+		t.Errorf("expected the node policy to cover 2 generations, got %d:\n%s", n, rules)
+	}
+
+	if err := k.RemoveNode("kube-1"); err != nil {
+		t.Fatalf("error removing node: %v", err)
+	}
+	if rules, err := vaultDev.Client().Sys().GetPolicy("test/node-kube-1"); err != nil || rules != "" {
+		t.Errorf("expected the node policy to be deleted, got rules='%s' err=%v", rules, err)
+	}
+	if err := signKubelet(t, vaultDev, nodeToken, "system:node:kube-1"); err == nil {
+		t.Error("expected the removed node to be denied signing")
+	}
+}
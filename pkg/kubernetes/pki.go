@@ -1,19 +1,58 @@
 package kubernetes
 
 import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	vault "github.com/hashicorp/vault/api"
 )
 
+const FlagCAMode = "ca-mode"
+const FlagCAParent = "ca-parent"
+const FlagCAExported = "ca-exported"
+const FlagPKIURL = "pki-url"
+const FlagCRLExpiry = "crl-expiry"
+const FlagPKIKeyType = "pki-key-type"
+const FlagPKIKeyBits = "pki-key-bits"
+const FlagCACSRDir = "ca-csr-dir"
+
+// A root CA is self-signed, an intermediate CA has to be signed by a parent
+const CAModeRoot = "root"
+const CAModeIntermediate = "intermediate"
+
+// Key types PKI roles accept in CSRs, ed25519 needs a vault version
+// supporting it
+const PKIKeyTypeRSA = "rsa"
+const PKIKeyTypeEC = "ec"
+const PKIKeyTypeEd25519 = "ed25519"
+const PKIKeyTypeAny = "any"
+
+type caState int
+
+const (
+	caMissing caState = iota
+	caPending         // intermediate CSR generated, waiting for the signed certificate
+	caReady
+)
+
 type PKI struct {
 	pkiName    string
 	kubernetes *Kubernetes
 
+	// generation is the active CA generation, generations all mounted ones
+	generation  int
+	generations []int
+
+	// keyEscrowed is set when rotating a CA, whose key has been escrowed
+	keyEscrowed bool
+
 	MaxLeaseTTL     time.Duration
 	DefaultLeaseTTL time.Duration
 
@@ -55,6 +94,63 @@ func (p *PKI) TuneMount(mount *vault.MountOutput) error {
 }
 
 func (p *PKI) Ensure() error {
+	if err := p.loadGenerations(); err != nil {
+		return err
+	}
+
+	if err := p.ensureMount(); err != nil {
+		return err
+	}
+
+	if err := p.ensureCA(); err != nil {
+		return err
+	}
+
+	return p.ensureURLs()
+}
+
+// The issuing and CRL URLs of a PKI mount, empty if no URL is configured
+func (p *PKI) urlsData(path string) map[string]interface{} {
+	base := strings.TrimSuffix(p.kubernetes.PKIURL, "/")
+	if base == "" {
+		return nil
+	}
+	return map[string]interface{}{
+		"issuing_certificates":    base + "/v1/" + path + "/ca",
+		"crl_distribution_points": base + "/v1/" + path + "/crl",
+	}
+}
+
+// The CRL config of a PKI mount, empty if no expiry is configured
+func (p *PKI) crlData() map[string]interface{} {
+	if p.kubernetes.CRLExpiry == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"expiry": fmt.Sprintf("%ds", int(p.kubernetes.CRLExpiry.Seconds())),
+	}
+}
+
+// Configure the issuing and CRL URLs and the CRL expiry of all CA
+// generations, certificates of retiring generations can still be revoked
+func (p *PKI) ensureURLs() error {
+	for _, path := range p.generationPaths() {
+		if data := p.urlsData(path); data != nil {
+			if _, err := p.kubernetes.vaultClient.Logical().Write(filepath.Join(path, "config", "urls"), data); err != nil {
+				return fmt.Errorf("error writing URLs of '%s': %v", path, err)
+			}
+		}
+		if data := p.crlData(); data != nil {
+			if _, err := p.kubernetes.vaultClient.Logical().Write(filepath.Join(path, "config", "crl"), data); err != nil {
+				return fmt.Errorf("error writing CRL config of '%s': %v", path, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *PKI) ensureMount() error {
 	mount, err := GetMountByPath(p.kubernetes.vaultClient, p.Path())
 	if err != nil {
 		return err
@@ -94,39 +190,218 @@ func (p *PKI) Ensure() error {
 		}
 	}
 
-	return p.ensureCA()
+	return nil
 }
 
 func (p *PKI) ensureCA() error {
-	b, err := p.caPathExists()
+	state, err := p.caState()
 	if err != nil {
 		return err
 	}
 
-	if !b {
-		return p.generateCA()
+	ca := p.kubernetes.specCA(p.pkiName)
+
+	switch state {
+	case caReady:
+		return nil
+
+	case caPending:
+		if ca.Parent == "" {
+			p.Log.Warnf("CA of '%s' is waiting for a signed certificate, import it using 'pki import-signed'", p.Path())
+			return nil
+		}
+		csr, err := p.kubernetes.secretsGeneric.CSRStore(p.pkiName)
+		if err != nil {
+			return err
+		}
+		return p.signIntermediateCA(ca.Parent, csr)
 	}
 
-	return nil
+	if ca.Mode == CAModeIntermediate {
+		return p.generateIntermediateCA(ca.Parent)
+	}
+	return p.generateCA()
+}
+
+// Whether the CA's key gets exported, new generations of an exported CA
+// stay exported
+func (p *PKI) exported() bool {
+	return p.keyEscrowed || p.kubernetes.specCA(p.pkiName).Exported
+}
+
+// The type of CA key generation, exported keys are returned by vault
+func (p *PKI) caKeyType() string {
+	if p.exported() {
+		return "exported"
+	}
+	return "internal"
+}
+
+// Escrow the private key of an exported CA of the active generation
+func (p *PKI) storeCAKey(s *vault.Secret) error {
+	if !p.exported() {
+		return nil
+	}
+
+	key, err := secretString(s, "private_key")
+	if err != nil {
+		return fmt.Errorf("error reading CA key of '%s': %v", p.Path(), err)
+	}
+
+	return p.kubernetes.secretsGeneric.SetCAKeyStore(filepath.Base(p.Path()), key)
 }
 
 func (p *PKI) generateCA() error {
-	path := filepath.Join(p.Path(), "root", "generate", "internal")
-	description := "Kubernetes " + p.kubernetes.clusterID + "/" + p.pkiName + " CA"
+	path := filepath.Join(p.Path(), "root", "generate", p.caKeyType())
 
 	data := map[string]interface{}{
-		"common_name": description,
+		"common_name": p.caCommonName(),
 		"ttl":         p.getMaxLeaseTTL(),
 	}
 
-	_, err := p.kubernetes.vaultClient.Logical().Write(path, data)
+	s, err := p.kubernetes.vaultClient.Logical().Write(path, data)
 	if err != nil {
 		return fmt.Errorf("error writing new CA: %v", err)
 	}
 
+	return p.storeCAKey(s)
+}
+
+// Generate the intermediate CA's key and store its CSR. If a parent mount is
+// given the CSR gets signed straight away, else the signed certificate has to
+// be imported later.
+func (p *PKI) generateIntermediateCA(parent string) error {
+	path := filepath.Join(p.Path(), "intermediate", "generate", p.caKeyType())
+
+	data := map[string]interface{}{
+		"common_name": p.caCommonName(),
+		"ttl":         p.getMaxLeaseTTL(),
+	}
+
+	s, err := p.kubernetes.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		return fmt.Errorf("error generating intermediate CA: %v", err)
+	}
+
+	csr, err := secretString(s, "csr")
+	if err != nil {
+		return fmt.Errorf("error reading CSR of '%s': %v", path, err)
+	}
+
+	if err := p.kubernetes.secretsGeneric.SetCSRStore(p.pkiName, csr); err != nil {
+		return err
+	}
+	if err := p.storeCAKey(s); err != nil {
+		return err
+	}
+	p.Log.Infof("Generated intermediate CA CSR for '%s'", p.Path())
+
+	if parent == "" {
+		p.Log.Warnf("CA of '%s' is waiting for a signed certificate, import it using 'pki import-signed'", p.Path())
+		return nil
+	}
+
+	return p.signIntermediateCA(parent, csr)
+}
+
+// Sign the CSR using the CA of a parent PKI mount in the same vault
+func (p *PKI) signIntermediateCA(parent, csr string) error {
+	path := filepath.Join(parent, "root", "sign-intermediate")
+
+	data := map[string]interface{}{
+		"csr":         csr,
+		"common_name": p.caCommonName(),
+		"ttl":         p.getMaxLeaseTTL(),
+		"format":      "pem_bundle",
+	}
+
+	s, err := p.kubernetes.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		return fmt.Errorf("error signing intermediate CA of '%s' using '%s': %v", p.Path(), parent, err)
+	}
+
+	cert, err := secretString(s, "certificate")
+	if err != nil {
+		return fmt.Errorf("error reading signed certificate from '%s': %v", path, err)
+	}
+
+	return p.ImportSigned(cert)
+}
+
+// Import the signed certificate of a pending intermediate CA
+func (p *PKI) ImportSigned(certPEM string) error {
+	state, err := p.caState()
+	if err != nil {
+		return err
+	}
+	if state != caPending {
+		return fmt.Errorf("CA of '%s' is not waiting for a signed certificate", p.Path())
+	}
+
+	csr, err := p.kubernetes.secretsGeneric.CSRStore(p.pkiName)
+	if err != nil {
+		return err
+	}
+	if err := verifySignedCSR(csr, certPEM); err != nil {
+		return fmt.Errorf("error verifying certificate for '%s': %v", p.Path(), err)
+	}
+
+	path := filepath.Join(p.Path(), "intermediate", "set-signed")
+	data := map[string]interface{}{
+		"certificate": certPEM,
+	}
+	if _, err := p.kubernetes.vaultClient.Logical().Write(path, data); err != nil {
+		return fmt.Errorf("error importing signed certificate to '%s': %v", path, err)
+	}
+
+	if err := p.kubernetes.secretsGeneric.deleteCSRStore(p.pkiName); err != nil {
+		return err
+	}
+	p.Log.Infof("Imported signed intermediate CA for '%s'", p.Path())
+
 	return nil
 }
 
+// Make sure the first certificate of the PEM bundle is a CA certificate
+// for the public key of the CSR
+func verifySignedCSR(csrPEM, certPEM string) error {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil {
+		return errors.New("stored CSR is not PEM encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("error parsing stored CSR: %v", err)
+	}
+
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate: %v", err)
+	}
+
+	if !cert.IsCA {
+		return errors.New("certificate is not a CA certificate")
+	}
+
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return fmt.Errorf("error marshalling public key of CSR: %v", err)
+	}
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return fmt.Errorf("error marshalling public key of certificate: %v", err)
+	}
+	if !bytes.Equal(csrKey, certKey) {
+		return errors.New("certificate does not match the CSR's public key")
+	}
+
+	return nil
+}
+
+func (p *PKI) caCommonName() string {
+	return "Kubernetes " + p.kubernetes.clusterID + "/" + p.pkiName + " CA"
+}
+
 func (p *PKI) caPathExists() (bool, error) {
 	path := filepath.Join(p.Path(), "cert", "ca")
 
@@ -145,6 +420,87 @@ func (p *PKI) caPathExists() (bool, error) {
 	return true, nil
 }
 
+// Get the state of the CA, it is pending when there is no CA certificate
+// but a CSR is stored
+func (p *PKI) caState() (caState, error) {
+	exists, err := p.caPathExists()
+	if err != nil {
+		return caMissing, err
+	}
+	if exists {
+		return caReady, nil
+	}
+
+	csr, err := p.kubernetes.secretsGeneric.CSRStore(p.pkiName)
+	if err != nil {
+		return caMissing, err
+	}
+	if csr != "" {
+		return caPending, nil
+	}
+
+	return caMissing, nil
+}
+
+// Make sure vault accepts the key type and bits of PKI roles, the bits are
+// the minimum size of RSA keys and EC curves
+func ValidatePKIKey(keyType string, bits int) error {
+	switch keyType {
+	case PKIKeyTypeRSA:
+		if bits != 2048 && bits != 4096 && bits != 8192 {
+			return fmt.Errorf("invalid bits %d for %s keys, expected 2048, 4096 or 8192", bits, keyType)
+		}
+	case PKIKeyTypeEC:
+		if bits != 224 && bits != 256 && bits != 384 && bits != 521 {
+			return fmt.Errorf("invalid bits %d for %s keys, expected 224, 256, 384 or 521", bits, keyType)
+		}
+	case PKIKeyTypeEd25519, PKIKeyTypeAny:
+	default:
+		return fmt.Errorf("unknown key type '%s', expected '%s', '%s', '%s' or '%s'", keyType, PKIKeyTypeRSA, PKIKeyTypeEC, PKIKeyTypeEd25519, PKIKeyTypeAny)
+	}
+
+	return nil
+}
+
+// The key_type and key_bits of PKI roles, nil if unset. Bits only apply to
+// RSA and EC keys.
+func (k *Kubernetes) pkiRoleKeyData() map[string]interface{} {
+	switch k.PKIKeyType {
+	case "":
+		return nil
+	case PKIKeyTypeRSA, PKIKeyTypeEC:
+		return map[string]interface{}{
+			"key_type": k.PKIKeyType,
+			"key_bits": k.PKIKeyBits,
+		}
+	}
+	return map[string]interface{}{
+		"key_type": k.PKIKeyType,
+	}
+}
+
+// Copy the key settings of an existing role to a role without them, writing
+// a role resets the settings it leaves out
+func (p *PKI) keepRoleKey(role *pkiRole) error {
+	path := filepath.Join(p.Path(), "roles", role.Name)
+
+	s, err := p.kubernetes.vaultClient.Logical().Read(path)
+	if err != nil {
+		return fmt.Errorf("error reading role '%s': %v", path, err)
+	}
+	if s == nil {
+		return nil
+	}
+
+	for _, key := range []string{"key_type", "key_bits"} {
+		if value, ok := s.Data[key]; ok {
+			role.Data[key] = value
+		}
+	}
+
+	return nil
+}
+
 func (p *PKI) WriteRole(role *pkiRole) error {
 	path := filepath.Join(p.Path(), "roles", role.Name)
 
@@ -156,8 +512,9 @@ func (p *PKI) WriteRole(role *pkiRole) error {
 	return nil
 }
 
+// Path of the mount of the active CA generation
 func (p *PKI) Path() string {
-	return filepath.Join(p.kubernetes.Path(), "pki", p.pkiName)
+	return p.generationPath(p.generation)
 }
 
 func (p *PKI) getMountConfigInput() vault.MountConfigInput {
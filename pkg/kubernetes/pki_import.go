@@ -0,0 +1,117 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const FlagPKIImportCert = "cert"
+const FlagPKIImportKey = "key"
+
+// Import an existing CA and its private key into the mount of a PKI instead
+// of generating one, e.g. to move clusters bootstrapped by other tooling to
+// vault without re-keying their components. The certificate may be followed
+// by the chain of its issuers.
+func (p *PKI) Import(certPEM, keyPEM string) error {
+	if err := p.loadGenerations(); err != nil {
+		return err
+	}
+
+	if err := verifyImportedCA(certPEM, keyPEM, p.MaxLeaseTTL, p.Log.Warnf); err != nil {
+		return fmt.Errorf("error verifying CA for '%s': %v", p.Path(), err)
+	}
+
+	if err := p.ensureMount(); err != nil {
+		return err
+	}
+
+	state, err := p.caState()
+	if err != nil {
+		return err
+	}
+	switch state {
+	case caReady:
+		return fmt.Errorf("CA of '%s' already exists", p.Path())
+	case caPending:
+		return fmt.Errorf("CA of '%s' is waiting for a signed certificate, import it using 'pki import-signed'", p.Path())
+	}
+
+	path := filepath.Join(p.Path(), "config", "ca")
+	data := map[string]interface{}{
+		"pem_bundle": strings.TrimSpace(keyPEM) + "\n" + strings.TrimSpace(certPEM) + "\n",
+	}
+	if _, err := p.kubernetes.vaultClient.Logical().Write(path, data); err != nil {
+		return fmt.Errorf("error importing CA to '%s': %v", path, err)
+	}
+	p.Log.Infof("Imported CA for '%s'", p.Path())
+
+	if !p.exported() {
+		return nil
+	}
+	if err := p.kubernetes.secretsGeneric.ensureMount(); err != nil {
+		return err
+	}
+	return p.kubernetes.secretsGeneric.SetCAKeyStore(filepath.Base(p.Path()), keyPEM)
+}
+
+// Make sure the first certificate of the PEM bundle is a valid CA certificate
+// for the private key. CAs expiring before the maximum lease TTL of the mount
+// are accepted with a warning, as vault can't issue certificates beyond the
+// expiry of its CA.
+func verifyImportedCA(certPEM, keyPEM string, maxLeaseTTL time.Duration, warnf func(string, ...interface{})) error {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing certificate: %v", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing private key: %v", err)
+	}
+
+	if !cert.BasicConstraintsValid || !cert.IsCA {
+		return errors.New("certificate is not a CA certificate")
+	}
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("certificate is not allowed to sign certificates")
+	}
+
+	certKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return fmt.Errorf("error marshalling public key of certificate: %v", err)
+	}
+	privKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("error marshalling public key of private key: %v", err)
+	}
+	if !bytes.Equal(certKey, privKey) {
+		return errors.New("private key does not match the certificate")
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate is not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if !now.Before(cert.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if left := cert.NotAfter.Sub(now); left < maxLeaseTTL {
+		warnf("CA expires in %s, before the maximum lease TTL of %s, no certificates can be issued beyond %s", formatLifetime(left), formatLifetime(maxLeaseTTL), cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Import an existing CA into a PKI of the spec, before setup generates one
+func (k *Kubernetes) ImportCA(pkiName, certPEM, keyPEM string) error {
+	p, err := k.specPKIByName(pkiName)
+	if err != nil {
+		return err
+	}
+
+	return k.PKI(p.Name).Import(certPEM, keyPEM)
+}
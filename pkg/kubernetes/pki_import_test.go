@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+// Create a self-signed certificate and its key, PEM encoded
+func selfSignedCert(t *testing.T, isCA bool, notAfter time.Time) (string, string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kubernetes"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return string(certPEM), string(keyPEM)
+}
+
+func TestVerifyImportedCA(t *testing.T) {
+	year := time.Hour * 24 * 365
+	caCert, caKey := selfSignedCert(t, true, time.Now().Add(10*year))
+	_, otherKey := selfSignedCert(t, true, time.Now().Add(10*year))
+	leafCert, leafKey := selfSignedCert(t, false, time.Now().Add(10*year))
+	expiredCert, expiredKey := selfSignedCert(t, true, time.Now().Add(-time.Minute))
+
+	for _, c := range []struct {
+		name, cert, key, err string
+		warnings             int
+	}{
+		{"valid", caCert, caKey, "", 0},
+		{"expiring before the max lease TTL", caCert, caKey, "", 1},
+		{"mismatching key", caCert, otherKey, "does not match", 0},
+		{"no CA", leafCert, leafKey, "not a CA", 0},
+		{"expired", expiredCert, expiredKey, "expired", 0},
+		{"no key", caCert, "", "parsing private key", 0},
+	} {
+		maxLeaseTTL := year
+		if c.warnings > 0 {
+			maxLeaseTTL = 20 * year
+		}
+
+		warnings := 0
+		err := verifyImportedCA(c.cert, c.key, maxLeaseTTL, func(string, ...interface{}) { warnings++ })
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing '%s', got %v", c.name, c.err, err)
+		}
+		if warnings != c.warnings {
+			t.Errorf("%s: unexpected number of warnings, exp=%d got=%d", c.name, c.warnings, warnings)
+		}
+	}
+}
+
+func TestPKI_Import(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	// set after creating the PKIs, like the flags are
+	maxValidityCA := time.Hour * 24 * 365 * 5
+	k.SetMaxValidityCA(maxValidityCA)
+
+	certPEM, keyPEM := selfSignedCert(t, true, time.Now().Add(time.Hour*24*365*10))
+	if err := k.ImportCA("unknown", certPEM, keyPEM); err == nil {
+		t.Error("expected an error importing into an unknown PKI")
+	}
+	if err := k.ImportCA("k8s", certPEM, keyPEM); err != nil {
+		t.Fatalf("error importing CA: %v", err)
+	}
+	if err := k.ImportCA("k8s", certPEM, keyPEM); err == nil {
+		t.Error("expected an error importing into a PKI with a CA")
+	}
+
+	// setup keeps the imported CA and issues certificates with it
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	for _, path := range []string{"test/pki/k8s", "test/pki/etcd-k8s"} {
+		mount, err := GetMountByPath(k.vaultClient, path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp, act := int(maxValidityCA.Seconds()), mount.Config.MaxLeaseTTL; exp != act {
+			t.Errorf("unexpected max lease TTL of '%s', exp=%d got=%d", path, exp, act)
+		}
+	}
+
+	ca, err := parseCert(certPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	active, err := k.PKI("k8s").readCACert()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !active.Equal(ca) {
+		t.Error("expected setup to keep the imported CA")
+	}
+
+	leaf := issueKubeProxy(t, k, "test/pki/k8s")
+	if err := leaf.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("expected certificates to be signed by the imported CA: %v", err)
+	}
+}
@@ -0,0 +1,509 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Mounts of later CA generations are named '<pki>-gen<N>', the first
+// generation keeps the plain PKI name
+var generationMountRegexp = regexp.MustCompile(`^(.+)-gen([0-9]+)$`)
+
+// Split the mount name of a PKI generation into PKI name and generation
+func splitPKIGeneration(mountName string) (string, int) {
+	m := generationMountRegexp.FindStringSubmatch(mountName)
+	if m == nil {
+		return mountName, 1
+	}
+	gen, err := strconv.Atoi(m[2])
+	if err != nil || gen < 2 {
+		return mountName, 1
+	}
+	return m[1], gen
+}
+
+func (p *PKI) generationPath(gen int) string {
+	name := p.pkiName
+	if gen > 1 {
+		name = fmt.Sprintf("%s-gen%d", p.pkiName, gen)
+	}
+	return filepath.Join(p.kubernetes.Path(), "pki", name)
+}
+
+// Discover the CA generations mounted in vault, the latest one is active
+func (p *PKI) loadGenerations() error {
+	mounts, err := p.kubernetes.vaultClient.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("error listing mounts: %v", err)
+	}
+
+	prefix := filepath.Join(p.kubernetes.Path(), "pki") + "/"
+	var generations []int
+	for path, mount := range mounts {
+		path = filepath.Clean(path)
+		if !strings.HasPrefix(path, prefix) || mount.Type != "pki" {
+			continue
+		}
+		name, gen := splitPKIGeneration(strings.TrimPrefix(path, prefix))
+		if name == p.pkiName {
+			generations = append(generations, gen)
+		}
+	}
+	sort.Ints(generations)
+
+	p.generations = generations
+	p.generation = 1
+	if len(generations) > 0 {
+		p.generation = generations[len(generations)-1]
+	}
+
+	return nil
+}
+
+// Paths of all mounted generations, the active one first
+func (p *PKI) generationPaths() []string {
+	if len(p.generations) == 0 {
+		return []string{p.Path()}
+	}
+
+	var paths []string
+	for pos := len(p.generations) - 1; pos >= 0; pos-- {
+		paths = append(paths, p.generationPath(p.generations[pos]))
+	}
+	return paths
+}
+
+// Mount a new CA generation next to the active one, which becomes the new
+// active generation. Self-signed CAs are cross-signed in both directions, so
+// certificates of both generations are trusted during the rollover.
+func (p *PKI) Rotate() error {
+	if err := p.loadGenerations(); err != nil {
+		return err
+	}
+
+	state, err := p.caState()
+	if err != nil {
+		return err
+	}
+	if state != caReady {
+		return fmt.Errorf("CA of '%s' has to exist before it can be rotated", p.Path())
+	}
+
+	oldPath := p.Path()
+	oldCert, err := p.readCACert()
+	if err != nil {
+		return err
+	}
+	key, err := p.kubernetes.secretsGeneric.CAKeyStore(filepath.Base(oldPath))
+	if err != nil {
+		return err
+	}
+	p.keyEscrowed = key != ""
+
+	p.generation++
+	p.generations = append(p.generations, p.generation)
+	if err := p.ensureMount(); err != nil {
+		return err
+	}
+	p.Log.Infof("Mounted CA generation %d of '%s' at '%s'", p.generation, p.pkiName, p.Path())
+
+	// an intermediate CA is replaced by another one, both generations chain
+	// up to the same parent, so no cross-signing is needed
+	if oldCert.CheckSignatureFrom(oldCert) != nil {
+		if err := p.generateIntermediateCA(p.kubernetes.specCA(p.pkiName).Parent); err != nil {
+			return err
+		}
+		return p.storeCABundle(oldCert)
+	}
+
+	return p.generateCrossSignedCA(oldPath, oldCert)
+}
+
+// Generate a self-signed CA and cross-sign it with the CA at oldPath. The
+// private key of the new CA is only exported to sign the old CA, it never
+// leaves this process unless the CA is configured to be exported.
+func (p *PKI) generateCrossSignedCA(oldPath string, oldCert *x509.Certificate) error {
+	path := filepath.Join(p.Path(), "root", "generate", "exported")
+
+	data := map[string]interface{}{
+		"common_name": p.caCommonName(),
+		"ttl":         p.getMaxLeaseTTL(),
+	}
+
+	s, err := p.kubernetes.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		return fmt.Errorf("error writing new CA: %v", err)
+	}
+
+	certPEM, err := secretString(s, "certificate")
+	if err != nil {
+		return fmt.Errorf("error reading new CA of '%s': %v", p.Path(), err)
+	}
+	newCert, err := parseCert(certPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing new CA of '%s': %v", p.Path(), err)
+	}
+	keyPEM, err := secretString(s, "private_key")
+	if err != nil {
+		return fmt.Errorf("error reading new CA key of '%s': %v", p.Path(), err)
+	}
+	newKey, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return fmt.Errorf("error parsing new CA key of '%s': %v", p.Path(), err)
+	}
+	if err := p.storeCAKey(s); err != nil {
+		return err
+	}
+
+	newByOld, err := p.crossSignNew(oldPath, oldCert, newCert, newKey)
+	if err != nil {
+		return err
+	}
+
+	oldByNew, err := crossSign(oldCert, newCert, newKey)
+	if err != nil {
+		return fmt.Errorf("error cross-signing CA of '%s': %v", oldPath, err)
+	}
+
+	return p.kubernetes.secretsGeneric.SetCABundleStore(p.pkiName, encodeCerts(oldCert, newCert, newByOld, oldByNew))
+}
+
+// Let the old CA sign the new CA's key, using a CSR created with the new key
+func (p *PKI) crossSignNew(oldPath string, oldCert, newCert *x509.Certificate, newKey crypto.Signer) (*x509.Certificate, error) {
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: newCert.Subject}, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSR for cross-signing: %v", err)
+	}
+
+	// the old CA can't sign beyond its own expiry
+	ttl := p.MaxLeaseTTL
+	if remaining := oldCert.NotAfter.Sub(time.Now()) - time.Hour; remaining < ttl {
+		ttl = remaining
+	}
+
+	path := filepath.Join(oldPath, "root", "sign-intermediate")
+	data := map[string]interface{}{
+		"csr":             string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"common_name":     newCert.Subject.CommonName,
+		"ttl":             fmt.Sprintf("%ds", int(ttl.Seconds())),
+		"use_csr_values":  true,
+		"max_path_length": -1,
+	}
+
+	s, err := p.kubernetes.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("error cross-signing '%s' using '%s': %v", p.Path(), oldPath, err)
+	}
+
+	certPEM, err := secretString(s, "certificate")
+	if err != nil {
+		return nil, fmt.Errorf("error reading cross-signed certificate from '%s': %v", path, err)
+	}
+
+	return parseCert(certPEM)
+}
+
+// Issue a certificate for the subject and key of cert, signed by parent
+func crossSign(cert, parent *x509.Certificate, key crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %v", err)
+	}
+
+	notAfter := cert.NotAfter
+	if parent.NotAfter.Before(notAfter) {
+		notAfter = parent.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               cert.Subject,
+		NotBefore:             time.Now().Add(-30 * time.Second),
+		NotAfter:              notAfter,
+		KeyUsage:              cert.KeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          cert.SubjectKeyId,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, cert.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// Unmount all CA generations but the active one, which is moved to the mount
+// of the first generation. Certificates are requested from that path, so
+// clients issue from the active CA once the previous ones are retired. The
+// path is missing for the moment between unmounting the first generation and
+// moving the active one.
+func (p *PKI) Retire() error {
+	if err := p.loadGenerations(); err != nil {
+		return err
+	}
+
+	// an earlier retire can have failed before moving the active generation
+	if len(p.generations) < 2 && p.generation == 1 {
+		return fmt.Errorf("'%s' has no CA generations to retire", p.Path())
+	}
+
+	state, err := p.caState()
+	if err != nil {
+		return err
+	}
+	if state != caReady {
+		return fmt.Errorf("CA of '%s' is not ready, refusing to retire previous generations", p.Path())
+	}
+
+	var result error
+	for _, gen := range p.generations[:len(p.generations)-1] {
+		path := p.generationPath(gen)
+		if err := p.kubernetes.unmount(path); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if err := p.kubernetes.secretsGeneric.deleteCAKeyStore(filepath.Base(path)); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	if result != nil {
+		return result
+	}
+
+	if err := p.moveToFirstGeneration(); err != nil {
+		return err
+	}
+
+	return p.kubernetes.secretsGeneric.deleteCABundleStore(p.pkiName)
+}
+
+// Move the mount of the active generation and its escrowed key to the mount
+// of the first generation, the URLs are written for the new path
+func (p *PKI) moveToFirstGeneration() error {
+	from := p.Path()
+	to := p.generationPath(1)
+
+	if err := p.kubernetes.vaultClient.Sys().Remount(from, to); err != nil {
+		return fmt.Errorf("error moving '%s' to '%s': %v", from, to, err)
+	}
+	p.Log.Infof("Moved CA generation %d of '%s' from '%s' to '%s'", p.generation, p.pkiName, from, to)
+	p.generation = 1
+	p.generations = []int{1}
+
+	key, err := p.kubernetes.secretsGeneric.CAKeyStore(filepath.Base(from))
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		if err := p.kubernetes.secretsGeneric.SetCAKeyStore(filepath.Base(to), key); err != nil {
+			return err
+		}
+		if err := p.kubernetes.secretsGeneric.deleteCAKeyStore(filepath.Base(from)); err != nil {
+			return err
+		}
+	}
+
+	return p.ensureURLs()
+}
+
+// Rotate the CA of a PKI and move roles and policies to the new generation
+func (k *Kubernetes) RotatePKI(pkiName string) error {
+	p, err := k.specPKIByName(pkiName)
+	if err != nil {
+		return err
+	}
+
+	if err := k.PKI(p.Name).Rotate(); err != nil {
+		return err
+	}
+
+	return k.ensurePKIGeneration(p)
+}
+
+// Retire all but the active CA generation of a PKI
+func (k *Kubernetes) RetirePKI(pkiName string) error {
+	p, err := k.specPKIByName(pkiName)
+	if err != nil {
+		return err
+	}
+
+	if err := k.PKI(p.Name).Retire(); err != nil {
+		return err
+	}
+
+	return k.ensurePKIGeneration(p)
+}
+
+// Write roles and policies once the active generation changed
+func (k *Kubernetes) ensurePKIGeneration(p *SpecPKI) error {
+	// other PKIs' generations are needed to write the policies
+	for _, s := range k.Spec().PKIs {
+		if s.Name == p.Name {
+			continue
+		}
+		if err := k.PKI(s.Name).loadGenerations(); err != nil {
+			return err
+		}
+	}
+
+	if err := k.ensurePKIRoles(k.PKI(p.Name), p.Roles); err != nil {
+		return err
+	}
+
+	if err := k.ensurePolicies(); err != nil {
+		return err
+	}
+
+	// node policies and cert auth cover all generations
+	if p.Name == certAuthPKI {
+		if err := k.ensureNodePolicies(); err != nil {
+			return err
+		}
+		return k.ensureCertAuth()
+	}
+
+	return nil
+}
+
+func (k *Kubernetes) specPKIByName(pkiName string) (*SpecPKI, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	for _, p := range k.Spec().PKIs {
+		if p.Name == pkiName {
+			return p, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown pki '%s'", pkiName)
+}
+
+// Expand a policy path, relative to the cluster, of a PKI to the paths of
+// all its mounted generations
+func (k *Kubernetes) pkiGenerationPaths(rel string) []string {
+	parts := strings.SplitN(filepath.Clean(rel), "/", 3)
+	if len(parts) < 2 || parts[0] != "pki" {
+		return []string{filepath.Join(k.Path(), rel)}
+	}
+
+	name, _ := splitPKIGeneration(parts[1])
+	p, ok := k.pkis[name]
+	if !ok || len(p.generations) == 0 {
+		return []string{filepath.Join(k.Path(), rel)}
+	}
+
+	var paths []string
+	for _, path := range p.generationPaths() {
+		paths = append(paths, filepath.Join(append([]string{path}, parts[2:]...)...))
+	}
+	return paths
+}
+
+// Make a policy path relative to the cluster, independent of the PKI
+// generation
+func (k *Kubernetes) relativePKIPath(path string) string {
+	rel, err := filepath.Rel(k.Path(), path)
+	if err != nil {
+		return path
+	}
+
+	parts := strings.SplitN(rel, "/", 3)
+	if len(parts) < 2 || parts[0] != "pki" {
+		return rel
+	}
+	parts[1], _ = splitPKIGeneration(parts[1])
+	return strings.Join(parts, "/")
+}
+
+// Store the CAs of the previous and, if already signed, the active generation
+func (p *PKI) storeCABundle(oldCert *x509.Certificate) error {
+	certs := []*x509.Certificate{oldCert}
+
+	state, err := p.caState()
+	if err != nil {
+		return err
+	}
+	if state == caReady {
+		newCert, err := p.readCACert()
+		if err != nil {
+			return err
+		}
+		certs = append(certs, newCert)
+	}
+
+	return p.kubernetes.secretsGeneric.SetCABundleStore(p.pkiName, encodeCerts(certs...))
+}
+
+func (p *PKI) readCACert() (*x509.Certificate, error) {
+	path := filepath.Join(p.Path(), "cert", "ca")
+
+	s, err := p.kubernetes.vaultClient.Logical().Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ca path '%s': %v", path, err)
+	}
+
+	certPEM, err := secretString(s, "certificate")
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA of '%s': %v", p.Path(), err)
+	}
+
+	return parseCert(certPEM)
+}
+
+func parseCert(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parsePrivateKey(keyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("unsupported PEM block type '%s'", block.Type)
+}
+
+func encodeCerts(certs ...*x509.Certificate) string {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.String()
+}
@@ -0,0 +1,180 @@
+package kubernetes
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestSplitPKIGeneration(t *testing.T) {
+	for mount, exp := range map[string]struct {
+		name string
+		gen  int
+	}{
+		"k8s":           {"k8s", 1},
+		"k8s-api-proxy": {"k8s-api-proxy", 1},
+		"k8s-gen2":      {"k8s", 2},
+		"k8s-gen12":     {"k8s", 12},
+		"k8s-gen1":      {"k8s-gen1", 1},
+		"k8s-gen":       {"k8s-gen", 1},
+	} {
+		name, gen := splitPKIGeneration(mount)
+		if name != exp.name || gen != exp.gen {
+			t.Errorf("unexpected split of '%s', exp=%s/%d got=%s/%d", mount, exp.name, exp.gen, name, gen)
+		}
+	}
+}
+
+func TestPKI_Rotate(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	oldLeaf := issueKubeProxy(t, k, "test/pki/k8s")
+
+	if err := k.RotatePKI("k8s"); err != nil {
+		t.Fatalf("error rotating: %v", err)
+	}
+
+	newLeaf := issueKubeProxy(t, k, "test/pki/k8s-gen2")
+
+	// worker policy has to allow both generations until retired
+	rules, err := vault.Client().Sys().GetPolicy("test/worker")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, path := range []string{"test/pki/k8s/sign/kube-proxy", "test/pki/k8s-gen2/sign/kube-proxy", "test/secrets/ca_bundle_k8s"} {
+		if !strings.Contains(rules, path) {
+			t.Errorf("expected worker policy to contain '%s': %s", path, rules)
+		}
+	}
+
+	plan, err := k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes after rotating:\n%s", plan)
+	}
+
+	// certificates of each generation have to be trusted by the other one
+	bundle, err := k.secretsGeneric.CABundleStore("k8s")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certs := parseCerts(t, bundle)
+	if exp, act := 4, len(certs); exp != act {
+		t.Fatalf("unexpected number of certificates in bundle, exp=%d got=%d", exp, act)
+	}
+	oldCA, newCA, newByOld, oldByNew := certs[0], certs[1], certs[2], certs[3]
+	verifyChain(t, newLeaf, oldCA, newByOld)
+	verifyChain(t, oldLeaf, newCA, oldByNew)
+
+	if err := k.RetirePKI("k8s"); err != nil {
+		t.Fatalf("error retiring: %v", err)
+	}
+
+	// the active generation moves to the path nodes request certificates from
+	if mount, err := GetMountByPath(k.vaultClient, "test/pki/k8s-gen2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if mount != nil {
+		t.Error("expected the active generation to be moved")
+	}
+	verifyChain(t, issueKubeProxy(t, k, "test/pki/k8s"), newCA, newCA)
+	rules, err = vault.Client().Sys().GetPolicy("test/worker")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(rules, `"test/pki/k8s/sign/kube-proxy"`) || strings.Contains(rules, "test/pki/k8s-gen2") {
+		t.Errorf("expected worker policy to only contain the first generation's path: %s", rules)
+	}
+	if bundle, err := k.secretsGeneric.CABundleStore("k8s"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if bundle != "" {
+		t.Error("expected the bundle to be deleted")
+	}
+
+	if err := k.RetirePKI("k8s"); err == nil {
+		t.Error("expected an error retiring without previous generations")
+	}
+
+	// a fresh instance picks up the moved generation
+	k = New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	if exp, act := "test/pki/k8s", k.PKI("k8s").Path(); exp != act {
+		t.Errorf("unexpected active generation, exp=%s got=%s", exp, act)
+	}
+	plan, err = k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes after retiring:\n%s", plan)
+	}
+}
+
+func issueKubeProxy(t *testing.T, k *Kubernetes, path string) *x509.Certificate {
+	s, err := k.vaultClient.Logical().Write(path+"/issue/kube-proxy", map[string]interface{}{
+		"common_name": "system:kube-proxy",
+	})
+	if err != nil {
+		t.Fatalf("error issuing certificate from '%s': %v", path, err)
+	}
+	certPEM, err := secretString(s, "certificate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return cert
+}
+
+func parseCerts(t *testing.T, bundle string) []*x509.Certificate {
+	var certs []*x509.Certificate
+	rest := []byte(bundle)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return certs
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("error parsing bundle: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func verifyChain(t *testing.T, leaf, root, intermediate *x509.Certificate) {
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		t.Errorf("certificate '%s' not trusted by '%s': %v", leaf.Subject.CommonName, root.Subject.CommonName, err)
+	}
+}
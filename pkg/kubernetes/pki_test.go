@@ -1,11 +1,15 @@
 package kubernetes
 
 import (
+	"crypto/x509"
+	"encoding/pem"
 	"testing"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
 )
 
 func TestPKI_Ensure(t *testing.T) {
@@ -91,3 +95,114 @@ func TestPKI_Ensure(t *testing.T) {
 		return
 	}
 }
+
+func TestPKI_Intermediate(t *testing.T) {
+	dev := vault_dev.New()
+	if err := dev.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer dev.Stop()
+
+	// corporate root CA
+	if err := dev.Client().Sys().Mount("corp-pki", &vault.MountInput{Type: "pki"}); err != nil {
+		t.Fatalf("failed to mount: %v", err)
+	}
+	if err := dev.Client().Sys().TuneMount("corp-pki", vault.MountConfigInput{MaxLeaseTTL: "876000h"}); err != nil {
+		t.Fatalf("failed to tune mount: %v", err)
+	}
+	root, err := dev.Client().Logical().Write("corp-pki/root/generate/internal", map[string]interface{}{
+		"common_name": "Corp Root CA",
+		"ttl":         "876000h",
+	})
+	if err != nil {
+		t.Fatalf("failed to generate root CA: %v", err)
+	}
+
+	k := New(dev.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	k.CAMode = CAModeIntermediate
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	csrs, err := k.PendingCSRs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := 4, len(csrs); exp != act {
+		t.Fatalf("unexpected number of pending CSRs, exp=%d got=%d", exp, act)
+	}
+
+	plan, err := k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes while CSRs are pending:\n%s", plan)
+	}
+
+	// a certificate not matching the CSR has to be refused
+	rootCert, err := secretString(root, "certificate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.ImportSignedCA("k8s", rootCert); err == nil {
+		t.Error("expected an error importing a certificate not matching the CSR")
+	}
+
+	// sign outside of setup
+	s, err := dev.Client().Logical().Write("corp-pki/root/sign-intermediate", map[string]interface{}{
+		"csr":    csrs["k8s"],
+		"format": "pem_bundle",
+	})
+	if err != nil {
+		t.Fatalf("failed to sign CSR: %v", err)
+	}
+	cert, err := secretString(s, "certificate")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.ImportSignedCA("k8s", cert); err != nil {
+		t.Fatalf("unexpected error importing signed certificate: %v", err)
+	}
+
+	// sign the rest using the parent mount
+	k.CAParent = "corp-pki"
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	csrs, err = k.PendingCSRs()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := 0, len(csrs); exp != act {
+		t.Errorf("unexpected number of pending CSRs, exp=%d got=%d", exp, act)
+	}
+
+	for _, name := range []string{"etcd-k8s", "etcd-overlay", "k8s", "k8s-api-proxy"} {
+		s, err := dev.Client().Logical().Read("test/pki/" + name + "/cert/ca")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		caCert, err := secretString(s, "certificate")
+		if err != nil {
+			t.Fatalf("unexpected error reading CA of '%s': %v", name, err)
+		}
+		if exp, act := "Corp Root CA", certIssuer(t, caCert); exp != act {
+			t.Errorf("unexpected issuer of '%s' CA, exp=%s got=%s", name, exp, act)
+		}
+	}
+}
+
+func certIssuer(t *testing.T, certPEM string) string {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatalf("certificate is not PEM encoded: %s", certPEM)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("error parsing certificate: %v", err)
+	}
+	return cert.Issuer.CommonName
+}
@@ -0,0 +1,689 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	vault "github.com/hashicorp/vault/api"
+)
+
+const FlagPlan = "plan"
+const FlagPlanFormat = "plan-format"
+
+const PlanFormatHuman = "human"
+const PlanFormatJSON = "json"
+
+const ActionCreate = "create"
+const ActionUpdate = "update"
+const ActionNoop = "no-op"
+
+const KindMount = "mount"
+const KindTune = "tune"
+const KindCA = "ca"
+const KindSecret = "secret"
+const KindRole = "role"
+const KindPolicy = "policy"
+const KindTokenRole = "token-role"
+const KindInitToken = "init-token"
+const KindAuth = "auth"
+const KindAppRole = "approle"
+const KindCertAuth = "cert-auth"
+const KindURLs = "urls"
+const KindCRL = "crl"
+
+// A change Ensure would make to a single vault object
+type Change struct {
+	Kind   string       `json:"kind"`
+	Path   string       `json:"path"`
+	Action string       `json:"action"`
+	Fields []*FieldDiff `json:"fields,omitempty"`
+}
+
+// A single field that differs between vault and the desired state
+type FieldDiff struct {
+	Field   string      `json:"field"`
+	Current interface{} `json:"current"`
+	Desired interface{} `json:"desired"`
+}
+
+// All changes Ensure would make to a cluster
+type Plan struct {
+	ClusterID string    `json:"clusterID"`
+	Changes   []*Change `json:"changes"`
+}
+
+// Compute the changes Ensure would make, without writing anything to vault
+func (k *Kubernetes) Plan() (*Plan, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+	if err := k.checkNodeIdentity(); err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{ClusterID: k.clusterID}
+	var result error
+
+	for _, backend := range k.backends() {
+		changes, err := backend.Plan()
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("backend %s: %s", backend.Path(), err))
+			continue
+		}
+		plan.Changes = append(plan.Changes, changes...)
+	}
+
+	for _, p := range k.Spec().PKIs {
+		for _, role := range p.Roles {
+			change, err := k.PKI(p.Name).planRole(k.pkiRole(role))
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			plan.Changes = append(plan.Changes, change)
+		}
+	}
+
+	for _, p := range k.Spec().Policies {
+		change, err := k.planPolicy(k.policy(p))
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	if k.Bootstrap == BootstrapAppRole {
+		changes, err := k.planAppRoles()
+		if err != nil {
+			result = multierror.Append(result, err)
+		}
+		plan.Changes = append(plan.Changes, changes...)
+	} else {
+		for _, initToken := range k.specInitTokens() {
+			changes, err := initToken.Plan()
+			if err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			plan.Changes = append(plan.Changes, changes...)
+		}
+	}
+
+	changes, err := k.planCertAuth()
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	plan.Changes = append(plan.Changes, changes...)
+
+	return plan, result
+}
+
+func (k *Kubernetes) planPolicy(p *Policy) (*Change, error) {
+	current, err := k.vaultClient.Sys().GetPolicy(p.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy '%s': %v", p.Name, err)
+	}
+
+	change := &Change{
+		Kind:   KindPolicy,
+		Path:   p.Name,
+		Action: ActionNoop,
+	}
+
+	if current == "" {
+		change.Action = ActionCreate
+		return change, nil
+	}
+
+	currentPolicy, err := parsePolicy(p.Name, current)
+	if err != nil {
+		// rules vault accepted but we can't parse get replaced as a whole
+		change.Action = ActionUpdate
+		change.Fields = []*FieldDiff{
+			&FieldDiff{Field: "rules", Current: current, Desired: p.Policy()},
+		}
+		return change, nil
+	}
+
+	change.Fields = diffPolicies(p, currentPolicy)
+	if len(change.Fields) > 0 {
+		change.Action = ActionUpdate
+	}
+
+	return change, nil
+}
+
+// Compare the capabilities and constraints of every path of two policies
+func diffPolicies(desired, current *Policy) []*FieldDiff {
+	desiredCaps := desired.capabilities()
+	currentCaps := current.capabilities()
+
+	var paths []string
+	for path := range desiredCaps {
+		paths = append(paths, path)
+	}
+	for path := range currentCaps {
+		if _, ok := desiredCaps[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var fields []*FieldDiff
+	for _, path := range paths {
+		d, c := desiredCaps[path], currentCaps[path]
+		if strings.Join(d, ",") == strings.Join(c, ",") {
+			continue
+		}
+
+		field := &FieldDiff{Field: fmt.Sprintf("path \"%s\"", path)}
+		if d != nil {
+			field.Desired = d
+		}
+		if c != nil {
+			field.Current = c
+		}
+		fields = append(fields, field)
+	}
+
+	// constraints of paths both policies grant
+	desiredConstraints := desired.constraints()
+	currentConstraints := current.constraints()
+	for _, path := range paths {
+		if desiredCaps[path] == nil || currentCaps[path] == nil {
+			continue
+		}
+
+		var keys []string
+		for key := range desiredConstraints[path] {
+			keys = append(keys, key)
+		}
+		for key := range currentConstraints[path] {
+			if _, ok := desiredConstraints[path][key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			d, c := desiredConstraints[path][key], currentConstraints[path][key]
+			if d == c {
+				continue
+			}
+
+			field := &FieldDiff{Field: fmt.Sprintf("path \"%s\" %s", path, key)}
+			if d != "" {
+				field.Desired = d
+			}
+			if c != "" {
+				field.Current = c
+			}
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+func (p *PKI) Plan() ([]*Change, error) {
+	if err := p.loadGenerations(); err != nil {
+		return nil, err
+	}
+
+	mount, err := GetMountByPath(p.kubernetes.vaultClient, p.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	tuneFields := []*FieldDiff{
+		&FieldDiff{Field: "default_lease_ttl", Desired: int(p.DefaultLeaseTTL.Seconds())},
+		&FieldDiff{Field: "max_lease_ttl", Desired: int(p.MaxLeaseTTL.Seconds())},
+	}
+
+	if mount == nil {
+		changes := []*Change{
+			&Change{Kind: KindMount, Path: p.Path(), Action: ActionCreate, Fields: []*FieldDiff{
+				&FieldDiff{Field: "type", Desired: "pki"},
+			}},
+			&Change{Kind: KindTune, Path: p.Path(), Action: ActionCreate, Fields: tuneFields},
+			p.planCA(caMissing),
+		}
+		if p.urlsData(p.Path()) != nil {
+			changes = append(changes, &Change{Kind: KindURLs, Path: filepath.Join(p.Path(), "config", "urls"), Action: ActionCreate})
+		}
+		if p.crlData() != nil {
+			changes = append(changes, &Change{Kind: KindCRL, Path: filepath.Join(p.Path(), "config", "crl"), Action: ActionCreate})
+		}
+		return changes, nil
+	}
+
+	if mount.Type != "pki" {
+		return nil, fmt.Errorf("Mount '%s' already existing with wrong type '%s'", p.Path(), mount.Type)
+	}
+
+	changes := []*Change{
+		&Change{Kind: KindMount, Path: p.Path(), Action: ActionNoop},
+	}
+
+	tune := &Change{Kind: KindTune, Path: p.Path(), Action: ActionNoop}
+	tuneFields[0].Current = mount.Config.DefaultLeaseTTL
+	tuneFields[1].Current = mount.Config.MaxLeaseTTL
+	for _, f := range tuneFields {
+		if f.Current != f.Desired {
+			tune.Action = ActionUpdate
+			tune.Fields = append(tune.Fields, f)
+		}
+	}
+	changes = append(changes, tune)
+
+	state, err := p.caState()
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, p.planCA(state))
+
+	urls, err := p.planURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(changes, urls...), nil
+}
+
+// Compare the URLs and CRL config of all CA generations
+func (p *PKI) planURLs() ([]*Change, error) {
+	var changes []*Change
+	for _, path := range p.generationPaths() {
+		for _, c := range []struct {
+			kind, path string
+			data       map[string]interface{}
+		}{
+			{KindURLs, filepath.Join(path, "config", "urls"), p.urlsData(path)},
+			{KindCRL, filepath.Join(path, "config", "crl"), p.crlData()},
+		} {
+			if c.data == nil {
+				continue
+			}
+			s, err := p.kubernetes.vaultClient.Logical().Read(c.path)
+			if err != nil {
+				return nil, fmt.Errorf("error reading '%s': %v", c.path, err)
+			}
+			changes = append(changes, planData(c.kind, c.path, c.data, s))
+		}
+	}
+
+	return changes, nil
+}
+
+func (p *PKI) planCA(state caState) *Change {
+	ca := p.kubernetes.specCA(p.pkiName)
+	change := &Change{Kind: KindCA, Path: filepath.Join(p.Path(), "cert", "ca"), Action: ActionNoop}
+
+	switch state {
+	case caMissing:
+		change.Action = ActionCreate
+		change.Fields = []*FieldDiff{&FieldDiff{Field: "mode", Desired: ca.Mode}}
+		if ca.Parent != "" {
+			change.Fields = append(change.Fields, &FieldDiff{Field: "parent", Desired: ca.Parent})
+		}
+	case caPending:
+		// only a parent mount lets setup complete a pending CA
+		if ca.Parent != "" {
+			change.Action = ActionUpdate
+			change.Fields = []*FieldDiff{&FieldDiff{Field: "state", Current: "pending", Desired: "signed"}}
+		}
+	}
+
+	return change
+}
+
+// Defaults vault gives the fields of PKI roles, which widen the names or
+// usages of certificates when changed
+var pkiRoleDefaults = map[string]interface{}{
+	"allow_any_name":          false,
+	"allow_bare_domains":      false,
+	"allow_base_domain":       false,
+	"allow_glob_domains":      false,
+	"allow_ip_sans":           true,
+	"allow_localhost":         true,
+	"allow_subdomains":        false,
+	"allow_token_displayname": false,
+	"allowed_domains":         "",
+	"allowed_other_sans":      "",
+	"allowed_uri_sans":        "",
+	"client_flag":             true,
+	"code_signing_flag":       false,
+	"email_protection_flag":   false,
+	"enforce_hostnames":       true,
+	"key_usage":               "DigitalSignature,KeyAgreement,KeyEncipherment",
+	"organization":            "",
+	"ou":                      "",
+	"server_flag":             true,
+	"use_csr_common_name":     true,
+	"use_csr_sans":            true,
+}
+
+func (p *PKI) planRole(role *pkiRole) (*Change, error) {
+	path := filepath.Join(p.Path(), "roles", role.Name)
+
+	s, err := p.kubernetes.vaultClient.Logical().Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading role '%s': %v", path, err)
+	}
+
+	// writing a role resets the fields it leaves out, so they have to be at
+	// their defaults. Fields older vaults don't know are skipped.
+	desired := map[string]interface{}{}
+	if s != nil {
+		for key, value := range pkiRoleDefaults {
+			if _, ok := s.Data[key]; ok {
+				desired[key] = value
+			}
+		}
+	}
+	for key, value := range role.Data {
+		desired[key] = value
+	}
+
+	return planData(KindRole, path, desired, s), nil
+}
+
+func (g *Generic) Plan() ([]*Change, error) {
+	mount, err := GetMountByPath(g.kubernetes.vaultClient, g.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKeyPath := filepath.Join(g.Path(), "service-accounts")
+
+	desired := g.kubernetes.SecretsKVVersion
+
+	if mount == nil {
+		fields := []*FieldDiff{
+			&FieldDiff{Field: "type", Desired: "generic"},
+		}
+		if desired == 2 {
+			fields = []*FieldDiff{
+				&FieldDiff{Field: "type", Desired: "kv"},
+				&FieldDiff{Field: "version", Desired: 2},
+			}
+		}
+		return []*Change{
+			&Change{Kind: KindMount, Path: g.Path(), Action: ActionCreate, Fields: fields},
+			&Change{Kind: KindSecret, Path: rsaKeyPath, Action: ActionCreate},
+		}, nil
+	}
+
+	version, err := g.KVVersion()
+	if err != nil {
+		return nil, err
+	}
+	if desired == 1 && version == 2 {
+		return nil, fmt.Errorf("secrets mount '%s' is KV version 2, downgrading to version 1 is not supported", g.Path())
+	}
+
+	mountChange := &Change{Kind: KindMount, Path: g.Path(), Action: ActionNoop}
+	if desired == 2 && version == 1 {
+		mountChange.Action = ActionUpdate
+		mountChange.Fields = []*FieldDiff{
+			&FieldDiff{Field: "version", Current: 1, Desired: 2},
+		}
+	}
+	changes := []*Change{mountChange}
+
+	keys, secret, err := g.readServiceAccountKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil {
+		return append(changes, &Change{Kind: KindSecret, Path: rsaKeyPath, Action: ActionCreate}), nil
+	}
+
+	keys.Previous = g.pruneServiceAccountKeys(keys.Previous)
+	data, err := keys.data()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(changes, planData(KindSecret, rsaKeyPath, data, secret)), nil
+}
+
+func (i *InitToken) Plan() ([]*Change, error) {
+	var changes []*Change
+
+	s, err := i.kubernetes.vaultClient.Logical().Read(i.Path())
+	if err != nil {
+		return nil, fmt.Errorf("error reading token role '%s': %v", i.Path(), err)
+	}
+	changes = append(changes, planData(KindTokenRole, i.Path(), i.tokenRoleData(), s))
+
+	change, err := i.kubernetes.planPolicy(i.initTokenPolicy())
+	if err != nil {
+		return nil, err
+	}
+	changes = append(changes, change)
+
+	token, err := i.secretsGeneric().InitTokenStore(i.Role)
+	if err != nil {
+		return nil, err
+	}
+	change = &Change{Kind: KindInitToken, Path: i.secretsGeneric().initTokenPath(i.Role), Action: ActionNoop}
+	if token == "" {
+		change.Action = ActionCreate
+	}
+
+	return append(changes, change), nil
+}
+
+// Compare the data that would be written against what vault returns
+func planData(kind, path string, desired map[string]interface{}, current *vault.Secret) *Change {
+	change := &Change{
+		Kind:   kind,
+		Path:   path,
+		Action: ActionNoop,
+	}
+
+	if current == nil || current.Data == nil {
+		change.Action = ActionCreate
+		return change
+	}
+
+	change.Fields = diffData(desired, current.Data)
+	if len(change.Fields) > 0 {
+		change.Action = ActionUpdate
+	}
+
+	return change
+}
+
+// Field level differences of the desired keys, values are normalised before
+// they get compared
+func diffData(desired, current map[string]interface{}) []*FieldDiff {
+	var keys []string
+	for key := range desired {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []*FieldDiff
+	for _, key := range keys {
+		if !equalValues(desired[key], current[key]) {
+			fields = append(fields, &FieldDiff{
+				Field:   key,
+				Current: current[key],
+				Desired: desired[key],
+			})
+		}
+	}
+
+	return fields
+}
+
+func equalValues(desired, current interface{}) bool {
+	d := normaliseValue(desired)
+	c := normaliseValue(current)
+
+	if reflect.DeepEqual(d, c) {
+		return true
+	}
+
+	// an empty string and an empty list are the same
+	if isEmptyValue(d) && isEmptyValue(c) {
+		return true
+	}
+
+	// a single element list and a string are the same
+	if ds, ok := d.([]string); ok {
+		if cs, ok := c.(string); ok {
+			return reflect.DeepEqual(ds, normaliseValue(cs))
+		}
+	}
+	if cs, ok := c.([]string); ok {
+		if ds, ok := d.(string); ok {
+			return reflect.DeepEqual(cs, normaliseValue(ds))
+		}
+	}
+
+	return false
+}
+
+func isEmptyValue(v interface{}) bool {
+	switch value := v.(type) {
+	case string:
+		return value == ""
+	case []string:
+		return len(value) == 0
+	}
+	return false
+}
+
+// Bring the representations vault uses for a value into one form: durations
+// and numbers become float64 seconds, comma separated strings and lists
+// become sorted string slices
+func normaliseValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case bool:
+		return value
+	case int:
+		return float64(value)
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	case json.Number:
+		f, err := value.Float64()
+		if err != nil {
+			return value.String()
+		}
+		return f
+	case time.Duration:
+		return value.Seconds()
+	case []string:
+		return normaliseList(value)
+	case []interface{}:
+		list := make([]string, len(value))
+		for pos, elem := range value {
+			list[pos] = fmt.Sprintf("%v", elem)
+		}
+		return normaliseList(list)
+	case string:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		if d, err := time.ParseDuration(value); err == nil {
+			return d.Seconds()
+		}
+		if strings.Contains(value, ",") {
+			return normaliseList(strings.Split(value, ","))
+		}
+		return value
+	}
+
+	return fmt.Sprintf("%v", v)
+}
+
+func normaliseList(list []string) interface{} {
+	var out []string
+	for _, elem := range list {
+		if elem = strings.TrimSpace(elem); elem != "" {
+			out = append(out, elem)
+		}
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (p *Plan) HasChanges() bool {
+	for _, c := range p.Changes {
+		if c.Action != ActionNoop {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// Human readable form of the plan
+func (p *Plan) String() string {
+	var buf bytes.Buffer
+	count := map[string]int{}
+
+	fmt.Fprintf(&buf, "Plan for cluster '%s':\n", p.ClusterID)
+	for _, c := range p.Changes {
+		count[c.Action]++
+
+		symbol := "="
+		switch c.Action {
+		case ActionCreate:
+			symbol = "+"
+		case ActionUpdate:
+			symbol = "~"
+		}
+		fmt.Fprintf(&buf, "  %s %-11s %s\n", symbol, c.Kind, c.Path)
+
+		for _, f := range c.Fields {
+			fmt.Fprintf(&buf, "      %s: %s => %s\n", f.Field, formatValue(f.Current), formatValue(f.Desired))
+		}
+	}
+	fmt.Fprintf(&buf, "%d to create, %d to update, %d unchanged.\n", count[ActionCreate], count[ActionUpdate], count[ActionNoop])
+
+	return buf.String()
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	if s, ok := v.(string); ok {
+		if strings.Contains(s, "\n") {
+			return "\n" + indent(s, "        ")
+		}
+		return strconv.Quote(s)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for pos, line := range lines {
+		lines[pos] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
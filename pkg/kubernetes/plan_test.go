@@ -0,0 +1,109 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/golang/mock/gomock"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestPlan_Empty(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	k := fv.Kubernetes()
+
+	fv.fakeSys.EXPECT().ListMounts().AnyTimes().Return(nil, nil)
+	fv.fakeSys.EXPECT().ListAuth().AnyTimes().Return(nil, nil)
+	fv.fakeSys.EXPECT().GetPolicy(gomock.Any()).AnyTimes().Return("", nil)
+	fv.fakeLogical.EXPECT().Read(gomock.Any()).AnyTimes().Return(nil, nil)
+
+	plan, err := k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !plan.HasChanges() {
+		t.Error("expected plan to have changes")
+	}
+
+	for _, c := range plan.Changes {
+		if c.Action != ActionCreate {
+			t.Errorf("unexpected action for %s '%s', exp=%s got=%s", c.Kind, c.Path, ActionCreate, c.Action)
+		}
+	}
+
+	dat, err := plan.JSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := json.Unmarshal(dat, &Plan{}); err != nil {
+		t.Errorf("plan is not valid JSON: %v", err)
+	}
+}
+
+func TestDiffData(t *testing.T) {
+	desired := map[string]interface{}{
+		"ttl":              "2592000s",
+		"allowed_domains":  "kubelet,system:node",
+		"allowed_policies": "b,a",
+		"server_flag":      true,
+	}
+
+	current := map[string]interface{}{
+		"ttl":              json.Number("2592000"),
+		"allowed_domains":  []interface{}{"system:node", "kubelet"},
+		"allowed_policies": "a,b",
+		"server_flag":      false,
+		"not_managed":      "value",
+	}
+
+	fields := diffData(desired, current)
+	if exp, act := 1, len(fields); exp != act {
+		t.Fatalf("unexpected number of differences, exp=%d got=%d: %+v", exp, act, fields)
+	}
+	if exp, act := "server_flag", fields[0].Field; exp != act {
+		t.Errorf("unexpected field, exp=%s got=%s", exp, act)
+	}
+}
+
+func TestPlan_AfterEnsure(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test-cluster")
+
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	plan, err := k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("expected no changes after ensure:\n%s", plan)
+	}
+
+	// change a role by hand
+	if _, err := vault.Client().Logical().Write("test-cluster/pki/k8s/roles/kubelet", map[string]interface{}{
+		"allowed_domains": "evil",
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	plan, err = k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(plan.String(), "~ role        test-cluster/pki/k8s/roles/kubelet") {
+		t.Errorf("expected kubelet role to be updated:\n%s", plan)
+	}
+}
@@ -2,7 +2,14 @@ package kubernetes
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
 )
 
 type Policy struct {
@@ -14,22 +21,69 @@ type Policy struct {
 type policyPath struct {
 	path         string
 	capabilities []string
+	// Deny the path, overriding the capabilities of all other policies
+	deny bool
+	// Parameters requests may set and their allowed values, an empty list
+	// allows any value. Without any, all parameters are allowed.
+	allowedParameters map[string][]string
+	// Parameters requests must not set, or the values they must not set
+	// them to
+	deniedParameters map[string][]string
+	// Parameters requests have to set, needs vault 0.9 or later
+	requiredParameters []string
+	// Bounds of the TTL requests have to wrap their responses with
+	minWrappingTTL time.Duration
+	maxWrappingTTL time.Duration
 }
 
-func (pp *policyPath) String() string {
-	capabilities := make([]string, len(pp.capabilities))
-	for pos, cap := range pp.capabilities {
-		capabilities[pos] = fmt.Sprintf(`"%s"`, cap)
+// Quote values as HCL strings, escaping quotes and backslashes of spec
+// supplied values
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for pos, value := range values {
+		quoted[pos] = strconv.Quote(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// Render parameters and their values as an HCL object, sorted by parameter
+func parametersHCL(name string, params map[string][]string) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	var keys []string
+	for key := range params {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	return fmt.Sprintf(
-		`path "%s" {
-  capabilities = [%s]
+	output := fmt.Sprintf("  %s = {\n", name)
+	for _, key := range keys {
+		output += fmt.Sprintf("    %s = %s\n", strconv.Quote(key), quoteList(params[key]))
+	}
+	return output + "  }\n"
 }
-`,
-		pp.path,
-		strings.Join(capabilities, ", "),
-	)
+
+func (pp *policyPath) String() string {
+	capabilities := pp.capabilities
+	if pp.deny {
+		capabilities = []string{"deny"}
+	}
+
+	output := fmt.Sprintf("path %s {\n  capabilities = %s\n", strconv.Quote(pp.path), quoteList(capabilities))
+	output += parametersHCL("allowed_parameters", pp.allowedParameters)
+	output += parametersHCL("denied_parameters", pp.deniedParameters)
+	if len(pp.requiredParameters) > 0 {
+		output += fmt.Sprintf("  required_parameters = %s\n", quoteList(pp.requiredParameters))
+	}
+	if pp.minWrappingTTL > 0 {
+		output += fmt.Sprintf("  min_wrapping_ttl = \"%ds\"\n", int(pp.minWrappingTTL.Seconds()))
+	}
+	if pp.maxWrappingTTL > 0 {
+		output += fmt.Sprintf("  max_wrapping_ttl = \"%ds\"\n", int(pp.maxWrappingTTL.Seconds()))
+	}
+	return output + "}\n"
 }
 
 func (p *Policy) Policy() string {
@@ -39,3 +93,205 @@ func (p *Policy) Policy() string {
 	}
 	return strings.Join(policies, "\n")
 }
+
+// Parse the rules of a vault policy, old style 'policy' values are converted
+// to their capabilities
+func parsePolicy(name, rules string) (*Policy, error) {
+	root, err := hcl.Parse(rules)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing policy '%s': %v", name, err)
+	}
+
+	list, ok := root.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("error parsing policy '%s': no root object", name)
+	}
+
+	p := &Policy{Name: name}
+	for _, item := range list.Filter("path").Items {
+		if len(item.Keys) == 0 {
+			return nil, fmt.Errorf("error parsing policy '%s': path without a name", name)
+		}
+		path, ok := item.Keys[0].Token.Value().(string)
+		if !ok {
+			return nil, fmt.Errorf("error parsing policy '%s': invalid path", name)
+		}
+
+		var rules struct {
+			Policy             string                   `hcl:"policy"`
+			Capabilities       []string                 `hcl:"capabilities"`
+			AllowedParameters  []map[string]interface{} `hcl:"allowed_parameters"`
+			DeniedParameters   []map[string]interface{} `hcl:"denied_parameters"`
+			RequiredParameters []string                 `hcl:"required_parameters"`
+			MinWrappingTTL     interface{}              `hcl:"min_wrapping_ttl"`
+			MaxWrappingTTL     interface{}              `hcl:"max_wrapping_ttl"`
+		}
+		if err := hcl.DecodeObject(&rules, item.Val); err != nil {
+			return nil, fmt.Errorf("error parsing policy '%s' path '%s': %v", name, path, err)
+		}
+
+		capabilities := rules.Capabilities
+		switch rules.Policy {
+		case "deny":
+			capabilities = append(capabilities, "deny")
+		case "read":
+			capabilities = append(capabilities, "read", "list")
+		case "write":
+			capabilities = append(capabilities, "create", "read", "update", "delete", "list")
+		case "sudo":
+			capabilities = append(capabilities, "create", "read", "update", "delete", "list", "sudo")
+		}
+
+		pp := &policyPath{
+			path:               path,
+			requiredParameters: rules.RequiredParameters,
+		}
+		for _, c := range capabilities {
+			if c == "deny" {
+				pp.deny = true
+			}
+		}
+		if !pp.deny {
+			pp.capabilities = capabilities
+		}
+
+		var result error
+		if pp.allowedParameters, err = parseParameters(rules.AllowedParameters); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if pp.deniedParameters, err = parseParameters(rules.DeniedParameters); err != nil {
+			result = multierror.Append(result, err)
+		}
+		if pp.minWrappingTTL, err = parseWrappingTTL(rules.MinWrappingTTL); err != nil {
+			result = multierror.Append(result, fmt.Errorf("invalid min_wrapping_ttl: %v", err))
+		}
+		if pp.maxWrappingTTL, err = parseWrappingTTL(rules.MaxWrappingTTL); err != nil {
+			result = multierror.Append(result, fmt.Errorf("invalid max_wrapping_ttl: %v", err))
+		}
+		if result != nil {
+			return nil, fmt.Errorf("error parsing policy '%s' path '%s': %v", name, path, result)
+		}
+
+		p.Policies = append(p.Policies, pp)
+	}
+
+	return p, nil
+}
+
+// Parameters decode as a list of objects, mapping a parameter to its values
+func parseParameters(objects []map[string]interface{}) (map[string][]string, error) {
+	if len(objects) == 0 {
+		return nil, nil
+	}
+
+	params := map[string][]string{}
+	for _, object := range objects {
+		for key, value := range object {
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("values of parameter '%s' are not a list", key)
+			}
+			list := []string{}
+			for _, v := range values {
+				list = append(list, fmt.Sprintf("%v", v))
+			}
+			params[key] = list
+		}
+	}
+
+	return params, nil
+}
+
+// Wrapping TTLs are either seconds or a duration string
+func parseWrappingTTL(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case string:
+		if v == "" {
+			return 0, nil
+		}
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return time.Duration(seconds) * time.Second, nil
+		}
+		return time.ParseDuration(v)
+	}
+	return 0, fmt.Errorf("unexpected value '%v'", value)
+}
+
+// Render parameters and their sorted values, sorted by parameter
+func canonicalParameters(params map[string][]string) string {
+	var output []string
+	for key, values := range params {
+		sorted := append([]string{}, values...)
+		sort.Strings(sorted)
+		output = append(output, fmt.Sprintf("%s=%s", key, quoteList(sorted)))
+	}
+	sort.Strings(output)
+	return strings.Join(output, " ")
+}
+
+// Map every path to its constraints besides the capabilities, keyed by their
+// name in the rules. Values are rendered independent of their order, unset
+// constraints are left out.
+func (p *Policy) constraints() map[string]map[string]string {
+	output := map[string]map[string]string{}
+	for _, pp := range p.Policies {
+		c := map[string]string{}
+		if len(pp.allowedParameters) > 0 {
+			c["allowed_parameters"] = canonicalParameters(pp.allowedParameters)
+		}
+		if len(pp.deniedParameters) > 0 {
+			c["denied_parameters"] = canonicalParameters(pp.deniedParameters)
+		}
+		if len(pp.requiredParameters) > 0 {
+			sorted := append([]string{}, pp.requiredParameters...)
+			sort.Strings(sorted)
+			c["required_parameters"] = quoteList(sorted)
+		}
+		if pp.minWrappingTTL > 0 {
+			c["min_wrapping_ttl"] = pp.minWrappingTTL.String()
+		}
+		if pp.maxWrappingTTL > 0 {
+			c["max_wrapping_ttl"] = pp.maxWrappingTTL.String()
+		}
+
+		// paths given more than once are merged
+		if output[pp.path] == nil {
+			output[pp.path] = map[string]string{}
+		}
+		for key, value := range c {
+			output[pp.path][key] = value
+		}
+	}
+	return output
+}
+
+// Map every path to its sorted capabilities, paths given more than once
+// are merged. Denied paths only have the capability deny.
+func (p *Policy) capabilities() map[string][]string {
+	output := map[string][]string{}
+	denied := map[string]bool{}
+	for _, pp := range p.Policies {
+		if pp.deny {
+			denied[pp.path] = true
+		}
+		seen := map[string]bool{}
+		for _, c := range output[pp.path] {
+			seen[c] = true
+		}
+		for _, c := range pp.capabilities {
+			if !seen[c] {
+				seen[c] = true
+				output[pp.path] = append(output[pp.path], c)
+			}
+		}
+		sort.Strings(output[pp.path])
+	}
+	for path := range denied {
+		output[path] = []string{"deny"}
+	}
+	return output
+}
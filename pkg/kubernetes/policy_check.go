@@ -0,0 +1,181 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const FlagPolicyDestDir = "dest-dir"
+const FlagPolicyTokenRole = "token-role"
+const FlagPolicyPath = "path"
+
+// The policies setup writes for the cluster: the ones of the spec and,
+// when nodes bootstrap with init tokens, the policies creating their tokens
+func (k *Kubernetes) Policies() []*Policy {
+	var policies []*Policy
+	for _, p := range k.Spec().Policies {
+		policies = append(policies, k.policy(p))
+	}
+	if k.Bootstrap != BootstrapAppRole {
+		for _, initToken := range k.specInitTokens() {
+			policies = append(policies, initToken.initTokenPolicy())
+		}
+	}
+	return policies
+}
+
+// Write the rules of all policies setup writes to files named after the
+// policies below dir, e.g. '<dir>/<cluster>/worker.hcl'. Returns the paths
+// of the files.
+func (k *Kubernetes) RenderPolicies(dir string) ([]string, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	var paths []string
+	for _, p := range k.Policies() {
+		path := filepath.Join(dir, p.Name+".hcl")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("error creating directory for policy '%s': %v", p.Name, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(p.Policy()), 0644); err != nil {
+			return nil, fmt.Errorf("error writing policy '%s' to '%s': %v", p.Name, path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// The result of evaluating policies for a path
+type PolicyCheck struct {
+	Path string `json:"path"`
+	// Rule is the path of the rule matching the path, empty if there is none
+	Rule string `json:"rule"`
+	// Policies having the matching rule
+	Policies     []string `json:"policies"`
+	Capabilities []string `json:"capabilities"`
+	// Constraints of the matching rule, keyed by policy
+	Constraints map[string]map[string]string `json:"constraints,omitempty"`
+}
+
+func (c *PolicyCheck) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "path          %s\n", c.Path)
+	if c.Rule == "" {
+		fmt.Fprintf(&buf, "rule          <none>\n")
+	} else {
+		fmt.Fprintf(&buf, "rule          %s\n", c.Rule)
+		fmt.Fprintf(&buf, "policies      %s\n", strings.Join(c.Policies, ", "))
+	}
+	fmt.Fprintf(&buf, "capabilities  %s\n", strings.Join(c.Capabilities, ", "))
+
+	for _, policy := range c.Policies {
+		var keys []string
+		for key := range c.Constraints[policy] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "  %s %s = %s\n", policy, key, c.Constraints[policy][key])
+		}
+	}
+
+	return buf.String()
+}
+
+// Evaluate the policies tokens of an init token role get for a path,
+// without vault
+func (k *Kubernetes) CheckPolicies(tokenRole, path string) (*PolicyCheck, error) {
+	// token roles are named after the cluster
+	role := strings.TrimPrefix(tokenRole, k.clusterID+"-")
+
+	s, err := k.specInitToken(role)
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := map[string]*Policy{}
+	for _, p := range k.Policies() {
+		byRole[p.Role] = p
+	}
+
+	var policies []*Policy
+	for _, name := range s.Policies {
+		p, ok := byRole[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown policy '%s' of token role '%s'", name, role)
+		}
+		policies = append(policies, p)
+	}
+
+	return checkPolicies(policies, path), nil
+}
+
+// Evaluate policies for a path like vault does: rules of the same path are
+// merged across policies, a rule for the exact path wins over globs, and of
+// the globs, paths ending in '*', the longest matching prefix wins. Without
+// a matching rule, or if a matching rule denies, the path is denied.
+func checkPolicies(policies []*Policy, path string) *PolicyCheck {
+	path = strings.TrimPrefix(path, "/")
+	check := &PolicyCheck{
+		Path:         path,
+		Capabilities: []string{"deny"},
+	}
+
+	// find the rule
+	for _, p := range policies {
+		for rule := range p.capabilities() {
+			if rule == path {
+				check.Rule = rule
+				break
+			}
+			prefix := strings.TrimSuffix(rule, "*")
+			if prefix == rule || !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			if check.Rule != path && (check.Rule == "" || len(prefix) > len(strings.TrimSuffix(check.Rule, "*"))) {
+				check.Rule = rule
+			}
+		}
+	}
+	if check.Rule == "" {
+		return check
+	}
+
+	// merge the capabilities of the policies with the rule
+	seen := map[string]bool{}
+	var capabilities []string
+	for _, p := range policies {
+		caps, ok := p.capabilities()[check.Rule]
+		if !ok {
+			continue
+		}
+
+		check.Policies = append(check.Policies, p.Name)
+		for _, c := range caps {
+			if !seen[c] {
+				seen[c] = true
+				capabilities = append(capabilities, c)
+			}
+		}
+		if constraints := p.constraints()[check.Rule]; len(constraints) > 0 {
+			if check.Constraints == nil {
+				check.Constraints = map[string]map[string]string{}
+			}
+			check.Constraints[p.Name] = constraints
+		}
+	}
+	if !seen["deny"] {
+		sort.Strings(capabilities)
+		check.Capabilities = capabilities
+	}
+
+	return check
+}
@@ -0,0 +1,109 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckPolicies(t *testing.T) {
+	a := &Policy{
+		Name: "a",
+		Policies: []*policyPath{
+			&policyPath{path: "secret/*", capabilities: []string{"read"}},
+			&policyPath{path: "secret/team/*", capabilities: []string{"list"}},
+			&policyPath{path: "secret/team/key", capabilities: []string{"update"}},
+			&policyPath{path: "secret/other/*", deny: true},
+		},
+	}
+	b := &Policy{
+		Name: "b",
+		Policies: []*policyPath{
+			&policyPath{path: "secret/team/*", capabilities: []string{"create"}},
+			&policyPath{path: "secret/other/*", capabilities: []string{"read"}},
+		},
+	}
+
+	for _, c := range []struct {
+		path, rule, capabilities string
+	}{
+		{"secret/foo", "secret/*", "read"},
+		// the longest prefix wins, rules are merged across policies
+		{"secret/team/foo", "secret/team/*", "create,list"},
+		// the exact path wins over globs
+		{"/secret/team/key", "secret/team/key", "update"},
+		// deny overrides the capabilities of other policies
+		{"secret/other/foo", "secret/other/*", "deny"},
+		{"other", "", "deny"},
+	} {
+		check := checkPolicies([]*Policy{a, b}, c.path)
+		if check.Rule != c.rule {
+			t.Errorf("unexpected rule for '%s', exp=%s got=%s", c.path, c.rule, check.Rule)
+		}
+		if act := strings.Join(check.Capabilities, ","); act != c.capabilities {
+			t.Errorf("unexpected capabilities for '%s', exp=%s got=%s", c.path, c.capabilities, act)
+		}
+	}
+}
+
+func TestKubernetes_CheckPolicies(t *testing.T) {
+	k := New(nil, nil)
+	k.SetClusterID("test")
+
+	check, err := k.CheckPolicies("test-master", "test/pki/k8s/sign/kube-apiserver")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "create,read,update", strings.Join(check.Capabilities, ","); exp != act {
+		t.Errorf("unexpected capabilities of master, exp=%s got=%s", exp, act)
+	}
+
+	check, err = k.CheckPolicies("worker", "test/pki/k8s/sign/kube-apiserver")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := "deny", strings.Join(check.Capabilities, ","); exp != act {
+		t.Errorf("unexpected capabilities of worker, exp=%s got=%s", exp, act)
+	}
+
+	if _, err := k.CheckPolicies("unknown", "test/secrets"); err == nil {
+		t.Error("expected an error for an unknown token role")
+	}
+}
+
+func TestKubernetes_RenderPolicies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vault-helper-policies")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	k := New(nil, nil)
+	k.SetClusterID("test")
+
+	paths, err := k.RenderPolicies(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := len(k.Policies()), len(paths); exp != act {
+		t.Errorf("unexpected number of policy files, exp=%d got=%d", exp, act)
+	}
+
+	rules, err := ioutil.ReadFile(filepath.Join(dir, "test", "worker-creator.hcl"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(rules), `path "auth/token/create/test-worker"`) {
+		t.Errorf("unexpected rules of the worker creator policy:\n%s", rules)
+	}
+
+	// nodes bootstrapping with AppRoles have no init tokens
+	k.Bootstrap = BootstrapAppRole
+	for _, p := range k.Policies() {
+		if strings.HasSuffix(p.Name, "-creator") {
+			t.Errorf("unexpected policy '%s'", p.Name)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagRevokeSerial = "serial"
+
+// The mounts of all CA generations of the spec's PKIs
+func (k *Kubernetes) pkiMountPaths() ([]string, error) {
+	var paths []string
+	for _, s := range k.Spec().PKIs {
+		p := k.PKI(s.Name)
+		if err := p.loadGenerations(); err != nil {
+			return nil, err
+		}
+		paths = append(paths, p.generationPaths()...)
+	}
+	return paths, nil
+}
+
+// Serials are listed by vault with hyphens, but accepted with colons too
+func normaliseSerial(serial string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(serial), ":", "-", -1))
+}
+
+// Revoke certificates by serial number, in whichever CA generation of the
+// cluster's PKIs issued them, and rotate the CRLs
+func (k *Kubernetes) RevokeSerials(serials []string) error {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	paths, err := k.pkiMountPaths()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	revoked := map[string]bool{}
+	for _, serial := range serials {
+		serial = normaliseSerial(serial)
+
+		found := false
+		for _, path := range paths {
+			s, err := k.vaultClient.Logical().Read(filepath.Join(path, "cert", serial))
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("error reading certificate '%s' of '%s': %v", serial, path, err))
+				continue
+			}
+			if certPEM, err := secretString(s, "certificate"); err != nil || certPEM == "" {
+				continue
+			}
+
+			found = true
+			if err := k.revokeCert(path, serial); err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			revoked[path] = true
+		}
+		if !found {
+			result = multierror.Append(result, fmt.Errorf("no certificate with serial '%s' issued by the PKIs of cluster '%s'", serial, k.clusterID))
+		}
+	}
+
+	if err := k.rotateCRLs(revoked); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return result
+}
+
+func (k *Kubernetes) revokeCert(path, serial string) error {
+	data := map[string]interface{}{
+		"serial_number": serial,
+	}
+	if _, err := k.vaultClient.Logical().Write(filepath.Join(path, "revoke"), data); err != nil {
+		return fmt.Errorf("error revoking certificate '%s' of '%s': %v", serial, path, err)
+	}
+	k.Log.Infof("Revoked certificate '%s' of '%s'", serial, path)
+
+	return nil
+}
+
+func (k *Kubernetes) rotateCRLs(paths map[string]bool) error {
+	var sorted []string
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var result error
+	for _, path := range sorted {
+		if _, err := k.vaultClient.Logical().Read(filepath.Join(path, "crl", "rotate")); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error rotating CRL of '%s': %v", path, err))
+			continue
+		}
+		k.Log.Infof("Rotated CRL of '%s'", path)
+	}
+
+	return result
+}
+
+// Revoke the certificates and tokens of a decommissioned node. Certificates
+// belong to the node if they are issued for its kubelet common name, or name
+// the node in their common name or DNS names. Tokens belong to the node if
+// they were created with its token role, or by logging in with its AppRole or
+// cert auth role.
+func (k *Kubernetes) RevokeNode(name string) error {
+	n, err := k.NewNode(name, "")
+	if err != nil {
+		return err
+	}
+
+	paths, err := k.pkiMountPaths()
+	if err != nil {
+		return err
+	}
+
+	var result error
+	revoked := map[string]bool{}
+	for _, path := range paths {
+		serials, err := n.certSerials(path)
+		if err != nil {
+			result = multierror.Append(result, err)
+			continue
+		}
+		for _, serial := range serials {
+			if err := k.revokeCert(path, serial); err != nil {
+				result = multierror.Append(result, err)
+				continue
+			}
+			revoked[path] = true
+		}
+	}
+
+	if err := k.rotateCRLs(revoked); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := n.revokeTokens(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	return result
+}
+
+// Serials of the node's valid certificates issued by a PKI mount
+func (n *Node) certSerials(path string) ([]string, error) {
+	k := n.kubernetes
+
+	serials, err := listKeys(k.vaultClient, filepath.Join(path, "certs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, serial := range serials {
+		certPath := filepath.Join(path, "cert", serial)
+		s, err := k.vaultClient.Logical().Read(certPath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading certificate '%s': %v", certPath, err)
+		}
+		certPEM, err := secretString(s, "certificate")
+		if err != nil {
+			continue
+		}
+		if !equalValues(0, s.Data["revocation_time"]) {
+			continue
+		}
+
+		cert, err := parseCert(certPEM)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate '%s': %v", certPath, err)
+		}
+		if cert.IsCA || time.Now().After(cert.NotAfter) {
+			continue
+		}
+
+		match := cert.Subject.CommonName == n.CommonName() || cert.Subject.CommonName == n.Name
+		for _, dns := range cert.DNSNames {
+			match = match || dns == n.Name
+		}
+		if match {
+			result = append(result, serial)
+		}
+	}
+
+	return result, nil
+}
+
+// Revoke the tokens the node got from its token role, AppRole or cert auth
+// role, found by looking up all accessors
+func (n *Node) revokeTokens() error {
+	k := n.kubernetes
+	tokenRole := k.NewInitToken(n.Role(), "", nil).Name()
+	appRole := k.NewAppRole(n.Role(), nil).Name()
+	certLogin := filepath.Join("auth", k.certAuthPath(), "login")
+
+	accessors, err := listKeys(k.vaultClient, "auth/token/accessors")
+	if err != nil {
+		return err
+	}
+
+	var result error
+	for _, accessor := range accessors {
+		s, err := k.vaultClient.Logical().Write("auth/token/lookup-accessor", map[string]interface{}{
+			"accessor": accessor,
+		})
+		if err != nil {
+			// tokens can expire while looking them up
+			k.Log.Debugf("Error looking up accessor '%s': %v", accessor, err)
+			continue
+		}
+		if s == nil || s.Data == nil {
+			continue
+		}
+
+		meta, _ := s.Data["meta"].(map[string]interface{})
+		path, _ := s.Data["path"].(string)
+		switch {
+		case s.Data["role"] == tokenRole:
+		case meta != nil && meta["role_name"] == appRole:
+		case meta != nil && meta["cert_name"] == n.Role() && strings.HasPrefix(path, certLogin):
+		default:
+			continue
+		}
+
+		if _, err := k.vaultClient.Logical().Write("auth/token/revoke-accessor", map[string]interface{}{
+			"accessor": accessor,
+		}); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error revoking token accessor '%s': %v", accessor, err))
+			continue
+		}
+		k.Log.Infof("Revoked token of node '%s' with accessor '%s'", n.Name, accessor)
+	}
+
+	return result
+}
@@ -0,0 +1,100 @@
+package kubernetes
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	vault "github.com/hashicorp/vault/api"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+// Issue a certificate and return its serial number as listed by vault
+func issueSerial(t *testing.T, k *Kubernetes, path string, data map[string]interface{}) string {
+	s, err := k.vaultClient.Logical().Write(path, data)
+	if err != nil {
+		t.Fatalf("error issuing certificate from '%s': %v", path, err)
+	}
+	serial, err := secretString(s, "serial_number")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return normaliseSerial(serial)
+}
+
+func revoked(t *testing.T, k *Kubernetes, path, serial string) bool {
+	s, err := k.vaultClient.Logical().Read(path + "/cert/" + serial)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return !equalValues(0, s.Data["revocation_time"])
+}
+
+func TestRevoke(t *testing.T) {
+	vaultDev := vault_dev.New()
+	if err := vaultDev.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vaultDev.Stop()
+
+	k := New(vaultDev.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	k.NodeIdentity = true
+	k.PKIURL = "https://vault.example.com:8200/"
+	k.CRLExpiry = 24 * time.Hour
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	plan, err := k.Plan()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plan.HasChanges() {
+		t.Errorf("unexpected changes after setup:\n%s", plan)
+	}
+
+	// certificates name the CRL of the mount issuing them
+	cert := issueKubeProxy(t, k, "test/pki/k8s")
+	if exp := "https://vault.example.com:8200/v1/test/pki/k8s/crl"; len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != exp {
+		t.Errorf("unexpected CRL distribution points, exp=%s got=%v", exp, cert.CRLDistributionPoints)
+	}
+
+	if _, err := k.AddNode("kube-1", "worker"); err != nil {
+		t.Fatalf("error adding node: %v", err)
+	}
+	secret, err := vaultDev.Client().Auth().Token().CreateWithRole(&vault.TokenCreateRequest{}, "test-node-kube-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	nodeToken := secret.Auth.ClientToken
+
+	kubelet := issueSerial(t, k, "test/pki/k8s/issue/kubelet", map[string]interface{}{"common_name": "system:node:kube-1"})
+	other := issueSerial(t, k, "test/pki/k8s/issue/kubelet", map[string]interface{}{"common_name": "system:node:kube-2"})
+
+	if err := k.RevokeNode("kube-1"); err != nil {
+		t.Fatalf("error revoking node: %v", err)
+	}
+	if !revoked(t, k, "test/pki/k8s", kubelet) {
+		t.Error("expected the node's kubelet certificate to be revoked")
+	}
+	if revoked(t, k, "test/pki/k8s", other) {
+		t.Error("unexpected revocation of another node's certificate")
+	}
+	if s, err := vaultDev.Client().Auth().Token().Lookup(nodeToken); err == nil && s != nil {
+		t.Error("expected the node's token to be revoked")
+	}
+
+	// serials are accepted with colons
+	if err := k.RevokeSerials([]string{"aa:bb"}); err == nil {
+		t.Error("expected an error revoking an unknown serial")
+	}
+	if err := k.RevokeSerials([]string{strings.Replace(other, "-", ":", -1)}); err != nil {
+		t.Fatalf("error revoking: %v", err)
+	}
+	if !revoked(t, k, "test/pki/k8s", other) {
+		t.Error("expected the certificate to be revoked")
+	}
+}
@@ -0,0 +1,300 @@
+package kubernetes
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const FlagSAKeyType = "sa-key-type"
+const FlagSAKeyOverlap = "sa-key-overlap"
+const FlagSAKeepPrevious = "sa-keep-previous-keys"
+
+const SAKeyTypeRSA = "rsa"
+const SAKeyTypeECDSA = "ecdsa"
+
+// The service account signing key and the public keys of previous ones,
+// which are still valid to verify tokens during the overlap window
+type serviceAccountKeys struct {
+	Key      string
+	KeyType  string
+	Previous []*previousServiceAccountKey
+
+	// whether the previous keys secret exists
+	previousStored bool
+}
+
+type previousServiceAccountKey struct {
+	PublicKey string    `json:"public_key"`
+	Retired   time.Time `json:"retired"`
+}
+
+func validSAKeyType(keyType string) error {
+	if keyType != SAKeyTypeRSA && keyType != SAKeyTypeECDSA {
+		return fmt.Errorf("unsupported service account key type '%s', expected '%s' or '%s'", keyType, SAKeyTypeRSA, SAKeyTypeECDSA)
+	}
+	return nil
+}
+
+const serviceAccountsKey = "service-accounts"
+const serviceAccountsPreviousKey = "service-accounts-previous"
+
+func (g *Generic) serviceAccountsPath() string {
+	return filepath.Join(g.Path(), serviceAccountsKey)
+}
+
+func (g *Generic) serviceAccountsPreviousPath() string {
+	return filepath.Join(g.Path(), serviceAccountsPreviousKey)
+}
+
+// Create the service account key if there is none, fill in the public key
+// fields of keys written by older versions and drop previous keys whose
+// overlap window has passed
+func (g *Generic) ensureServiceAccountKeys() error {
+	keys, secret, err := g.readServiceAccountKeys()
+	if err != nil {
+		return err
+	}
+
+	if keys == nil {
+		keyType := g.kubernetes.ServiceAccountKeyType
+		key, err := generateServiceAccountKey(keyType)
+		if err != nil {
+			return err
+		}
+		return g.writeServiceAccountKeys(&serviceAccountKeys{Key: key, KeyType: keyType})
+	}
+
+	kept := g.pruneServiceAccountKeys(keys.Previous)
+	if dropped := len(keys.Previous) - len(kept); dropped > 0 {
+		g.Log.Warnf("Dropping %d service account keys retired more than %s ago, tokens they signed are no longer valid, including legacy service account tokens that don't expire. Use --%s until those were reissued.", dropped, g.kubernetes.ServiceAccountKeyOverlap, FlagSAKeepPrevious)
+		keys.Previous = kept
+		return g.writeServiceAccountKeys(keys)
+	}
+
+	// keys written before rotation was supported lack the public keys
+	data, err := keys.data()
+	if err != nil {
+		return err
+	}
+	if len(diffData(data, secret.Data)) > 0 {
+		return g.writeServiceAccountKeys(keys)
+	}
+
+	return nil
+}
+
+// Replace the service account signing key with a new one of the given type.
+// The public key of the replaced key stays in the bundle for the overlap
+// window, so tokens it signed can still be verified.
+func (g *Generic) RotateServiceAccountKey(keyType string) error {
+	if err := validSAKeyType(keyType); err != nil {
+		return err
+	}
+
+	keys, _, err := g.readServiceAccountKeys()
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		return fmt.Errorf("no service account key found at '%s', run setup first", g.serviceAccountsPath())
+	}
+
+	publicKey, err := publicKeyPEM(keys.Key)
+	if err != nil {
+		return fmt.Errorf("error reading current service account key: %v", err)
+	}
+
+	key, err := generateServiceAccountKey(keyType)
+	if err != nil {
+		return err
+	}
+
+	previous := append([]*previousServiceAccountKey{
+		&previousServiceAccountKey{PublicKey: publicKey, Retired: time.Now().UTC()},
+	}, keys.Previous...)
+
+	if err := g.writeServiceAccountKeys(&serviceAccountKeys{
+		Key:      key,
+		KeyType:  keyType,
+		Previous: g.pruneServiceAccountKeys(previous),
+
+		previousStored: keys.previousStored,
+	}); err != nil {
+		return err
+	}
+
+	g.Log.Infof("Rotated service account key '%s'", g.serviceAccountsPath())
+
+	return nil
+}
+
+// The previous keys still within the overlap window, all of them if they are
+// kept until the tokens they signed were reissued
+func (g *Generic) pruneServiceAccountKeys(previous []*previousServiceAccountKey) []*previousServiceAccountKey {
+	if g.kubernetes.ServiceAccountKeepPrevious {
+		return previous
+	}
+
+	var kept []*previousServiceAccountKey
+	for _, p := range previous {
+		if time.Since(p.Retired) <= g.kubernetes.ServiceAccountKeyOverlap {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// Read the stored keys, nil if there is no key yet
+func (g *Generic) readServiceAccountKeys() (*serviceAccountKeys, *vault.Secret, error) {
+	path := g.serviceAccountsPath()
+	s, err := g.readSecret(serviceAccountsKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking for secret %s: %v", path, err)
+	}
+	if s == nil {
+		return nil, nil, nil
+	}
+
+	keys := &serviceAccountKeys{KeyType: SAKeyTypeRSA}
+	if keys.Key, err = secretString(s, "key"); err != nil {
+		return nil, nil, fmt.Errorf("error reading secret %s: %v", path, err)
+	}
+	if _, ok := s.Data["key_type"]; ok {
+		if keys.KeyType, err = secretString(s, "key_type"); err != nil {
+			return nil, nil, fmt.Errorf("error reading secret %s: %v", path, err)
+		}
+	}
+
+	path = g.serviceAccountsPreviousPath()
+	previous, err := g.readSecret(serviceAccountsPreviousKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking for secret %s: %v", path, err)
+	}
+	if previous != nil {
+		keys.previousStored = true
+		// the list comes back as generic JSON values
+		dat, err := json.Marshal(previous.Data["keys"])
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading secret %s: %v", path, err)
+		}
+		if err := json.Unmarshal(dat, &keys.Previous); err != nil {
+			return nil, nil, fmt.Errorf("error reading secret %s: %v", path, err)
+		}
+	}
+
+	return keys, s, nil
+}
+
+func (g *Generic) writeServiceAccountKeys(keys *serviceAccountKeys) error {
+	data, err := keys.data()
+	if err != nil {
+		return err
+	}
+
+	// write the previous keys first, the bundle of the key secret is what
+	// clients read
+	if len(keys.Previous) > 0 {
+		err = g.writeSecretCAS(serviceAccountsPreviousKey, map[string]interface{}{
+			"keys": keys.Previous,
+		})
+	} else if keys.previousStored {
+		err = g.deleteSecret(serviceAccountsPreviousKey)
+	}
+	if err != nil {
+		return fmt.Errorf("error writting previous keys to secrets: %v", err)
+	}
+
+	if err := g.writeSecretCAS(serviceAccountsKey, data); err != nil {
+		return fmt.Errorf("error writting key to secrets: %v", err)
+	}
+
+	g.Log.Infof("Key written to secrets '%s'", g.serviceAccountsPath())
+
+	return nil
+}
+
+// The fields of the key secret. public_keys holds the PEM bundle of all public
+// keys tokens can be verified with.
+func (s *serviceAccountKeys) data() (map[string]interface{}, error) {
+	publicKey, err := publicKeyPEM(s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account key: %v", err)
+	}
+
+	bundle := publicKey
+	for _, p := range s.Previous {
+		bundle += p.PublicKey
+	}
+
+	return map[string]interface{}{
+		"key":         s.Key,
+		"key_type":    s.KeyType,
+		"public_key":  publicKey,
+		"public_keys": bundle,
+	}, nil
+}
+
+func generateServiceAccountKey(keyType string) (string, error) {
+	var block *pem.Block
+
+	switch keyType {
+	case SAKeyTypeRSA:
+		key, err := rsa.GenerateKey(rand.Reader, 4096)
+		if err != nil {
+			return "", fmt.Errorf("error generating rsa key: %v", err)
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	case SAKeyTypeECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("error generating ecdsa key: %v", err)
+		}
+		dat, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return "", fmt.Errorf("error encoding ecdsa key: %v", err)
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: dat}
+	default:
+		return "", validSAKeyType(keyType)
+	}
+
+	var buf bytes.Buffer
+	if err := pem.Encode(&buf, block); err != nil {
+		return "", fmt.Errorf("error encoding key in PEM: %v", err)
+	}
+
+	return buf.String(), nil
+}
+
+func publicKeyPEM(keyPEM string) (string, error) {
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return "", err
+	}
+
+	dat, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return "", fmt.Errorf("error encoding public key: %v", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: dat})), nil
+}
+
+// Replace the service account signing key, see Generic.RotateServiceAccountKey
+func (k *Kubernetes) RotateServiceAccountKey(keyType string) error {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+	return k.secretsGeneric.RotateServiceAccountKey(keyType)
+}
@@ -0,0 +1,120 @@
+package kubernetes
+
+import (
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func countPublicKeys(t *testing.T, k *Kubernetes) int {
+	s, err := k.vaultClient.Logical().Read("test/secrets/service-accounts")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bundle, err := secretString(s, "public_keys")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := 0
+	for rest := []byte(bundle); ; n++ {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return n
+		}
+		if block.Type != "PUBLIC KEY" {
+			t.Errorf("unexpected PEM block type '%s'", block.Type)
+		}
+	}
+}
+
+func TestServiceAccountKeys(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	k.ServiceAccountKeyType = SAKeyTypeECDSA
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	keys, _, err := k.secretsGeneric.readServiceAccountKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := SAKeyTypeECDSA, keys.KeyType; exp != act {
+		t.Errorf("unexpected key type, exp=%s got=%s", exp, act)
+	}
+	if n := countPublicKeys(t, k); n != 1 {
+		t.Errorf("expected 1 public key, got %d", n)
+	}
+
+	// both rotated keys stay valid
+	for _, keyType := range []string{SAKeyTypeECDSA, SAKeyTypeRSA} {
+		if err := k.RotateServiceAccountKey(keyType); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if n := countPublicKeys(t, k); n != 3 {
+		t.Errorf("expected 3 public keys, got %d", n)
+	}
+	if err := k.RotateServiceAccountKey("dsa"); err == nil {
+		t.Error("expected an error rotating to an unsupported key type")
+	}
+
+	keys, _, err = k.secretsGeneric.readServiceAccountKeys()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := SAKeyTypeRSA, keys.KeyType; exp != act {
+		t.Errorf("unexpected key type, exp=%s got=%s", exp, act)
+	}
+	if exp, act := 2, len(keys.Previous); exp != act {
+		t.Errorf("unexpected number of previous keys, exp=%d got=%d", exp, act)
+	}
+
+	// previous keys can be kept past the overlap window
+	k.ServiceAccountKeyOverlap = time.Nanosecond
+	k.ServiceAccountKeepPrevious = true
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	if n := countPublicKeys(t, k); n != 3 {
+		t.Errorf("expected 3 public keys, got %d", n)
+	}
+
+	// setup drops keys after the overlap window
+	k.ServiceAccountKeepPrevious = false
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	if n := countPublicKeys(t, k); n != 1 {
+		t.Errorf("expected 1 public key, got %d", n)
+	}
+	if s, err := vault.Client().Logical().Read("test/secrets/service-accounts-previous"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if s != nil {
+		t.Error("expected previous keys to be removed")
+	}
+
+	// keys written by older versions get their public keys filled in
+	if _, err := vault.Client().Logical().Write("test/secrets/service-accounts", map[string]interface{}{
+		"key": keys.Key,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	if n := countPublicKeys(t, k); n != 1 {
+		t.Errorf("expected 1 public key, got %d", n)
+	}
+}
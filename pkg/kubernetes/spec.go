@@ -0,0 +1,563 @@
+package kubernetes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"gopkg.in/yaml.v2"
+)
+
+const SpecVersion = "v1"
+
+const ValidityAdmin = "admin"
+const ValidityComponents = "components"
+
+// A component role is built like the roles of the kubernetes components
+const RoleTemplateComponent = "component"
+
+// Spec describes the vault layout of a cluster: the PKI mounts and their
+// roles, the policies, the init token roles and the roles nodes log in with
+// their certificates. All paths and policy names
+// are relative to the cluster ID.
+type Spec struct {
+	Version    string           `yaml:"version" json:"version"`
+	PKIs       []*SpecPKI       `yaml:"pkis" json:"pkis"`
+	Policies   []*SpecPolicy    `yaml:"policies" json:"policies"`
+	InitTokens []*SpecInitToken `yaml:"initTokens" json:"initTokens"`
+	CertAuth   []*SpecCertAuth  `yaml:"certAuth,omitempty" json:"certAuth,omitempty"`
+}
+
+type SpecPKI struct {
+	Name  string      `yaml:"name" json:"name"`
+	CA    *SpecCA     `yaml:"ca,omitempty" json:"ca,omitempty"`
+	Roles []*SpecRole `yaml:"roles" json:"roles"`
+}
+
+// SpecCA configures how the CA of a PKI gets created, defaults to a
+// self-signed root CA
+type SpecCA struct {
+	Mode string `yaml:"mode" json:"mode"`
+	// Parent is the vault path of a PKI mount signing the intermediate CA,
+	// if empty the CSR has to be signed outside of vault
+	Parent string `yaml:"parent,omitempty" json:"parent,omitempty"`
+	// Exported CAs get generated with an exportable private key, which is
+	// escrowed in the secrets mount so backups can include it
+	Exported bool `yaml:"exported,omitempty" json:"exported,omitempty"`
+}
+
+type SpecRole struct {
+	Name string `yaml:"name" json:"name"`
+	// Validity sets ttl and max_ttl of the role to either the admin or
+	// component validity, if they are not part of Data
+	Validity string `yaml:"validity,omitempty" json:"validity,omitempty"`
+	// Template the role data is based on, Data overrides its values
+	Template string                 `yaml:"template,omitempty" json:"template,omitempty"`
+	Data     map[string]interface{} `yaml:"data" json:"data"`
+	// Classes are the policies, which are allowed to sign the role
+	Classes []string `yaml:"classes,omitempty" json:"classes,omitempty"`
+}
+
+type SpecPolicy struct {
+	Name  string            `yaml:"name" json:"name"`
+	Paths []*SpecPolicyPath `yaml:"paths" json:"paths"`
+}
+
+type SpecPolicyPath struct {
+	Path         string   `yaml:"path" json:"path"`
+	Capabilities []string `yaml:"capabilities" json:"capabilities"`
+	// Deny the path, regardless of the capabilities other policies grant
+	Deny bool `yaml:"deny,omitempty" json:"deny,omitempty"`
+	// AllowedParameters restricts the parameters of requests to the path
+	// and their values, an empty list allows any value
+	AllowedParameters map[string][]string `yaml:"allowedParameters,omitempty" json:"allowedParameters,omitempty"`
+	// DeniedParameters are parameters requests must not set, or the values
+	// they must not set them to
+	DeniedParameters map[string][]string `yaml:"deniedParameters,omitempty" json:"deniedParameters,omitempty"`
+	// RequiredParameters have to be set by requests, needs vault 0.9
+	RequiredParameters []string `yaml:"requiredParameters,omitempty" json:"requiredParameters,omitempty"`
+	// Bounds of the TTL requests have to wrap their responses with, e.g.
+	// '1m'
+	MinWrappingTTL string `yaml:"minWrappingTTL,omitempty" json:"minWrappingTTL,omitempty"`
+	MaxWrappingTTL string `yaml:"maxWrappingTTL,omitempty" json:"maxWrappingTTL,omitempty"`
+}
+
+type SpecInitToken struct {
+	Role     string   `yaml:"role" json:"role"`
+	Policies []string `yaml:"policies" json:"policies"`
+}
+
+// SpecCertAuth maps client certificates of the k8s PKI to policies. The
+// allowed names are matched against the common name of certificates.
+type SpecCertAuth struct {
+	Role         string   `yaml:"role" json:"role"`
+	AllowedNames []string `yaml:"allowedNames" json:"allowedNames"`
+	Policies     []string `yaml:"policies" json:"policies"`
+}
+
+// Read a spec from a YAML or JSON file
+func LoadSpec(path string) (*Spec, error) {
+	dat, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec file '%s': %v", path, err)
+	}
+
+	return ParseSpec(dat)
+}
+
+// Read the specs of a directory of YAML or JSON files, keyed by the cluster
+// ID, which is the file name without extension
+func LoadSpecDir(dir string) (map[string]*Spec, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading spec directory '%s': %v", dir, err)
+	}
+
+	specs := make(map[string]*Spec)
+	var result error
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+
+		clusterID := strings.TrimSuffix(f.Name(), ext)
+		if _, ok := specs[clusterID]; ok {
+			result = multierror.Append(result, fmt.Errorf("duplicate spec for cluster '%s' in '%s'", clusterID, dir))
+			continue
+		}
+
+		spec, err := LoadSpec(filepath.Join(dir, f.Name()))
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error loading spec '%s': %v", f.Name(), err))
+			continue
+		}
+		specs[clusterID] = spec
+	}
+
+	return specs, result
+}
+
+func ParseSpec(dat []byte) (*Spec, error) {
+	spec := &Spec{}
+	if err := yaml.UnmarshalStrict(dat, spec); err != nil {
+		return nil, fmt.Errorf("error parsing spec: %v", err)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+func (s *Spec) Validate() error {
+	var result error
+
+	if s.Version != SpecVersion {
+		result = multierror.Append(result, fmt.Errorf("unsupported spec version '%s', expected '%s'", s.Version, SpecVersion))
+	}
+
+	pkis := map[string]bool{}
+	for _, p := range s.PKIs {
+		if p.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("pki without a name"))
+			continue
+		}
+		if pkis[p.Name] {
+			result = multierror.Append(result, fmt.Errorf("pki '%s' defined more than once", p.Name))
+		}
+		pkis[p.Name] = true
+
+		if _, gen := splitPKIGeneration(p.Name); gen > 1 {
+			result = multierror.Append(result, fmt.Errorf("pki '%s' name is reserved for CA generations", p.Name))
+		}
+
+		if p.CA != nil {
+			if err := p.CA.Validate(); err != nil {
+				result = multierror.Append(result, fmt.Errorf("pki '%s': %v", p.Name, err))
+			}
+		}
+
+		roles := map[string]bool{}
+		for _, r := range p.Roles {
+			if r.Name == "" {
+				result = multierror.Append(result, fmt.Errorf("pki '%s' has a role without a name", p.Name))
+				continue
+			}
+			if roles[r.Name] {
+				result = multierror.Append(result, fmt.Errorf("pki '%s' role '%s' defined more than once", p.Name, r.Name))
+			}
+			roles[r.Name] = true
+
+			if r.Validity != "" && r.Validity != ValidityAdmin && r.Validity != ValidityComponents {
+				result = multierror.Append(result, fmt.Errorf("pki '%s' role '%s' has unknown validity '%s'", p.Name, r.Name, r.Validity))
+			}
+			if r.Template != "" && r.Template != RoleTemplateComponent {
+				result = multierror.Append(result, fmt.Errorf("pki '%s' role '%s' has unknown template '%s'", p.Name, r.Name, r.Template))
+			}
+		}
+	}
+
+	policies := map[string]bool{}
+	for _, p := range s.Policies {
+		if p.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("policy without a name"))
+			continue
+		}
+		if policies[p.Name] {
+			result = multierror.Append(result, fmt.Errorf("policy '%s' defined more than once", p.Name))
+		}
+		policies[p.Name] = true
+
+		for _, pp := range p.Paths {
+			if pp.Path == "" || filepath.IsAbs(pp.Path) || strings.HasPrefix(filepath.Clean(pp.Path), "..") {
+				result = multierror.Append(result, fmt.Errorf("policy '%s' path '%s' has to be relative to the cluster", p.Name, pp.Path))
+			}
+			if len(pp.Capabilities) == 0 && !pp.Deny {
+				result = multierror.Append(result, fmt.Errorf("policy '%s' path '%s' has no capabilities", p.Name, pp.Path))
+			}
+
+			minTTL, err := parseWrappingTTL(pp.MinWrappingTTL)
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("policy '%s' path '%s' has an invalid minWrappingTTL: %v", p.Name, pp.Path, err))
+			}
+			maxTTL, err := parseWrappingTTL(pp.MaxWrappingTTL)
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("policy '%s' path '%s' has an invalid maxWrappingTTL: %v", p.Name, pp.Path, err))
+			}
+			if minTTL > 0 && maxTTL > 0 && minTTL > maxTTL {
+				result = multierror.Append(result, fmt.Errorf("policy '%s' path '%s' has a minWrappingTTL above its maxWrappingTTL", p.Name, pp.Path))
+			}
+		}
+	}
+
+	for _, p := range s.PKIs {
+		for _, r := range p.Roles {
+			for _, class := range r.Classes {
+				if !policies[class] {
+					result = multierror.Append(result, fmt.Errorf("pki '%s' role '%s' references unknown policy '%s'", p.Name, r.Name, class))
+				}
+			}
+		}
+	}
+
+	roles := map[string]bool{}
+	for _, i := range s.InitTokens {
+		if i.Role == "" {
+			result = multierror.Append(result, fmt.Errorf("init token without a role"))
+			continue
+		}
+		if roles[i.Role] {
+			result = multierror.Append(result, fmt.Errorf("init token '%s' defined more than once", i.Role))
+		}
+		roles[i.Role] = true
+
+		for _, policy := range i.Policies {
+			if !policies[policy] {
+				result = multierror.Append(result, fmt.Errorf("init token '%s' references unknown policy '%s'", i.Role, policy))
+			}
+		}
+	}
+
+	if len(s.CertAuth) > 0 && !pkis[certAuthPKI] {
+		result = multierror.Append(result, fmt.Errorf("cert auth needs the pki '%s'", certAuthPKI))
+	}
+	roles = map[string]bool{}
+	for _, c := range s.CertAuth {
+		if c.Role == "" {
+			result = multierror.Append(result, fmt.Errorf("cert auth without a role"))
+			continue
+		}
+		if roles[c.Role] {
+			result = multierror.Append(result, fmt.Errorf("cert auth '%s' defined more than once", c.Role))
+		}
+		roles[c.Role] = true
+
+		if len(c.AllowedNames) == 0 {
+			result = multierror.Append(result, fmt.Errorf("cert auth '%s' has no allowed names", c.Role))
+		}
+		for _, policy := range c.Policies {
+			if !policies[policy] {
+				result = multierror.Append(result, fmt.Errorf("cert auth '%s' references unknown policy '%s'", c.Role, policy))
+			}
+		}
+	}
+
+	return result
+}
+
+func (c *SpecCA) Validate() error {
+	switch c.Mode {
+	case CAModeRoot:
+		if c.Parent != "" {
+			return fmt.Errorf("a parent is only supported by '%s' CAs", CAModeIntermediate)
+		}
+	case CAModeIntermediate:
+	default:
+		return fmt.Errorf("unknown CA mode '%s', expected '%s' or '%s'", c.Mode, CAModeRoot, CAModeIntermediate)
+	}
+	return nil
+}
+
+// Add a role to a PKI of the spec, replacing an existing one of the same
+// name
+func (s *Spec) AddRole(pkiName string, role *SpecRole) error {
+	if !s.addRole(pkiName, role) {
+		return fmt.Errorf("unknown pki '%s'", pkiName)
+	}
+	return s.Validate()
+}
+
+func (s *Spec) addRole(pkiName string, role *SpecRole) bool {
+	for _, p := range s.PKIs {
+		if p.Name != pkiName {
+			continue
+		}
+		for pos, r := range p.Roles {
+			if r.Name == role.Name {
+				p.Roles[pos] = role
+				return true
+			}
+		}
+		p.Roles = append(p.Roles, role)
+		return true
+	}
+
+	return false
+}
+
+// Marshal the spec to YAML
+func (s *Spec) YAML() ([]byte, error) {
+	return yaml.Marshal(s)
+}
+
+// Set a spec the cluster will converge to, replacing the default layout
+func (k *Kubernetes) SetSpec(spec *Spec) error {
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("invalid spec: %v", err)
+	}
+	k.spec = spec
+	return nil
+}
+
+// Get the spec the cluster will converge to, with the component roles added
+func (k *Kubernetes) Spec() *Spec {
+	spec := k.spec
+	if spec == nil {
+		spec = k.defaultSpec()
+	}
+	// validated when they were added, replacing them again is a no-op
+	for _, role := range k.componentRoles {
+		spec.addRole(k.kubernetesPKI.pkiName, role)
+	}
+	return spec
+}
+
+// The default spec describes the layout vault-helper has always set up
+func (k *Kubernetes) defaultSpec() *Spec {
+	spec := &Spec{
+		Version: SpecVersion,
+		PKIs: []*SpecPKI{
+			k.specPKI(
+				k.etcdKubernetesPKI,
+				specRole(k.etcdClientRole(), ValidityComponents),
+				specRole(k.etcdServerRole(), ValidityComponents),
+			),
+			k.specPKI(
+				k.etcdOverlayPKI,
+				specRole(k.etcdClientRole(), ValidityComponents),
+				specRole(k.etcdServerRole(), ValidityComponents),
+			),
+			k.specPKI(
+				k.kubernetesPKI,
+				specRole(k.k8sAdminRole(), ValidityAdmin),
+				specRole(k.k8sAPIServerRole(), ValidityComponents),
+				specRole(k.k8sComponentRole("kube-scheduler"), ValidityComponents),
+				specRole(k.k8sComponentRole("kube-controller-manager"), ValidityComponents),
+				specRole(k.k8sComponentRole("kube-proxy"), ValidityComponents),
+				specRole(k.k8sKubeletRole(), ValidityComponents),
+			),
+			k.specPKI(k.kubernetesAPIProxy, specRole(k.k8sAPIServerProxyRole(), ValidityComponents)),
+		},
+		InitTokens: []*SpecInitToken{
+			&SpecInitToken{Role: "etcd", Policies: []string{"etcd"}},
+			&SpecInitToken{Role: "master", Policies: []string{"master", "worker"}},
+			&SpecInitToken{Role: "worker", Policies: []string{"worker"}},
+			&SpecInitToken{Role: "all", Policies: []string{"etcd", "master", "worker"}},
+		},
+		CertAuth: k.defaultSpecCertAuth(),
+	}
+
+	for _, p := range []*Policy{
+		k.etcdPolicy(),
+		k.masterPolicy(),
+		k.workerPolicy(),
+	} {
+		spec.Policies = append(spec.Policies, k.specPolicy(p))
+	}
+
+	return spec
+}
+
+func (k *Kubernetes) specPKI(p *PKI, roles ...*SpecRole) *SpecPKI {
+	return &SpecPKI{
+		Name:  p.pkiName,
+		Roles: roles,
+	}
+}
+
+// The spec role of a generated role, its TTLs follow the validity flags
+// instead of being pinned to their current values
+func specRole(role *pkiRole, validity string) *SpecRole {
+	data := map[string]interface{}{}
+	for key, value := range role.Data {
+		if key != "ttl" && key != "max_ttl" {
+			data[key] = value
+		}
+	}
+	return &SpecRole{
+		Name:     role.Name,
+		Validity: validity,
+		Data:     data,
+	}
+}
+
+func (k *Kubernetes) specPolicy(p *Policy) *SpecPolicy {
+	s := &SpecPolicy{
+		Name: p.Role,
+	}
+	for _, pp := range p.Policies {
+		sp := &SpecPolicyPath{
+			Path:               k.relativePKIPath(pp.path),
+			Capabilities:       pp.capabilities,
+			Deny:               pp.deny,
+			AllowedParameters:  pp.allowedParameters,
+			DeniedParameters:   pp.deniedParameters,
+			RequiredParameters: pp.requiredParameters,
+		}
+		if pp.minWrappingTTL > 0 {
+			sp.MinWrappingTTL = pp.minWrappingTTL.String()
+		}
+		if pp.maxWrappingTTL > 0 {
+			sp.MaxWrappingTTL = pp.maxWrappingTTL.String()
+		}
+		s.Paths = append(s.Paths, sp)
+	}
+	return s
+}
+
+// Build the vault role from a spec role
+func (k *Kubernetes) pkiRole(r *SpecRole) *pkiRole {
+	data := map[string]interface{}{}
+	if r.Template == RoleTemplateComponent {
+		for key, value := range k.k8sComponentRole(r.Name).Data {
+			data[key] = value
+		}
+	}
+	for key, value := range r.Data {
+		data[key] = value
+	}
+
+	var validity string
+	switch r.Validity {
+	case ValidityAdmin:
+		validity = fmt.Sprintf("%ds", int(k.MaxValidityAdmin.Seconds()))
+	case ValidityComponents:
+		validity = fmt.Sprintf("%ds", int(k.MaxValidityComponents.Seconds()))
+	}
+	if validity != "" {
+		for _, key := range []string{"ttl", "max_ttl"} {
+			if _, ok := data[key]; !ok {
+				data[key] = validity
+			}
+		}
+	}
+
+	// the cluster's key type, unless the role sets its own
+	if _, ok := data["key_type"]; !ok {
+		for key, value := range k.pkiRoleKeyData() {
+			data[key] = value
+		}
+	}
+
+	return &pkiRole{
+		Name: r.Name,
+		Data: data,
+	}
+}
+
+// Build the vault policy from a spec policy
+func (k *Kubernetes) policy(s *SpecPolicy) *Policy {
+	p := &Policy{
+		Name: k.policyName(s.Name),
+		Role: s.Name,
+	}
+	paths := s.Paths
+
+	// roles can name the policies allowed to sign them
+	for _, sp := range k.Spec().PKIs {
+		for _, r := range sp.Roles {
+			for _, class := range r.Classes {
+				if class == s.Name {
+					paths = append(paths, &SpecPolicyPath{
+						Path:         filepath.Join("pki", sp.Name, "sign", r.Name),
+						Capabilities: []string{"create", "read", "update"},
+					})
+				}
+			}
+		}
+	}
+
+	for _, pp := range paths {
+		// validated with the spec
+		minTTL, _ := parseWrappingTTL(pp.MinWrappingTTL)
+		maxTTL, _ := parseWrappingTTL(pp.MaxWrappingTTL)
+
+		// PKI paths are granted on all mounted CA generations
+		for _, path := range k.pkiGenerationPaths(pp.Path) {
+			p.Policies = append(p.Policies, &policyPath{
+				path:               path,
+				capabilities:       pp.Capabilities,
+				deny:               pp.Deny,
+				allowedParameters:  pp.AllowedParameters,
+				deniedParameters:   pp.DeniedParameters,
+				requiredParameters: pp.RequiredParameters,
+				minWrappingTTL:     minTTL,
+				maxWrappingTTL:     maxTTL,
+			})
+		}
+	}
+	return p
+}
+
+// Get the CA config of a PKI, falling back to the cluster wide defaults
+func (k *Kubernetes) specCA(name string) *SpecCA {
+	for _, p := range k.Spec().PKIs {
+		if p.Name == name && p.CA != nil {
+			return p.CA
+		}
+	}
+	return &SpecCA{
+		Mode:     k.CAMode,
+		Parent:   k.CAParent,
+		Exported: k.CAExported,
+	}
+}
+
+func (k *Kubernetes) policyName(name string) string {
+	return fmt.Sprintf("%s/%s", k.clusterID, name)
+}
+
+// Get the PKI of the given name, creating it if it is not yet known
+func (k *Kubernetes) PKI(name string) *PKI {
+	if p, ok := k.pkis[name]; ok {
+		return p
+	}
+
+	p := NewPKI(k, name, k.Log)
+	k.pkis[name] = p
+	return p
+}
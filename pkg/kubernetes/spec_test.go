@@ -0,0 +1,331 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	vault "github.com/hashicorp/vault/api"
+)
+
+const testSpec = `
+version: v1
+pkis:
+- name: k8s
+  roles:
+  - name: metrics-server
+    validity: components
+    data:
+      allowed_domains: metrics-server
+      allow_bare_domains: true
+      client_flag: true
+      server_flag: false
+policies:
+- name: worker
+  paths:
+  - path: pki/k8s/sign/metrics-server
+    capabilities: ["create", "read", "update"]
+initTokens:
+- role: worker
+  policies: ["worker"]
+`
+
+func TestSpec_Default(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	k := fv.Kubernetes()
+
+	spec := k.Spec()
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error validating default spec: %v", err)
+	}
+
+	// default spec has to result in the same policies as before
+	for _, p := range []*Policy{k.etcdPolicy(), k.masterPolicy(), k.workerPolicy()} {
+		found := false
+		for _, sp := range spec.Policies {
+			if sp.Name != p.Role {
+				continue
+			}
+			found = true
+			if exp, act := p.Policy(), k.policy(sp).Policy(); exp != act {
+				t.Errorf("unexpected policy '%s', exp=%s got=%s", p.Name, exp, act)
+			}
+		}
+		if !found {
+			t.Errorf("policy '%s' not found in default spec", p.Role)
+		}
+	}
+
+	// spec should be parsable again after marshalling it
+	dat, err := spec.YAML()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := ParseSpec(dat)
+	if err != nil {
+		t.Fatalf("unexpected error parsing marshalled default spec: %v", err)
+	}
+
+	// a saved spec follows the validity flags instead of pinning the TTLs
+	k.MaxValidityAdmin = time.Hour * 24 * 90
+	k.MaxValidityComponents = time.Hour * 24 * 7
+	if err := k.SetSpec(parsed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, p := range k.Spec().PKIs {
+		for _, r := range p.Roles {
+			exp := "604800s"
+			if r.Name == "admin" {
+				exp = "7776000s"
+			}
+			data := k.pkiRole(r).Data
+			if data["ttl"] != exp || data["max_ttl"] != exp {
+				t.Errorf("unexpected TTLs of role '%s' in pki '%s', exp=%s got ttl=%v max_ttl=%v", r.Name, p.Name, exp, data["ttl"], data["max_ttl"])
+			}
+		}
+	}
+}
+
+func TestSpec_Invalid(t *testing.T) {
+	for _, dat := range []string{
+		"version: v0",
+		"version: v1\npkis:\n- name: k8s\n- name: k8s",
+		"version: v1\npkis:\n- name: k8s\n  roles:\n  - name: admin\n    validity: forever",
+		"version: v1\npkis:\n- name: k8s\n  ca:\n    mode: subordinate",
+		"version: v1\npkis:\n- name: k8s-gen2",
+		"version: v1\npkis:\n- name: k8s\n  ca:\n    mode: root\n    parent: corp-pki",
+		"version: v1\npolicies:\n- name: worker\n  paths:\n  - path: /other-cluster/pki\n    capabilities: [read]",
+		"version: v1\ninitTokens:\n- role: worker\n  policies: [worker]",
+		"version: v1\ncertAuth:\n- role: worker\n  allowedNames: [\"system:node:*\"]",
+		"version: v1\npkis:\n- name: k8s\ncertAuth:\n- role: worker\n  policies: []",
+		"version: v1\npolicies:\n- name: worker\n  paths:\n  - path: secrets\n    capabilities: [read]\n    minWrappingTTL: forever",
+		"version: v1\npolicies:\n- name: worker\n  paths:\n  - path: secrets\n    capabilities: [read]\n    minWrappingTTL: 1h\n    maxWrappingTTL: 1m",
+		"version: v1\npolices:\n- name: worker",
+		"version: v1\npolicies:\n- name: worker\n  paths:\n  - path: secrets\n    capabilities: [read]\n    allowed_parameter:\n      key: []",
+	} {
+		if _, err := ParseSpec([]byte(dat)); err == nil {
+			t.Errorf("expected an error parsing spec: %s", dat)
+		}
+	}
+}
+
+func TestSpec_Ensure(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	k := fv.Kubernetes()
+
+	dir, err := ioutil.TempDir("", "vault-helper-spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cluster.yaml")
+	if err := ioutil.WriteFile(path, []byte(testSpec), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, err := LoadSpec(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading spec: %v", err)
+	}
+	if err := k.SetSpec(spec); err != nil {
+		t.Fatalf("unexpected error setting spec: %v", err)
+	}
+
+	fv.fakeSys.EXPECT().ListMounts().AnyTimes().Return(nil, nil)
+	fv.fakeSys.EXPECT().ListPolicies().AnyTimes().Return(nil, nil)
+	fv.fakeSys.EXPECT().Mount("test-cluster-inside/pki/k8s", gomock.Any()).Times(1).Return(nil)
+	fv.fakeSys.EXPECT().Mount("test-cluster-inside/secrets", gomock.Any()).Times(1).Return(nil)
+
+	fv.fakeLogical.EXPECT().Write("test-cluster-inside/pki/k8s/roles/metrics-server", map[string]interface{}{
+		"allowed_domains":    "metrics-server",
+		"allow_bare_domains": true,
+		"client_flag":        true,
+		"server_flag":        false,
+		"ttl":                "2592000s",
+		"max_ttl":            "2592000s",
+	}).Times(1).Return(nil, nil)
+
+	fv.fakeSys.EXPECT().PutPolicy("test-cluster-inside/worker", gomock.Any()).Times(1).Do(func(name, rules string) {
+		if exp := `path "test-cluster-inside/pki/k8s/sign/metrics-server"`; !strings.Contains(rules, exp) {
+			t.Errorf("policy '%s' should contain '%s': %s", name, exp, rules)
+		}
+	}).Return(nil)
+	fv.fakeSys.EXPECT().PutPolicy("test-cluster-inside/worker-creator", gomock.Any()).Times(1).Return(nil)
+
+	fv.fakeLogical.EXPECT().Read(gomock.Any()).AnyTimes().Return(nil, nil)
+	fv.fakeLogical.EXPECT().Write(gomock.Any(), gomock.Any()).AnyTimes().Return(nil, nil)
+	fv.fakeToken.EXPECT().CreateOrphan(gomock.Any()).Times(1).Return(&vault.Secret{
+		Auth: &vault.SecretAuth{
+			ClientToken: "my-new-token",
+		},
+	}, nil)
+
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if exp, act := 1, len(k.InitTokens()); exp != act {
+		t.Errorf("unexpected number of init tokens, exp=%d got=%d", exp, act)
+	}
+}
+
+func TestSpec_ComponentRoles(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	k := fv.Kubernetes()
+
+	if _, err := ParseComponentRoles([]string{"metrics-server"}); err == nil {
+		t.Error("expected an error parsing a role without class")
+	}
+
+	roles, err := ParseComponentRoles([]string{"metrics-server=worker", "cloud-controller-manager=master", "metrics-server=etcd"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exp, act := 2, len(roles); exp != act {
+		t.Fatalf("unexpected number of roles, exp=%d got=%d", exp, act)
+	}
+
+	if err := k.AddComponentRoles(roles); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	policies := map[string]string{}
+	for _, sp := range k.Spec().Policies {
+		policies[sp.Name] = k.policy(sp).Policy()
+	}
+	for policy, role := range map[string]string{
+		"worker": "metrics-server",
+		"etcd":   "metrics-server",
+		"master": "cloud-controller-manager",
+	} {
+		if exp := `path "test-cluster-inside/pki/k8s/sign/` + role + `"`; !strings.Contains(policies[policy], exp) {
+			t.Errorf("policy '%s' should contain '%s': %s", policy, exp, policies[policy])
+		}
+	}
+	if strings.Contains(policies["worker"], "cloud-controller-manager") {
+		t.Errorf("policy 'worker' should not allow cloud-controller-manager: %s", policies["worker"])
+	}
+
+	var role *SpecRole
+	for _, p := range k.Spec().PKIs {
+		for _, r := range p.Roles {
+			if p.Name == "k8s" && r.Name == "metrics-server" {
+				role = r
+			}
+		}
+	}
+	if role == nil {
+		t.Fatal("role metrics-server not found in k8s pki")
+	}
+	data := k.pkiRole(role).Data
+	if exp, act := "metrics-server,system:metrics-server", data["allowed_domains"]; exp != act {
+		t.Errorf("unexpected allowed_domains, exp=%s got=%v", exp, act)
+	}
+
+	// classes have to be existing policies
+	roles, err = ParseComponentRoles([]string{"metrics-server=nodes"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := k.AddComponentRoles(roles); err == nil {
+		t.Error("expected an error adding a role with unknown class")
+	}
+}
+
+func TestSpec_RoleKeyType(t *testing.T) {
+	k := New(nil, nil)
+
+	role := &SpecRole{Name: "etcd", Data: map[string]interface{}{"allow_any_name": true}}
+	// without a key type set the roles keep vault's key settings
+	if data := k.pkiRole(role).Data; data["key_type"] != nil || data["key_bits"] != nil {
+		t.Errorf("unexpected key settings of default roles: %v", data)
+	}
+
+	k.PKIKeyType = PKIKeyTypeRSA
+	k.PKIKeyBits = 2048
+	if data := k.pkiRole(role).Data; data["key_type"] != PKIKeyTypeRSA || data["key_bits"] != 2048 {
+		t.Errorf("unexpected key settings of RSA roles: %v", data)
+	}
+
+	k.PKIKeyType = PKIKeyTypeEC
+	k.PKIKeyBits = 384
+	if data := k.pkiRole(role).Data; data["key_type"] != PKIKeyTypeEC || data["key_bits"] != 384 {
+		t.Errorf("unexpected key settings of EC roles: %v", data)
+	}
+
+	k.PKIKeyType = PKIKeyTypeEd25519
+	if data := k.pkiRole(role).Data; data["key_type"] != PKIKeyTypeEd25519 || data["key_bits"] != nil {
+		t.Errorf("unexpected key settings of ed25519 roles: %v", data)
+	}
+
+	// roles can set their own key type
+	role.Data["key_type"] = PKIKeyTypeAny
+	if data := k.pkiRole(role).Data; data["key_type"] != PKIKeyTypeAny || data["key_bits"] != nil {
+		t.Errorf("unexpected key settings of role with key type: %v", data)
+	}
+
+	for _, c := range []struct {
+		keyType string
+		bits    int
+		valid   bool
+	}{
+		{PKIKeyTypeRSA, 2048, true},
+		{PKIKeyTypeRSA, 256, false},
+		{PKIKeyTypeEC, 256, true},
+		{PKIKeyTypeEC, 2048, false},
+		{PKIKeyTypeEd25519, 0, true},
+		{"dsa", 1024, false},
+	} {
+		if err := ValidatePKIKey(c.keyType, c.bits); (err == nil) != c.valid {
+			t.Errorf("%s %d: unexpected validation result: %v", c.keyType, c.bits, err)
+		}
+	}
+}
+
+// Roles written without key settings keep the ones they have in vault, as
+// writing a role resets the settings it leaves out
+func TestSpec_EnsureRoleKeepsKey(t *testing.T) {
+	fv := NewFakeVault(t)
+	defer fv.Finish()
+	k := fv.Kubernetes()
+
+	roles := []*SpecRole{&SpecRole{Name: "kubelet", Data: map[string]interface{}{"allow_any_name": true}}}
+	path := "test-cluster-inside/pki/k8s/roles/kubelet"
+
+	gomock.InOrder(
+		fv.fakeLogical.EXPECT().Read(path).Return(&vault.Secret{Data: map[string]interface{}{
+			"allow_any_name": true,
+			"key_type":       "ec",
+			"key_bits":       256,
+		}}, nil),
+		fv.fakeLogical.EXPECT().Write(path, map[string]interface{}{
+			"allow_any_name": true,
+			"key_type":       "ec",
+			"key_bits":       256,
+		}).Return(nil, nil),
+		// with a key type set the role isn't read
+		fv.fakeLogical.EXPECT().Write(path, map[string]interface{}{
+			"allow_any_name": true,
+			"key_type":       "rsa",
+			"key_bits":       4096,
+		}).Return(nil, nil),
+	)
+
+	if err := k.ensurePKIRoles(k.PKI("k8s"), roles); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	k.PKIKeyType = PKIKeyTypeRSA
+	k.PKIKeyBits = 4096
+	if err := k.ensurePKIRoles(k.PKI("k8s"), roles); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package kubernetes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagStatusFormat = "format"
+const FlagStatusWarnBefore = "warn-before"
+
+const StatusOK = "ok"
+const StatusWarning = "warning"
+const StatusError = "error"
+
+// The result of checking a single vault object
+type StatusCheck struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// The health of everything setup writes for a cluster
+type Status struct {
+	ClusterID string         `json:"clusterID"`
+	Checks    []*StatusCheck `json:"checks"`
+}
+
+// Check that everything setup writes exists and matches, and that CAs and
+// init tokens are valid for longer than warnBefore. Nothing gets written.
+func (k *Kubernetes) Status(warnBefore time.Duration) (*Status, error) {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return nil, fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	status := &Status{ClusterID: k.clusterID}
+
+	// CAs, init tokens and the service account key get checked in depth
+	var health []*StatusCheck
+	for _, p := range k.Spec().PKIs {
+		health = append(health, k.PKI(p.Name).statusCAs(warnBefore)...)
+	}
+	if k.Bootstrap != BootstrapAppRole {
+		for _, initToken := range k.specInitTokens() {
+			health = append(health, initToken.status(warnBefore))
+		}
+	}
+	health = append(health, k.secretsGeneric.statusServiceAccountKey())
+
+	covered := map[string]bool{}
+	for _, c := range health {
+		covered[c.Kind+" "+c.Path] = true
+	}
+
+	// mounts, tune TTLs, roles, policies, token roles and auth backends
+	plan, err := k.Plan()
+	if plan != nil {
+		for _, change := range plan.Changes {
+			if !covered[change.Kind+" "+change.Path] {
+				status.add(statusFromChange(change))
+			}
+		}
+	}
+	if merr, ok := err.(*multierror.Error); ok {
+		for _, err := range merr.Errors {
+			status.add(&StatusCheck{Kind: "plan", Path: k.clusterID, Status: StatusError, Message: err.Error()})
+		}
+	} else if err != nil {
+		status.add(&StatusCheck{Kind: "plan", Path: k.clusterID, Status: StatusError, Message: err.Error()})
+	}
+
+	status.Checks = append(status.Checks, health...)
+
+	return status, nil
+}
+
+func (s *Status) add(check *StatusCheck) {
+	s.Checks = append(s.Checks, check)
+}
+
+// Whether any check is not ok
+func (s *Status) HasProblems() bool {
+	for _, c := range s.Checks {
+		if c.Status != StatusOK {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Status) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func (s *Status) String() string {
+	var buf bytes.Buffer
+	count := map[string]int{}
+
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "STATUS\tKIND\tPATH\tMESSAGE\n")
+	for _, c := range s.Checks {
+		count[c.Status]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Status, c.Kind, c.Path, c.Message)
+	}
+	w.Flush()
+	fmt.Fprintf(&buf, "%d ok, %d warnings, %d errors for cluster '%s'.\n", count[StatusOK], count[StatusWarning], count[StatusError], s.ClusterID)
+
+	return buf.String()
+}
+
+// Objects setup would create are missing, ones it would update differ
+func statusFromChange(c *Change) *StatusCheck {
+	check := &StatusCheck{Kind: c.Kind, Path: c.Path, Status: StatusOK}
+
+	switch c.Action {
+	case ActionCreate:
+		check.Status = StatusError
+		check.Message = "missing"
+	case ActionUpdate:
+		check.Status = StatusError
+		var fields []string
+		for _, f := range c.Fields {
+			fields = append(fields, f.Field)
+		}
+		check.Message = "differs: " + strings.Join(fields, ", ")
+	}
+
+	return check
+}
+
+// Status of a validity ending at notAfter
+func statusExpiry(check *StatusCheck, notAfter time.Time, warnBefore time.Duration) {
+	left := notAfter.Sub(time.Now())
+	switch {
+	case left <= 0:
+		check.Status = StatusError
+		check.Message = fmt.Sprintf("expired at %s", notAfter.UTC().Format(time.RFC3339))
+	case left < warnBefore:
+		check.Status = StatusWarning
+		check.Message = "expires in " + formatLifetime(left)
+	default:
+		check.Message = "expires in " + formatLifetime(left)
+	}
+}
+
+// Lifetimes of a day or more in days, shorter ones to the minute
+func formatLifetime(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day {
+		return fmt.Sprintf("%dd", int(d/day))
+	}
+	return d.Round(time.Minute).String()
+}
+
+// Check the lifetime of the CAs of all generations
+func (p *PKI) statusCAs(warnBefore time.Duration) []*StatusCheck {
+	if err := p.loadGenerations(); err != nil {
+		return []*StatusCheck{&StatusCheck{Kind: KindCA, Path: p.Path(), Status: StatusError, Message: err.Error()}}
+	}
+
+	var checks []*StatusCheck
+	for _, path := range p.generationPaths() {
+		check := &StatusCheck{Kind: KindCA, Path: filepath.Join(path, "cert", "ca"), Status: StatusOK}
+		checks = append(checks, check)
+
+		s, err := p.kubernetes.vaultClient.Logical().Read(check.Path)
+		if err != nil {
+			check.Status = StatusError
+			check.Message = fmt.Sprintf("error reading CA: %v", err)
+			continue
+		}
+		certPEM, err := secretString(s, "certificate")
+		if err != nil || certPEM == "" {
+			check.Status = StatusError
+			check.Message = "no CA certificate"
+			continue
+		}
+		cert, err := parseCert(certPEM)
+		if err != nil {
+			check.Status = StatusError
+			check.Message = fmt.Sprintf("error parsing CA: %v", err)
+			continue
+		}
+
+		statusExpiry(check, cert.NotAfter, warnBefore)
+	}
+
+	return checks
+}
+
+// Check the stored init token is known to vault and its TTL
+func (i *InitToken) status(warnBefore time.Duration) *StatusCheck {
+	g := i.secretsGeneric()
+	check := &StatusCheck{Kind: KindInitToken, Path: g.initTokenPath(i.Role), Status: StatusError}
+
+	token, err := g.InitTokenStore(i.Role)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	if token == "" {
+		check.Message = "missing"
+		return check
+	}
+
+	s, err := i.kubernetes.vaultClient.Auth().Token().Lookup(token)
+	if err != nil {
+		if isBadToken(err) {
+			check.Message = "unknown to vault"
+		} else {
+			check.Message = fmt.Sprintf("error looking up init token: %v", err)
+		}
+		return check
+	}
+	if s == nil {
+		check.Message = "unknown to vault"
+		return check
+	}
+
+	check.Status = StatusOK
+	number, ok := s.Data["ttl"].(json.Number)
+	if !ok {
+		return check
+	}
+	ttl, err := number.Int64()
+	if err != nil || ttl == 0 {
+		check.Message = "never expires"
+		return check
+	}
+	statusExpiry(check, time.Now().Add(time.Duration(ttl)*time.Second), warnBefore)
+
+	return check
+}
+
+// Check the service account key exists and can be parsed
+func (g *Generic) statusServiceAccountKey() *StatusCheck {
+	check := &StatusCheck{Kind: KindSecret, Path: g.serviceAccountsPath(), Status: StatusError}
+
+	keys, _, err := g.readServiceAccountKeys()
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	if keys == nil {
+		check.Message = "missing"
+		return check
+	}
+	if _, err := publicKeyPEM(keys.Key); err != nil {
+		check.Message = fmt.Sprintf("invalid %s key: %v", keys.KeyType, err)
+		return check
+	}
+
+	check.Status = StatusOK
+	check.Message = fmt.Sprintf("%s key", keys.KeyType)
+	return check
+}
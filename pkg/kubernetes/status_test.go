@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func statusOf(s *Status, kind, path string) *StatusCheck {
+	for _, c := range s.Checks {
+		if c.Kind == kind && c.Path == path {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestStatus(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+
+	status, err := k.Status(time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !status.HasProblems() {
+		t.Error("expected problems before setup")
+	}
+
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	status, err = k.Status(time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.HasProblems() {
+		t.Errorf("unexpected problems after setup:\n%s", status)
+	}
+	if c := statusOf(status, KindCA, "test/pki/k8s/cert/ca"); c == nil || c.Status != StatusOK {
+		t.Errorf("unexpected status of the k8s CA: %+v", c)
+	}
+
+	// CAs and init tokens expire before the warning period
+	status, err = k.Status(time.Hour * 24 * 365 * 30)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, kind := range []string{KindCA, KindInitToken} {
+		for _, c := range status.Checks {
+			if c.Kind == kind && c.Status != StatusWarning {
+				t.Errorf("expected a warning for %s '%s', got %s", c.Kind, c.Path, c.Status)
+			}
+		}
+	}
+
+	// accidentally deleted token roles and revoked init tokens
+	if _, err := vault.Client().Logical().Delete("auth/token/roles/test-master"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token, err := k.secretsGeneric.InitTokenStore("worker")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := vault.Client().Auth().Token().RevokeOrphan(token); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	status, err = k.Status(time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c := statusOf(status, KindTokenRole, "auth/token/roles/test-master"); c == nil || c.Status != StatusError || c.Message != "missing" {
+		t.Errorf("unexpected status of the deleted token role: %+v", c)
+	}
+	if c := statusOf(status, KindInitToken, "test/secrets/init_token_worker"); c == nil || c.Status != StatusError || c.Message != "unknown to vault" {
+		t.Errorf("unexpected status of the revoked init token: %+v", c)
+	}
+}
@@ -0,0 +1,176 @@
+package kubernetes
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const FlagKeepCA = "keep-ca"
+const FlagKeepSecrets = "keep-secrets"
+const FlagConfirm = "yes"
+
+type TeardownOptions struct {
+	// Keep the PKI mounts including their CAs
+	KeepCA bool
+	// Keep the secrets mount including the service account key
+	KeepSecrets bool
+}
+
+// Remove everything setup created for the cluster from vault
+func (k *Kubernetes) Teardown(opts TeardownOptions) error {
+	if err := isValidClusterID(k.clusterID); err != nil {
+		return fmt.Errorf("error '%s' is not a valid clusterID", k.clusterID)
+	}
+
+	var result error
+
+	if err := k.secretsGeneric.revokeInitTokens(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := k.deleteTokenRoles(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := k.deleteAppRoles(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := k.disableCertAuth(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := k.deletePolicies(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if !opts.KeepCA {
+		if err := k.unmountPKIs(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if !opts.KeepSecrets {
+		for _, path := range []string{k.secretsGeneric.Path(), k.secretsGeneric.backupPath()} {
+			if err := k.unmount(path); err != nil {
+				result = multierror.Append(result, err)
+			}
+		}
+	}
+
+	return result
+}
+
+// Delete token roles, which have been created for this cluster
+func (k *Kubernetes) deleteTokenRoles() error {
+	var result error
+
+	path := "auth/token/roles"
+	roles, err := listKeys(k.vaultClient, path)
+	if err != nil {
+		return err
+	}
+
+	for _, role := range roles {
+		if !strings.HasPrefix(role, k.clusterID+"-") {
+			continue
+		}
+
+		// the prefix is shared with clusters like '<cluster>-2', so make sure
+		// the role belongs to this cluster
+		rolePath := filepath.Join(path, role)
+		s, err := k.vaultClient.Logical().Read(rolePath)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("error reading token role '%s': %v", rolePath, err))
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		if suffix, ok := s.Data["path_suffix"].(string); !ok || !strings.HasPrefix(suffix, k.clusterID+"/") {
+			k.Log.Debugf("Skipping token role of other cluster: %s", rolePath)
+			continue
+		}
+
+		if _, err := k.vaultClient.Logical().Delete(rolePath); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting token role '%s': %v", rolePath, err))
+			continue
+		}
+		k.Log.Infof("Deleted token role '%s'", rolePath)
+	}
+
+	return result
+}
+
+// Delete all policies of the cluster, including the init token policies
+func (k *Kubernetes) deletePolicies() error {
+	var result error
+
+	policies, err := k.vaultClient.Sys().ListPolicies()
+	if err != nil {
+		return fmt.Errorf("error listing policies: %v", err)
+	}
+
+	for _, policy := range policies {
+		if !strings.HasPrefix(policy, k.clusterID+"/") {
+			continue
+		}
+
+		if err := k.vaultClient.Sys().DeletePolicy(policy); err != nil {
+			result = multierror.Append(result, fmt.Errorf("error deleting policy '%s': %v", policy, err))
+			continue
+		}
+		k.Log.Infof("Deleted policy '%s'", policy)
+	}
+
+	return result
+}
+
+// Unmount all PKIs below the cluster's pki path
+func (k *Kubernetes) unmountPKIs() error {
+	var result error
+
+	mounts, err := k.vaultClient.Sys().ListMounts()
+	if err != nil {
+		return fmt.Errorf("error listing mounts: %v", err)
+	}
+
+	prefix := filepath.Join(k.Path(), "pki") + "/"
+	var paths []string
+	for path, mount := range mounts {
+		if strings.HasPrefix(path, prefix) && mount.Type == "pki" {
+			paths = append(paths, filepath.Clean(path))
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if err := k.unmount(path); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	return result
+}
+
+func (k *Kubernetes) unmount(path string) error {
+	mount, err := GetMountByPath(k.vaultClient, path)
+	if err != nil {
+		return err
+	}
+	if mount == nil {
+		k.Log.Debugf("Mount '%s' not existing", path)
+		return nil
+	}
+
+	if err := k.vaultClient.Sys().Unmount(path); err != nil {
+		return fmt.Errorf("error unmounting '%s': %v", path, err)
+	}
+	k.Log.Infof("Unmounted '%s'", path)
+
+	return nil
+}
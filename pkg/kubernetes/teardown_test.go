@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/jetstack/vault-helper/pkg/testing/vault_dev"
+)
+
+func TestTeardown(t *testing.T) {
+	vault := vault_dev.New()
+	if err := vault.Start(); err != nil {
+		t.Fatalf("unable to initialise vault dev server for integration tests: %v", err)
+	}
+	defer vault.Stop()
+
+	k := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	k.SetClusterID("test")
+	if err := k.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+	tokens := k.InitTokens()
+
+	// a cluster sharing the prefix must not be touched
+	other := New(vault.Client(), logrus.NewEntry(logrus.New()))
+	other.SetClusterID("test-2")
+	if err := other.Ensure(); err != nil {
+		t.Fatalf("error ensuring: %v", err)
+	}
+
+	if err := k.Teardown(TeardownOptions{KeepCA: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, path := range []string{"test/pki/k8s", "test-2/pki/k8s", "test-2/secrets"} {
+		if mount, err := GetMountByPath(k.vaultClient, path); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		} else if mount == nil {
+			t.Errorf("expected mount '%s' to be kept", path)
+		}
+	}
+	if mount, err := GetMountByPath(k.vaultClient, "test/secrets"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if mount != nil {
+		t.Error("expected secrets mount to be removed")
+	}
+
+	for role, token := range tokens {
+		if _, err := vault.Client().Auth().Token().Lookup(token); err == nil {
+			t.Errorf("expected init token '%s' to be revoked", role)
+		}
+	}
+
+	for _, path := range []string{"auth/token/roles/test-master", "auth/token/roles/test-2-master"} {
+		s, err := vault.Client().Logical().Read(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp, act := path == "auth/token/roles/test-2-master", s != nil; exp != act {
+			t.Errorf("unexpected existence of token role '%s', exp=%t got=%t", path, exp, act)
+		}
+	}
+
+	for _, policy := range []string{"test/master", "test/master-creator", "test-2/master"} {
+		rules, err := vault.Client().Sys().GetPolicy(policy)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exp, act := policy == "test-2/master", rules != ""; exp != act {
+			t.Errorf("unexpected existence of policy '%s', exp=%t got=%t", policy, exp, act)
+		}
+	}
+
+	// remove the rest
+	if err := k.Teardown(TeardownOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mount, err := GetMountByPath(k.vaultClient, "test/pki/k8s"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if mount != nil {
+		t.Error("expected pki mount to be removed")
+	}
+}
@@ -0,0 +1,165 @@
+package kubernetes
+
+import (
+	"strings"
+	"sync"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// A vault client caching calls, which are shared by all clusters converging
+// in the same run. Mount changes invalidate the cached mounts and auth
+// backends.
+type cachedVault struct {
+	Vault
+	sys *cachedVaultSys
+}
+
+// Mounts can be changed by logical writes as well, e.g. when options are
+// needed
+type cachedVaultLogical struct {
+	VaultLogical
+	sys *cachedVaultSys
+}
+
+type cachedVaultSys struct {
+	VaultSys
+
+	lock   sync.Mutex
+	mounts map[string]*vault.MountOutput
+	auths  map[string]*vault.AuthMount
+
+	// held while enabling auth backends, clusters share the AppRole one
+	authLock sync.Mutex
+}
+
+var _ Vault = &cachedVault{}
+var _ VaultSys = &cachedVaultSys{}
+var _ VaultLogical = &cachedVaultLogical{}
+
+// Create a vault client to share between clusters, which caches the mounts
+func NewCachedVault(vaultClient *vault.Client) Vault {
+	v := realVaultFromAPI(vaultClient)
+	return &cachedVault{
+		Vault: v,
+		sys:   &cachedVaultSys{VaultSys: v.Sys()},
+	}
+}
+
+func (c *cachedVault) Sys() VaultSys {
+	return c.sys
+}
+
+func (c *cachedVault) Logical() VaultLogical {
+	return &cachedVaultLogical{VaultLogical: c.Vault.Logical(), sys: c.sys}
+}
+
+func (c *cachedVaultLogical) Write(path string, data map[string]interface{}) (*vault.Secret, error) {
+	if changesMounts(path) {
+		defer c.sys.invalidate()
+	}
+	return c.VaultLogical.Write(path, data)
+}
+
+func (c *cachedVaultLogical) Delete(path string) (*vault.Secret, error) {
+	if changesMounts(path) {
+		defer c.sys.invalidate()
+	}
+	return c.VaultLogical.Delete(path)
+}
+
+func changesMounts(path string) bool {
+	path = strings.TrimPrefix(path, "/")
+	return strings.HasPrefix(path, "sys/mounts") || strings.HasPrefix(path, "sys/remount")
+}
+
+func (c *cachedVaultSys) ListMounts() (map[string]*vault.MountOutput, error) {
+	// the lock is held while listing, so an invalidation waits for a listing
+	// started before the mounts changed
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.mounts == nil {
+		mounts, err := c.VaultSys.ListMounts()
+		if err != nil {
+			return nil, err
+		}
+		c.mounts = mounts
+	}
+
+	// callers get their own copy of the map
+	mounts := make(map[string]*vault.MountOutput, len(c.mounts))
+	for path, mount := range c.mounts {
+		mounts[path] = mount
+	}
+	return mounts, nil
+}
+
+func (c *cachedVaultSys) ListAuth() (map[string]*vault.AuthMount, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.auths == nil {
+		auths, err := c.VaultSys.ListAuth()
+		if err != nil {
+			return nil, err
+		}
+		c.auths = auths
+	}
+
+	auths := make(map[string]*vault.AuthMount, len(c.auths))
+	for path, auth := range c.auths {
+		auths[path] = auth
+	}
+	return auths, nil
+}
+
+// Auth backends are enabled one at a time, a cluster finding the backend
+// enabled by another one in the meantime is done
+func (c *cachedVaultSys) EnableAuth(path, authType, desc string) error {
+	c.authLock.Lock()
+	defer c.authLock.Unlock()
+
+	auths, err := c.ListAuth()
+	if err != nil {
+		return err
+	}
+	if auth, ok := auths[strings.Trim(path, "/")+"/"]; ok && auth.Type == authType {
+		return nil
+	}
+
+	defer c.invalidate()
+	return c.VaultSys.EnableAuth(path, authType, desc)
+}
+
+func (c *cachedVaultSys) DisableAuth(path string) error {
+	defer c.invalidate()
+	return c.VaultSys.DisableAuth(path)
+}
+
+func (c *cachedVaultSys) Mount(path string, mountInfo *vault.MountInput) error {
+	defer c.invalidate()
+	return c.VaultSys.Mount(path, mountInfo)
+}
+
+func (c *cachedVaultSys) Unmount(path string) error {
+	defer c.invalidate()
+	return c.VaultSys.Unmount(path)
+}
+
+func (c *cachedVaultSys) Remount(from, to string) error {
+	defer c.invalidate()
+	return c.VaultSys.Remount(from, to)
+}
+
+func (c *cachedVaultSys) TuneMount(path string, config vault.MountConfigInput) error {
+	defer c.invalidate()
+	return c.VaultSys.TuneMount(path, config)
+}
+
+func (c *cachedVaultSys) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.mounts = nil
+	c.auths = nil
+}
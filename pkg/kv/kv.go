@@ -0,0 +1,124 @@
+package kv
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Reads from vault, satisfied by the logical clients
+type Logical interface {
+	Read(path string) (*vault.Secret, error)
+}
+
+// Get the KV mount a path belongs to and its version. Vault versions without
+// KV version 2 don't know the endpoint or deny it to tokens without sudo, so
+// their mounts are version 1. Newer versions only deny tokens that can't
+// access the path at all, which fail reading it anyway.
+func MountVersion(l Logical, path string) (mountPath string, version int, err error) {
+	path = strings.Trim(path, "/")
+
+	s, err := l.Read(filepath.Join("sys/internal/ui/mounts", path))
+	if err != nil && strings.Contains(err.Error(), "Code: 403") {
+		return "", 1, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("error looking up mount of '%s': %v", path, err)
+	}
+	if s == nil || s.Data == nil {
+		return "", 1, nil
+	}
+
+	mountPath, _ = s.Data["path"].(string)
+	mountPath = strings.Trim(mountPath, "/")
+
+	version = 1
+	if options, ok := s.Data["options"].(map[string]interface{}); ok {
+		switch v := options["version"].(type) {
+		case string:
+			if v == "2" {
+				version = 2
+			}
+		case json.Number:
+			if v.String() == "2" {
+				version = 2
+			}
+		}
+	}
+
+	return mountPath, version, nil
+}
+
+// The path of the data of a key in a mount
+func DataPath(mountPath string, version int, key string) string {
+	if version == 2 {
+		return filepath.Join(mountPath, "data", key)
+	}
+	return filepath.Join(mountPath, key)
+}
+
+// The path of the metadata of a key in a mount, which lists and deletes all
+// versions. In version 1 mounts it is the path of the data.
+func MetadataPath(mountPath string, version int, key string) string {
+	if version == 2 {
+		return filepath.Join(mountPath, "metadata", key)
+	}
+	return filepath.Join(mountPath, key)
+}
+
+// Turn a path as written by users into the path of its data, which adds the
+// data/ prefix in version 2 mounts unless the path has one already
+func APIPath(path, mountPath string, version int) string {
+	path = strings.Trim(path, "/")
+	if version != 2 || mountPath == "" {
+		return path
+	}
+
+	key := strings.TrimPrefix(path, mountPath+"/")
+	if strings.HasPrefix(key, "data/") || strings.HasPrefix(key, "metadata/") {
+		return path
+	}
+
+	return DataPath(mountPath, version, key)
+}
+
+// Turn the nested response of a version 2 data read into a secret holding
+// the data itself. Deleted versions have no data and return nil.
+func Unwrap(s *vault.Secret) *vault.Secret {
+	if s == nil {
+		return nil
+	}
+
+	data, ok := s.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	unwrapped := *s
+	unwrapped.Data = data
+	return &unwrapped
+}
+
+// The version of the data of a version 2 response, 0 if unknown
+func Version(s *vault.Secret) int {
+	if s == nil {
+		return 0
+	}
+
+	metadata, ok := s.Data["metadata"].(map[string]interface{})
+	if !ok {
+		// write responses hold the metadata as data
+		metadata = s.Data
+	}
+
+	if n, ok := metadata["version"].(json.Number); ok {
+		if v, err := n.Int64(); err == nil {
+			return int(v)
+		}
+	}
+
+	return 0
+}
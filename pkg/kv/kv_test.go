@@ -0,0 +1,113 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+type fakeLogical map[string]*vault.Secret
+
+func (f fakeLogical) Read(path string) (*vault.Secret, error) {
+	return f[path], nil
+}
+
+type deniedLogical struct{}
+
+func (deniedLogical) Read(path string) (*vault.Secret, error) {
+	return nil, errors.New("Error making API request.\n\nCode: 403. Errors:\n\n* permission denied")
+}
+
+func TestMountVersion(t *testing.T) {
+	l := fakeLogical{
+		"sys/internal/ui/mounts/c1/secrets/service-accounts": &vault.Secret{Data: map[string]interface{}{
+			"path":    "c1/secrets/",
+			"type":    "kv",
+			"options": map[string]interface{}{"version": "2"},
+		}},
+		"sys/internal/ui/mounts/c2/secrets/service-accounts": &vault.Secret{Data: map[string]interface{}{
+			"path":    "c2/secrets/",
+			"type":    "generic",
+			"options": nil,
+		}},
+	}
+
+	for _, c := range []struct {
+		path      string
+		mountPath string
+		version   int
+	}{
+		{"/c1/secrets/service-accounts", "c1/secrets", 2},
+		{"c2/secrets/service-accounts", "c2/secrets", 1},
+		// older vaults don't know the endpoint
+		{"c3/secrets/service-accounts", "", 1},
+	} {
+		mountPath, version, err := MountVersion(l, c.path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if mountPath != c.mountPath || version != c.version {
+			t.Errorf("unexpected mount of '%s', exp=%s (v%d) got=%s (v%d)", c.path, c.mountPath, c.version, mountPath, version)
+		}
+	}
+
+	// older vaults deny the endpoint to tokens without sudo
+	if _, version, err := MountVersion(deniedLogical{}, "c1/secrets/service-accounts"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	} else if version != 1 {
+		t.Errorf("unexpected version, exp=1 got=%d", version)
+	}
+}
+
+func TestAPIPath(t *testing.T) {
+	for _, c := range []struct {
+		path    string
+		version int
+		exp     string
+	}{
+		{"c1/secrets/service-accounts", 1, "c1/secrets/service-accounts"},
+		{"/c1/secrets/service-accounts", 2, "c1/secrets/data/service-accounts"},
+		{"c1/secrets/data/service-accounts", 2, "c1/secrets/data/service-accounts"},
+		{"c1/secrets/metadata/service-accounts", 2, "c1/secrets/metadata/service-accounts"},
+	} {
+		if act := APIPath(c.path, "c1/secrets", c.version); act != c.exp {
+			t.Errorf("unexpected path of '%s' (v%d), exp=%s got=%s", c.path, c.version, c.exp, act)
+		}
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	s := &vault.Secret{Data: map[string]interface{}{
+		"data":     map[string]interface{}{"key": "value"},
+		"metadata": map[string]interface{}{"version": json.Number("3")},
+	}}
+
+	if exp, act := 3, Version(s); exp != act {
+		t.Errorf("unexpected version, exp=%d got=%d", exp, act)
+	}
+
+	unwrapped := Unwrap(s)
+	if unwrapped == nil || unwrapped.Data["key"] != "value" {
+		t.Errorf("unexpected unwrapped secret: %+v", unwrapped)
+	}
+
+	// deleted versions have no data
+	deleted := &vault.Secret{Data: map[string]interface{}{
+		"data":     nil,
+		"metadata": map[string]interface{}{"version": json.Number("4")},
+	}}
+	if Unwrap(deleted) != nil {
+		t.Error("expected no secret for a deleted version")
+	}
+	if exp, act := 4, Version(deleted); exp != act {
+		t.Errorf("unexpected version, exp=%d got=%d", exp, act)
+	}
+
+	// write responses hold the metadata as data
+	written := &vault.Secret{Data: map[string]interface{}{"version": json.Number("5")}}
+	if exp, act := 5, Version(written); exp != act {
+		t.Errorf("unexpected version, exp=%d got=%d", exp, act)
+	}
+}
@@ -9,11 +9,13 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	vault "github.com/hashicorp/vault/api"
 
 	"github.com/jetstack/vault-helper/pkg/instanceToken"
+	"github.com/jetstack/vault-helper/pkg/kv"
 )
 
 const FlagOutputPath = "dest-path"
@@ -33,11 +35,21 @@ type Read struct {
 }
 
 func (r *Read) RunRead() error {
-	//Read vault
-	sec, err := r.InstanceToken().VaultClient().Logical().Read(r.VaultPath())
+	//Read vault, paths in KV version 2 mounts are read below data/
+	logical := r.InstanceToken().VaultClient().Logical()
+	mountPath, version, err := kv.MountVersion(logical, r.VaultPath())
+	if err != nil {
+		return err
+	}
+	path := kv.APIPath(r.VaultPath(), mountPath, version)
+
+	sec, err := logical.Read(path)
 	if err != nil {
 		return fmt.Errorf("error reading from vault: %v", err)
 	}
+	if version == 2 && strings.HasPrefix(path, mountPath+"/data/") {
+		sec = kv.Unwrap(sec)
+	}
 
 	if sec == nil {
 		return errors.New("vault returned nothing")